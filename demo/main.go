@@ -2,8 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -30,6 +30,10 @@ type ProductResponse struct {
 	Error   string            `json:"error,omitempty"`
 }
 
+// The PIR scheme that serves every query, picked at startup (see
+// pir.DemoScheme).
+var scheme pir.CheckedPIR
+
 func simpleEncrypt(data string, key string) string {
 	encrypted := make([]byte, len(data))
 	for i := 0; i < len(data); i++ {
@@ -50,74 +54,10 @@ func stringToUint64Hash(s string) uint64 {
 	return hash
 }
 
-type MockT struct {
-	failed bool
-}
-
-func (t *MockT) Fatalf(format string, args ...interface{}) {
-	t.failed = true
-	fmt.Printf("ERROR: %s\n", fmt.Sprintf(format, args...))
-	panic(fmt.Sprintf(format, args...))
-}
-
-func (t *MockT) Failed() bool {
-	return t.failed
-}
-
-func captureQueryOutput(productID uint64) (string, error) {
-	mockT := &MockT{}
-
-	_, pirKeys, _, _, err := pir.LoadDatabaseOnce()
-	if err != nil {
-		return "", fmt.Errorf("database error: %v", err)
-	}
-
-	found := false
-	for _, key := range pirKeys {
-		if key == productID {
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return "", fmt.Errorf("product with ID %d not found in database", productID)
-	}
-
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	var output string
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("query failed: %v", r)
-			}
-		}()
-		pir.QueryProductByID(mockT, productID, 0, 0)
-	}()
-
-	w.Close()
-	os.Stdout = old
-
-	var buf strings.Builder
-	io.Copy(&buf, r)
-	output = buf.String()
-
-	fmt.Print(output)
-
-	if mockT.failed || err != nil {
-		return "", fmt.Errorf("product not found")
-	}
-
-	if len(output) == 0 {
-		return "", fmt.Errorf("no product data found")
-	}
-
-	return output, nil
-}
+// Returned by findProductByBarcode and executeDirectLookup for a product
+// that is not in the DB. It does not name the barcode, so that logging it
+// does not record which products were looked up in vain.
+var errProductNotFound = errors.New("product not found")
 
 func findProductByBarcode(barcode string) (uint64, error) {
 	_, pirKeys, _, _, err := pir.LoadDatabaseOnce()
@@ -140,38 +80,15 @@ func findProductByBarcode(barcode string) (uint64, error) {
 		}
 	}
 
-	return 0, fmt.Errorf("barcode %s not found in database", barcode)
+	return 0, errProductNotFound
 }
 
-func extractProductInfo(output string, barcode string) (map[string]string, error) {
-	lines := strings.Split(output, "\n")
-
+// Drops the empty columns of a product record, and fails if none are left.
+func productFields(record map[string]string) (map[string]string, error) {
 	productData := make(map[string]string)
-	inRecordSection := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		if strings.Contains(line, "=== Retrieved Full Record for Product ID") {
-			inRecordSection = true
-			continue
-		}
-
-		if strings.Contains(line, "=== End Record ===") {
-			inRecordSection = false
-			break
-		}
-
-		if inRecordSection && strings.Contains(line, ":") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				if value != "<missing>" && value != "" {
-					productData[key] = value
-				}
-			}
+	for key, value := range record {
+		if value != "" {
+			productData[key] = value
 		}
 	}
 
@@ -183,13 +100,6 @@ func extractProductInfo(output string, barcode string) (map[string]string, error
 }
 
 func testDatabaseConnection() {
-	mockT := &MockT{}
-
-	defer func() {
-		if r := recover(); r != nil {
-		}
-	}()
-
 	_, pirKeys, _, _, err := pir.LoadDatabaseOnce()
 	if err != nil {
 		return
@@ -200,7 +110,9 @@ func testDatabaseConnection() {
 	}
 
 	if len(pirKeys) > 0 {
-		pir.QueryProductByID(mockT, pirKeys[0], 0, 0)
+		if err := pir.QueryProduct(scheme, pirKeys[0], 0, 0); err != nil {
+			fmt.Printf("  - Test query failed: %v\n", err)
+		}
 	}
 }
 
@@ -230,21 +142,9 @@ func handlePIRQuery(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("REAL PIR Query for barcode: %s\n", queryData.Barcode)
 
-	productID, err := findProductByBarcode(queryData.Barcode)
-	if err != nil {
-		fmt.Printf("ERROR: %v\n", err)
-		errorResponse := PIRQueryResponse{
-			Encrypted: true,
-			Result:    simpleEncrypt(`{"error":"Product not found"}`, "simplepir"),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(errorResponse)
-		return
-	}
-
 	start := time.Now()
 
-	output, err := executeRealPIRQuery(productID)
+	record, metrics, err := pir.QueryProductByBarcode(r.Context(), scheme, queryData.Barcode)
 	if err != nil {
 		fmt.Printf("ERROR: Real PIR query failed: %v\n", err)
 		errorResponse := PIRQueryResponse{
@@ -257,9 +157,11 @@ func handlePIRQuery(w http.ResponseWriter, r *http.Request) {
 	}
 
 	elapsed := time.Since(start)
-	fmt.Printf("REAL PIR query completed in %v\n", elapsed)
+	fmt.Printf("REAL %s query completed in %v (answer %v, upload %.2f KB, download %.2f KB)\n",
+		scheme.Name(), elapsed, metrics.Answer.Time, float64(metrics.Query.Bytes)/1024.0,
+		float64(metrics.Answer.Bytes)/1024.0)
 
-	allProductData, err := extractProductInfo(output, queryData.Barcode)
+	allProductData, err := productFields(record)
 	if err != nil {
 		fmt.Printf("ERROR: Failed to extract product info: %v\n", err)
 		errorResponse := PIRQueryResponse{
@@ -294,41 +196,48 @@ func handlePIRQuery(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func executeRealPIRQuery(productID uint64) (string, error) {
-	mockT := &MockT{}
-
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	var output string
-	var err error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("PIR query failed: %v", r)
-			}
-		}()
-		pir.QueryProductByID(mockT, productID, 0, 0)
-	}()
+// Checks whether a barcode is in the DB with a PIR query over the membership
+// filter, before any record is fetched. The browser has no PIR client yet, so
+// this handler decrypts the barcode with the fixed demo key and runs both the
+// client and the server half of pir.ProductExists in-process: it measures
+// what a private existence check costs, but hides nothing from this server.
+func handlePIRExists(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		http.Error(w, "Query parameter required", http.StatusBadRequest)
+		return
+	}
 
-	w.Close()
-	os.Stdout = old
+	decryptedQuery := simpleDecrypt(query, "simplepir")
 
-	var buf strings.Builder
-	io.Copy(&buf, r)
-	output = buf.String()
+	var queryData struct {
+		Barcode string `json:"barcode"`
+	}
+	if err := json.Unmarshal([]byte(decryptedQuery), &queryData); err != nil {
+		http.Error(w, "Invalid encrypted query", http.StatusBadRequest)
+		return
+	}
 
-	if mockT.failed || err != nil {
-		return "", fmt.Errorf("PIR query failed")
+	start := time.Now()
+	var metrics pir.Metrics
+	exists, err := pir.ProductExists(r.Context(), scheme, queryData.Barcode, &metrics)
+	if err != nil {
+		fmt.Printf("ERROR: PIR membership check failed: %v\n", err)
+		http.Error(w, "Membership check failed", http.StatusInternalServerError)
+		return
 	}
+	fmt.Printf("REAL %s membership check completed in %v (answer %v, upload %.2f KB, download %.2f KB)\n",
+		scheme.Name(), time.Since(start), metrics.Answer.Time, float64(metrics.Query.Bytes)/1024.0,
+		float64(metrics.Answer.Bytes)/1024.0)
 
-	if len(output) == 0 {
-		return "", fmt.Errorf("no PIR output generated")
+	responseJSON, _ := json.Marshal(map[string]bool{"exists": exists})
+	response := PIRQueryResponse{
+		Encrypted: true,
+		Result:    simpleEncrypt(string(responseJSON), "simplepir"),
 	}
 
-	return output, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func handlePIRProtocol(w http.ResponseWriter, r *http.Request) {
@@ -364,14 +273,7 @@ func handlePIRProtocol(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var productID uint64
-	if id, err := strconv.ParseUint(barcode, 10, 64); err == nil {
-		productID = id
-	} else {
-		productID = stringToUint64Hash(barcode)
-	}
-
-	output, err := captureQueryOutput(productID)
+	record, _, err := pir.QueryProductByBarcode(r.Context(), scheme, barcode)
 	if err != nil {
 		response := map[string]interface{}{
 			"encryptedResult": "encrypted_error_response",
@@ -385,7 +287,7 @@ func handlePIRProtocol(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	allProductData, err := extractProductInfo(output, barcode)
+	allProductData, err := productFields(record)
 	if err != nil {
 		log.Printf("ERROR: Failed to extract product info for barcode %s: %v", barcode, err)
 		response := map[string]interface{}{
@@ -417,22 +319,19 @@ func handleRegularSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("Regular search for barcode: %s\n", barcode)
+	start := time.Now()
 
+	// A missing barcode gets the same response as any failed lookup and is
+	// not logged, so neither the reply nor the log singles it out.
 	productID, err := findProductByBarcode(barcode)
-	if err != nil {
-		fmt.Printf("ERROR: Regular search failed: %v\n", err)
-		response := ProductResponse{Error: "Product not found"}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+	var record map[string]string
+	if err == nil {
+		record, err = executeDirectLookup(productID)
 	}
-
-	start := time.Now()
-
-	output, err := executeDirectLookup(productID)
 	if err != nil {
-		fmt.Printf("ERROR: Direct lookup failed: %v\n", err)
+		if !errors.Is(err, errProductNotFound) {
+			fmt.Printf("ERROR: Direct lookup failed: %v\n", err)
+		}
 		response := ProductResponse{Error: "Product not found"}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
@@ -442,7 +341,7 @@ func handleRegularSearch(w http.ResponseWriter, r *http.Request) {
 	elapsed := time.Since(start)
 	fmt.Printf("Direct lookup completed in %v\n", elapsed)
 
-	allProductData, err := extractProductInfo(output, barcode)
+	allProductData, err := productFields(record)
 	if err != nil {
 		fmt.Printf("ERROR: Failed to extract product info from regular search: %v\n", err)
 		response := ProductResponse{Error: "Failed to retrieve product data"}
@@ -466,10 +365,10 @@ func handleRegularSearch(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func executeDirectLookup(productID uint64) (string, error) {
+func executeDirectLookup(productID uint64) (map[string]string, error) {
 	_, pirKeys, columns, _, err := pir.LoadDatabaseOnce()
 	if err != nil {
-		return "", fmt.Errorf("database error: %v", err)
+		return nil, fmt.Errorf("database error: %v", err)
 	}
 
 	var queryIndex uint64
@@ -483,28 +382,16 @@ func executeDirectLookup(productID uint64) (string, error) {
 	}
 
 	if !found {
-		return "", fmt.Errorf("product with ID %d not found in database", productID)
+		return nil, errProductNotFound
 	}
 
 	binPath := "../db/en.openfoodfacts.org.products.bin"
 	recordData, err := pir.GetRecordFromBinary(binPath, columns, queryIndex)
 	if err != nil {
-		return "", fmt.Errorf("error retrieving record: %v", err)
+		return nil, fmt.Errorf("error retrieving record: %v", err)
 	}
 
-	var output strings.Builder
-	output.WriteString(fmt.Sprintf("=== Retrieved Full Record for Product ID %d (Index: %d) ===\n", productID, queryIndex))
-
-	for _, column := range columns {
-		if value, exists := recordData[column]; exists {
-			output.WriteString(fmt.Sprintf("%-20s: %s\n", column, value))
-		} else {
-			output.WriteString(fmt.Sprintf("%-20s: <missing>\n", column))
-		}
-	}
-	output.WriteString("=== End Record ===\n")
-
-	return output.String(), nil
+	return recordData, nil
 }
 
 func handleSearch(w http.ResponseWriter, r *http.Request) {
@@ -520,6 +407,12 @@ func handleSearch(w http.ResponseWriter, r *http.Request) {
 func main() {
 	fmt.Println("Starting PIR service...")
 
+	var err error
+	if scheme, err = pir.DemoScheme(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Serving queries with %s\n", scheme.Name())
+
 	testDatabaseConnection()
 
 	r := mux.NewRouter()
@@ -529,6 +422,7 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
 	}).Methods("GET")
 	r.HandleFunc("/pir-protocol", handlePIRProtocol).Methods("POST")
+	r.HandleFunc("/pir-exists", handlePIRExists).Methods("GET")
 
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir(".")))
 
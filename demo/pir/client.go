@@ -0,0 +1,152 @@
+package pir
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Client side of a PIR scheme. It owns the params, the hint downloaded from
+// the server, the shared state decompressed from the server's seed, the PRG
+// from which its query secrets are drawn, and a pool of precomputed queries
+// (see precompute.go).
+type Client struct {
+	pi     CheckedPIR
+	params Params
+	info   DBinfo
+	shared State
+	hint   Msg
+	prg    *BufPRGReader
+
+	mu   sync.Mutex // guards prg, hint and pool, as Precompute may run concurrently
+	pool []precomputedQuery
+}
+
+// Secrets of an outstanding batch of queries, needed to decode the answer.
+type PendingQuery struct {
+	indices []uint64
+	secrets []State
+	query   MsgSlice
+}
+
+// Builds a client from what the server publishes: its params, DB info, seed
+// and hint.
+func NewClient(pi CheckedPIR, p Params, info DBinfo, seed CompressedState, hint Msg) (*Client, error) {
+	return NewClientSeeded(pi, p, info, seed, hint, RandomPRGKey())
+}
+
+// Same as NewClient, but draws the query secrets and errors from a PRG keyed
+// with key, so that the same queries come out for the same key. Only for
+// tests and reproducible runs: queries are as private as the key.
+func NewClientSeeded(pi CheckedPIR, p Params, info DBinfo, seed CompressedState, hint Msg,
+	key *PRGKey) (*Client, error) {
+	if seed.Seed == nil {
+		return nil, fmt.Errorf("%w: missing seed", ErrBadState)
+	}
+	if info.Ne == 0 || info.Squishing == 0 {
+		return nil, ErrNotSetup
+	}
+	if len(hint.Data) == 0 {
+		return nil, fmt.Errorf("%w: missing hint", ErrBadState)
+	}
+
+	return &Client{
+		pi:     pi,
+		params: p,
+		info:   info,
+		shared: pi.DecompressState(info, p, seed),
+		hint:   hint,
+		prg:    NewBufPRG(NewPRG(key)),
+	}, nil
+}
+
+func (c *Client) Params() Params {
+	return c.params
+}
+
+func (c *Client) DBInfo() DBinfo {
+	return c.info
+}
+
+// Returns the range of DB rows (out of l) that the j-th query in a batch of
+// num queries is answered from; this mirrors how Answer splits the DB.
+func batchRows(l, j, num uint64) (uint64, uint64) {
+	batch_sz := l / num
+	if j == num-1 {
+		return j * batch_sz, l
+	}
+	return j * batch_sz, (j + 1) * batch_sz
+}
+
+// Returns the first DB index and the number of DB entries that lie entirely
+// within the rows that the j-th query in a batch of num queries is answered
+// from, i.e., the indices that the j-th query can fetch.
+func batchSubTable(j, num uint64, p Params, info DBinfo) (uint64, uint64) {
+	start, end := batchRows(p.L, j, num)
+	first_row := (start + info.Ne - 1) / info.Ne
+	last_row := end / info.Ne
+	// with packing, each Z_p elem holds several entries
+	per_elem := uint64(1)
+	if info.Packing > 0 {
+		per_elem = info.Packing
+	}
+	if last_row <= first_row {
+		return first_row * p.M * per_elem, 0
+	}
+	return first_row * p.M * per_elem, (last_row - first_row) * p.M * per_elem
+}
+
+// Builds a batch of queries, one for each index. With more than one index,
+// the server splits the DB rows evenly across the batch, so the j-th index
+// must fall in the j-th slice of rows.
+func (c *Client) Query(indices ...uint64) (*PendingQuery, MsgSlice, error) {
+	num := uint64(len(indices))
+	if num == 0 {
+		return nil, MsgSlice{}, fmt.Errorf("%w: empty batch", ErrBadQuery)
+	}
+	if c.params.L/num < c.info.Ne {
+		return nil, MsgSlice{}, fmt.Errorf("%w: %d queries, %d rows", ErrTooManyQueries, num, c.params.L)
+	}
+
+	pending := &PendingQuery{indices: indices}
+	for j, i := range indices {
+		row := (elemIndex(i, c.info) / c.params.M) * c.info.Ne
+		start, end := batchRows(c.params.L, uint64(j), num)
+		if row < start || row+c.info.Ne > end {
+			return nil, MsgSlice{}, fmt.Errorf("%w: index %d is not in rows [%d, %d) of batch %d",
+				ErrIndexOutOfRange, i, start, end, j)
+		}
+
+		secret, q, err := c.nextQuery(i)
+		if err != nil {
+			return nil, MsgSlice{}, err
+		}
+		pending.secrets = append(pending.secrets, secret)
+		pending.query.Data = append(pending.query.Data, q)
+	}
+
+	return pending, pending.query, nil
+}
+
+// Decodes the server's answer to a batch of queries, returning one DB entry
+// per queried index.
+func (c *Client) Recover(pending *PendingQuery, answer Msg) ([]uint64, error) {
+	if pending == nil {
+		return nil, fmt.Errorf("%w: no pending query", ErrBadState)
+	}
+
+	var vals []uint64
+	for j := range pending.indices {
+		val, err := c.recoverAt(pending, j, answer)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// Decodes the answer to the j-th query of a batch.
+func (c *Client) recoverAt(pending *PendingQuery, j int, answer Msg) (uint64, error) {
+	return c.pi.RecoverChecked(pending.indices[j], uint64(j), c.Hint(), pending.query.Data[j], answer,
+		c.shared, pending.secrets[j], c.params, c.info)
+}
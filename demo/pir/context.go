@@ -0,0 +1,95 @@
+package pir
+
+import "context"
+
+// Called with the number of rows processed so far, out of total, while
+// SetupContext or AnswerContext runs.
+type ProgressFunc func(done, total uint64)
+
+// Implemented by schemes whose Setup and Answer work through the DB in blocks
+// of rows, so that they can stop when ctx is cancelled (returning ctx.Err())
+// and report progress after each block. SetupContext only modifies DB once
+// the hint is computed, so a cancelled Setup leaves DB as it was.
+type ContextPIR interface {
+	SetupContext(ctx context.Context, DB *Database, shared State, p Params,
+		progress ProgressFunc) (State, Msg, error)
+
+	AnswerContext(ctx context.Context, DB *Database, query MsgSlice, server State, shared State, p Params,
+		workers int, progress ProgressFunc) (Msg, error)
+}
+
+// Number of rows processed between two checks for cancellation. This is a
+// multiple of 8, as the packed matrix-vector kernel handles 8 rows at a time.
+const contextBlockRows = 256
+
+// Tracks how many rows a Setup or Answer call has processed.
+type rowProgress struct {
+	ctx      context.Context
+	progress ProgressFunc
+	done     uint64
+	total    uint64
+}
+
+func newRowProgress(ctx context.Context, progress ProgressFunc) *rowProgress {
+	return &rowProgress{ctx: ctx, progress: progress}
+}
+
+// For the non-cancellable entry points.
+func noProgress() *rowProgress {
+	return newRowProgress(context.Background(), nil)
+}
+
+// Whether the work needs to be split into blocks at all.
+func (r *rowProgress) blocking() bool {
+	return r.ctx.Done() != nil || r.progress != nil
+}
+
+func (r *rowProgress) step(rows uint64) {
+	r.done += rows
+	if r.progress != nil {
+		r.progress(r.done, r.total)
+	}
+}
+
+// Computes a*b, contextBlockRows rows of a at a time.
+func matrixMulContext(r *rowProgress, a *Matrix, b *Matrix) (*Matrix, error) {
+	if !r.blocking() {
+		return MatrixMul(a, b), nil
+	}
+
+	out := new(Matrix)
+	for start := uint64(0); start < a.Rows; start += contextBlockRows {
+		if err := r.ctx.Err(); err != nil {
+			return nil, err
+		}
+		rows := a.Rows - start
+		if rows > contextBlockRows {
+			rows = contextBlockRows
+		}
+		out.Concat(MatrixMul(a.SelectRows(start, rows), b))
+		r.step(rows)
+	}
+	return out, nil
+}
+
+// Same as MatrixMulVecPackedParallel, contextBlockRows rows of a at a time.
+func matrixMulVecPackedContext(r *rowProgress, a *Matrix, b *Matrix, basis, compression uint64,
+	workers int) (*Matrix, error) {
+	if !r.blocking() {
+		return MatrixMulVecPackedParallel(a, b, basis, compression, workers), nil
+	}
+
+	out := new(Matrix)
+	for start := uint64(0); start < a.Rows; start += contextBlockRows {
+		if err := r.ctx.Err(); err != nil {
+			return nil, err
+		}
+		rows := a.Rows - start
+		if rows > contextBlockRows {
+			rows = contextBlockRows
+		}
+		out.Concat(MatrixMulVecPackedParallel(a.SelectRows(start, rows), b, basis, compression, workers))
+		r.step(rows)
+	}
+	return out, nil
+}
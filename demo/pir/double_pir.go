@@ -3,6 +3,7 @@ package pir
 // #cgo CFLAGS: -O3 -march=native
 // #include "pir.h"
 import "C"
+import "context"
 import "fmt"
 
 type DoublePIR struct{}
@@ -23,6 +24,24 @@ func (pi *DoublePIR) Name() string {
 }
 
 func (pi *DoublePIR) PickParams(N, d, n, logq uint64) Params {
+	p, err := pi.PickParamsChecked(N, d, n, logq)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (pi *DoublePIR) PickParamsChecked(N, d, n, logq uint64) (Params, error) {
+	return pi.PickParamsWithOptions(N, d, n, logq, FailureOptions{})
+}
+
+// Same as PickParamsChecked, but checks the decoding failure probability as
+// opts says.
+func (pi *DoublePIR) PickParamsWithOptions(N, d, n, logq uint64, opts FailureOptions) (Params, error) {
+	if N == 0 || d == 0 {
+		return Params{}, ErrEmptyDB
+	}
+
 	good_p := Params{}
 	found := false
 
@@ -36,22 +55,22 @@ func (pi *DoublePIR) PickParams(N, d, n, logq uint64) Params {
 			L:    l,
 			M:    m,
 		}
-		p.PickParams(true, l, m)
+		err := p.PickParamsWithOptions(true, opts, l, m)
 
-		if p.P < mod_p {
+		if err != nil || p.P < mod_p {
 			if !found {
-				panic("Error; should not happen")
+				if err == nil {
+					err = ErrInvalidParams
+				}
+				return Params{}, err
 			}
 			good_p.PrintParams()
-			return good_p
+			return good_p, nil
 		}
 
 		good_p = p
 		found = true
 	}
-
-	panic("Cannot be reached")
-	return Params{}
 }
 
 func (pi *DoublePIR) PickParamsGivenDimensions(l, m, n, logq uint64) Params {
@@ -76,9 +95,9 @@ func (pi *DoublePIR) GetBW(info DBinfo, p Params) {
 	fmt.Printf("\t\tOnline download: %d KB\n", uint64(online_download))
 }
 
-func (pi *DoublePIR) Init(info DBinfo, p Params) State {
-	A1 := MatrixRand(p.M, p.N, p.Logq, 0)
-	A2 := MatrixRand(p.L/info.X, p.N, p.Logq, 0)
+func (pi *DoublePIR) Init(info DBinfo, p Params, prg *BufPRGReader) State {
+	A1 := MatrixRand(prg, p.M, p.N, p.Logq, 0)
+	A2 := MatrixRand(prg, p.L/info.X, p.N, p.Logq, 0)
 
 	return MakeState(A1, A2)
 }
@@ -89,32 +108,44 @@ func (pi *DoublePIR) InitCompressed(info DBinfo, p Params) (State, CompressedSta
 }
 
 func (pi *DoublePIR) InitCompressedSeeded(info DBinfo, p Params, seed *PRGKey) (State, CompressedState) {
-        bufPrgReader = NewBufPRG(NewPRG(seed))
-        return pi.Init(info, p), MakeCompressedState(seed)
+        return pi.Init(info, p, NewBufPRG(NewPRG(seed))), MakeCompressedState(seed)
 }
 
 func (pi *DoublePIR) DecompressState(info DBinfo, p Params, comp CompressedState) State {
-        bufPrgReader = NewBufPRG(NewPRG(comp.Seed))
-        return pi.Init(info, p)
+        return pi.Init(info, p, NewBufPRG(NewPRG(comp.Seed)))
 }
 
 func (pi *DoublePIR) Setup(DB *Database, shared State, p Params) (State, Msg) {
+	server, offline, _ := pi.setup(noProgress(), DB, shared, p)
+	return server, offline
+}
+
+// Only fails if r's context is cancelled, in which case DB is left untouched.
+// Progress counts the rows of DB and then those of H1.
+func (pi *DoublePIR) setup(r *rowProgress, DB *Database, shared State, p Params) (State, Msg, error) {
 	A1 := shared.Data[0]
 	A2 := shared.Data[1]
 
-	H1 := MatrixMul(DB.Data, A1)
+	r.total = DB.Data.Rows + p.N*p.delta()*DB.Info.X
+	H1, err := matrixMulContext(r, DB.Data, A1)
+	if err != nil {
+		return State{}, Msg{}, err
+	}
 	H1.Transpose()
 	H1.Expand(p.P, p.delta())
 	H1.ConcatCols(DB.Info.X)
 
-	H2 := MatrixMul(H1, A2)
+	H2, err := matrixMulContext(r, H1, A2)
+	if err != nil {
+		return State{}, Msg{}, err
+	}
 
 	// pack the database more tightly, because the online computation is memory-bound
 	DB.Data.Add(p.P / 2)
 	DB.Squish()
 
 	H1.Add(p.P / 2)
-	H1.Squish(10, 3)
+	H1.Squish(squishBasis, squishCompression)
 
 	A2_copy := A2.RowsDeepCopy(0, A2.Rows) // deep copy whole matrix
 	if A2_copy.Rows % 3 != 0 {
@@ -122,12 +153,13 @@ func (pi *DoublePIR) Setup(DB *Database, shared State, p Params) (State, Msg) {
         }
 	A2_copy.Transpose()
 
-	return MakeState(H1, A2_copy), MakeMsg(H2)
+	return MakeState(H1, A2_copy), MakeMsg(H2), nil
 }
 
 func (pi *DoublePIR) FakeSetup(DB *Database, p Params) (State, float64) {
 	info := DB.Info
-	H1 := MatrixRand(p.N*p.delta()*info.X, p.L/info.X, 0, p.P)
+	prg := RandomBufPRG()
+	H1 := MatrixRand(prg, p.N*p.delta()*info.X, p.L/info.X, 0, p.P)
 	offline_download := float64(p.N*p.delta()*info.X*p.N*uint64(p.Logq)) / (8.0 * 1024.0)
 	fmt.Printf("\t\tOffline download: %d KB\n", uint64(offline_download))
 
@@ -136,26 +168,26 @@ func (pi *DoublePIR) FakeSetup(DB *Database, p Params) (State, float64) {
 	DB.Squish()
 
 	H1.Add(p.P / 2)
-	H1.Squish(10, 3)
+	H1.Squish(squishBasis, squishCompression)
 
 	A2_rows := p.L/info.X
 	if A2_rows % 3 != 0 {
 		A2_rows += (3-(A2_rows % 3))
 	}
-	A2_copy := MatrixRand(p.N, A2_rows, p.Logq, 0)
+	A2_copy := MatrixRand(prg, p.N, A2_rows, p.Logq, 0)
 
 	return MakeState(H1, A2_copy), offline_download
 }
 
-func (pi *DoublePIR) Query(i uint64, shared State, p Params, info DBinfo) (State, Msg) {
-	i1 := (i / p.M) * (info.Ne / info.X)
-	i2 := i % p.M
+func (pi *DoublePIR) Query(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg) {
+	i1 := (elemIndex(i, info) / p.M) * (info.Ne / info.X)
+	i2 := elemIndex(i, info) % p.M
 
 	A1 := shared.Data[0]
 	A2 := shared.Data[1]
 
-	secret1 := MatrixRand(p.N, 1, p.Logq, 0)
-	err1 := MatrixGaussian(p.M, 1)
+	secret1 := MatrixRand(prg, p.N, 1, p.Logq, 0)
+	err1 := MatrixGaussianSigma(prg, p.M, 1, p.Sigma)
 	query1 := MatrixMul(A1, secret1)
 	query1.MatrixAdd(err1)
 	query1.Data[i2] += C.Elem(p.Delta())
@@ -168,8 +200,8 @@ func (pi *DoublePIR) Query(i uint64, shared State, p Params, info DBinfo) (State
 	msg := MakeMsg(query1)
 
 	for j := uint64(0); j < info.Ne/info.X; j++ {
-		secret2 := MatrixRand(p.N, 1, p.Logq, 0)
-		err2 := MatrixGaussian(p.L/info.X, 1)
+		secret2 := MatrixRand(prg, p.N, 1, p.Logq, 0)
+		err2 := MatrixGaussianSigma(prg, p.L/info.X, 1, p.Sigma)
 		query2 := MatrixMul(A2, secret2)
 		query2.MatrixAdd(err2)
 		query2.Data[i1+j] += C.Elem(p.Delta())
@@ -186,6 +218,15 @@ func (pi *DoublePIR) Query(i uint64, shared State, p Params, info DBinfo) (State
 }
 
 func (pi *DoublePIR) Answer(DB *Database, query MsgSlice, server State, shared State, p Params) Msg {
+	ans, _ := pi.answer(noProgress(), DB, query, server, p, 1)
+	return ans
+}
+
+// Only reads from DB and the server state, so it is safe to call concurrently.
+// Only fails if r's context is cancelled; progress counts the rows of DB.
+func (pi *DoublePIR) answer(r *rowProgress, DB *Database, query MsgSlice, server State, p Params,
+	workers int) (Msg, error) {
+	r.total = DB.Data.Rows
 	H1 := server.Data[0]
 	A2_transpose := server.Data[1]
 
@@ -199,32 +240,46 @@ func (pi *DoublePIR) Answer(DB *Database, query MsgSlice, server State, shared S
 		if batch == int(num_queries-1) {
 			batch_sz = DB.Data.Rows - last
 		}
-		a := MatrixMulVecPacked(DB.Data.SelectRows(last, batch_sz),
-			                q1, DB.Info.Basis, DB.Info.Squishing)
+		a, err := matrixMulVecPackedContext(r, DB.Data.SelectRows(last, batch_sz),
+			                q1, DB.Info.Basis, DB.Info.Squishing, workers)
+		if err != nil {
+			return Msg{}, err
+		}
 		a1.Concat(a)
 		last += batch_sz
 	}
+	if err := r.ctx.Err(); err != nil {
+		return Msg{}, err
+	}
 
-	a1.TransposeAndExpandAndConcatColsAndSquish(p.P, p.delta(), DB.Info.X, 10, 3)
-        h1 := MatrixMulTransposedPacked(a1, A2_transpose, 10, 3)
+	a1.TransposeAndExpandAndConcatColsAndSquish(p.P, p.delta(), DB.Info.X, squishBasis, squishCompression)
+        h1 := MatrixMulTransposedPacked(a1, A2_transpose, squishBasis, squishCompression)
 	msg := MakeMsg(h1)
 
 	for _, q := range query.Data {
 		for j := uint64(0); j < DB.Info.Ne/DB.Info.X; j++ {
 			q2 := q.Data[1+j]
-			a2 := MatrixMulVecPacked(H1, q2, 10, 3)
-			h2 := MatrixMulVecPacked(a1, q2, 10, 3)
+			a2 := MatrixMulVecPackedParallel(H1, q2, squishBasis, squishCompression, workers)
+			h2 := MatrixMulVecPackedParallel(a1, q2, squishBasis, squishCompression, workers)
 
 			msg.Data = append(msg.Data, a2)
 			msg.Data = append(msg.Data, h2)
 		}
 	}
 
-	return msg
+	return msg, nil
 }
 
 func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Msg,
 	answer Msg, shared State, client State, p Params, info DBinfo) uint64 {
+	vals := pi.recoverElems(batch_index, offline, query, answer, shared, client, p, info)
+	return ReconstructElem(vals, i, info)
+}
+
+// Returns the (still mapped to [-p/2, p/2]) Z_p elems that make up the DB
+// entry queried in batch batch_index.
+func (pi *DoublePIR) recoverElems(batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) []uint64 {
 	H2 := offline.Data[0]
 	h1 := answer.Data[0].RowsDeepCopy(0, answer.Data[0].Rows) // deep copy whole matrix 
 	secret1 := client.Data[0]
@@ -234,15 +289,7 @@ func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 	for j := uint64(0); j<p.M; j++ {
 		val1 += ratio*query.Data[0].Get(j,0)
 	}
-	val1 %= (1<<p.Logq)
-	val1 = (1<<p.Logq)-val1
-
-	val2 := uint64(0)
-	for j := uint64(0); j<p.L/info.X; j++ {
-		val2 += ratio*query.Data[1].Get(j,0)
-	}
-	val2 %= (1<<p.Logq)
-	val2 = (1<<p.Logq)-val2
+	val1 = negModQ(val1, p.Logq)
 
 	A2 := shared.Data[1]
 	if (A2.Cols != p.N) || (h1.Cols != p.N) {
@@ -253,8 +300,7 @@ func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 	        for j2 := uint64(0); j2<A2.Rows; j2++ {
 			val3 += ratio*A2.Get(j2,j1)
 		}
-		val3 %= (1<<p.Logq)
-		val3 = (1<<p.Logq)-val3
+		val3 = negModQ(val3, p.Logq)
 		v := C.Elem(val3)
 		for k := uint64(0); k<h1.Rows; k++ {
                 	h1.Data[k*h1.Cols+j1] += v
@@ -267,6 +313,13 @@ func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 		a2 := answer.Data[1+2*i+offset]
 		h2 := answer.Data[2+2*i+offset]
 		secret2 := client.Data[1+i]
+
+		// each of the ne/X queries of the second level has its own offset
+		val2 := uint64(0)
+		for j := uint64(0); j<p.L/info.X; j++ {
+			val2 += ratio*query.Data[1+i].Get(j,0)
+		}
+		val2 = negModQ(val2, p.Logq)
 		h2.Add(val2)
 
 		for j := uint64(0); j < info.X; j++ {
@@ -274,10 +327,10 @@ func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 			state.Add(val2)
 			state.Concat(h2.SelectRows(j*p.delta(), p.delta()))
 
-			hint := H2.RowsDeepCopy(j*p.N*p.delta(), p.N*p.delta())
-			hint.Concat(h1.SelectRows(j*p.delta(), p.delta()))
-
-			interm := MatrixMul(hint, secret2)
+			// the hint is multiplied in place, as copying it for each of
+			// the ne elems costs more than the products
+			interm := MatrixMul(H2.SelectRows(j*p.N*p.delta(), p.N*p.delta()), secret2)
+			interm.Concat(MatrixMul(h1.SelectRows(j*p.delta(), p.delta()), secret2))
 			state.MatrixSub(interm)
 			state.Round(p)
 			state.Contract(p.P, p.delta())
@@ -285,17 +338,151 @@ func (pi *DoublePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 			noised := uint64(state.Data[p.N]) + val1
 			for l := uint64(0); l < p.N; l++ {
 				noised -= uint64(secret1.Data[l] * state.Data[l])
-				noised = noised % (1 << p.Logq)
+				noised = modQ(noised, p.Logq)
 			}
 			vals = append(vals, p.Round(noised))
 			//fmt.Printf("Reconstructing row %d: %d\n", j+info.X*i, denoised)
 		}
 	}
 
-	return ReconstructElem(vals, i, info)
+	return vals
 }
 
 func (pi *DoublePIR) Reset(DB *Database, p Params) {
 	DB.Unsquish()
 	DB.Data.Sub(p.P / 2)
 }
+
+
+func (pi *DoublePIR) checkShared(shared State, p Params, info DBinfo) error {
+	if info.X == 0 || p.L%info.X != 0 {
+		return fmt.Errorf("%w: X=%d must divide DB height %d", ErrBadParams, info.X, p.L)
+	}
+	if len(shared.Data) < 2 || !hasDims(shared.Data[0], p.M, p.N) ||
+		!hasDims(shared.Data[1], p.L/info.X, p.N) {
+		return fmt.Errorf("%w: expected %d-by-%d matrix A1 and %d-by-%d matrix A2",
+			ErrBadState, p.M, p.N, p.L/info.X, p.N)
+	}
+	return nil
+}
+
+func (pi *DoublePIR) SetupChecked(DB *Database, shared State, p Params) (State, Msg, error) {
+	if err := checkSetupDB(DB, p); err != nil {
+		return State{}, Msg{}, err
+	}
+	if p.HintBits() < p.Logq || p.AnswerBits() < p.Logq {
+		return State{}, Msg{}, fmt.Errorf("%w: DoublePIR cannot compress its hint or answer", ErrNotSupported)
+	}
+	if err := pi.checkShared(shared, p, DB.Info); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	server, offline := pi.Setup(DB, shared, p)
+	return server, offline, nil
+}
+
+func (pi *DoublePIR) SetupContext(ctx context.Context, DB *Database, shared State, p Params,
+	progress ProgressFunc) (State, Msg, error) {
+	if err := checkSetupDB(DB, p); err != nil {
+		return State{}, Msg{}, err
+	}
+	if p.HintBits() < p.Logq || p.AnswerBits() < p.Logq {
+		return State{}, Msg{}, fmt.Errorf("%w: DoublePIR cannot compress its hint or answer", ErrNotSupported)
+	}
+	if err := pi.checkShared(shared, p, DB.Info); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	return pi.setup(newRowProgress(ctx, progress), DB, shared, p)
+}
+
+func (pi *DoublePIR) QueryChecked(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg, error) {
+	if err := pi.checkShared(shared, p, info); err != nil {
+		return State{}, Msg{}, err
+	}
+	if err := checkIndex(i, p, info); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	client, query := pi.Query(i, shared, p, info, prg)
+	return client, query, nil
+}
+
+func (pi *DoublePIR) AnswerChecked(DB *Database, query MsgSlice, server State, shared State, p Params) (Msg, error) {
+	return pi.AnswerParallel(DB, query, server, shared, p, 1)
+}
+
+func (pi *DoublePIR) AnswerParallel(DB *Database, query MsgSlice, server State, shared State, p Params,
+	workers int) (Msg, error) {
+	return pi.AnswerContext(context.Background(), DB, query, server, shared, p, workers, nil)
+}
+
+func (pi *DoublePIR) AnswerContext(ctx context.Context, DB *Database, query MsgSlice, server State,
+	shared State, p Params, workers int, progress ProgressFunc) (Msg, error) {
+	if err := checkAnswerDB(DB, query); err != nil {
+		return Msg{}, err
+	}
+	if len(server.Data) != 2 || server.Data[0] == nil || server.Data[1] == nil {
+		return Msg{}, fmt.Errorf("%w: expected matrices H1 and A2", ErrBadState)
+	}
+	H1 := server.Data[0]
+	for batch, q := range query.Data {
+		if uint64(len(q.Data)) != 1+DB.Info.Ne/DB.Info.X ||
+			!hasDims(q.Data[0], DB.Data.Cols*DB.Info.Squishing, 1) {
+			return Msg{}, fmt.Errorf("%w: query %d does not match %d-column database",
+				ErrBadQuery, batch, DB.Info.Cols)
+		}
+		for _, q2 := range q.Data[1:] {
+			if !hasDims(q2, H1.Cols*3, 1) {
+				return Msg{}, fmt.Errorf("%w: query %d does not match %d-row database",
+					ErrBadQuery, batch, p.L/DB.Info.X)
+			}
+		}
+	}
+
+	return pi.answer(newRowProgress(ctx, progress), DB, query, server, p, workers)
+}
+
+func (pi *DoublePIR) RecoverChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) (uint64, error) {
+	if err := pi.checkRecover(i, batch_index, offline, query, answer, shared, client, p, info); err != nil {
+		return 0, err
+	}
+
+	return pi.Recover(i, batch_index, offline, query, answer, shared, client, p, info), nil
+}
+
+func (pi *DoublePIR) RecoverElemsChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) ([]uint64, error) {
+	if err := pi.checkRecover(i, batch_index, offline, query, answer, shared, client, p, info); err != nil {
+		return nil, err
+	}
+
+	vals := pi.recoverElems(batch_index, offline, query, answer, shared, client, p, info)
+	return unmapElems(vals, info), nil
+}
+
+func (pi *DoublePIR) checkRecover(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) error {
+	if err := pi.checkShared(shared, p, info); err != nil {
+		return err
+	}
+	if err := checkIndex(i, p, info); err != nil {
+		return err
+	}
+	reps := info.Ne / info.X
+	if uint64(len(client.Data)) != 1+reps {
+		return fmt.Errorf("%w: expected %d secrets", ErrBadState, 1+reps)
+	}
+	if uint64(len(query.Data)) != 1+reps || query.Data[0].Rows < p.M || query.Data[1].Rows < p.L/info.X {
+		return fmt.Errorf("%w: expected %d query vectors", ErrBadQuery, 1+reps)
+	}
+	if len(offline.Data) != 1 || offline.Data[0].Rows < info.X*p.N*p.delta() {
+		return fmt.Errorf("%w: expected hint with %d rows", ErrBadAnswer, info.X*p.N*p.delta())
+	}
+	if uint64(len(answer.Data)) < 1+2*reps*(batch_index+1) || answer.Data[0].Cols != p.N ||
+		answer.Data[0].Rows < info.X*p.delta() {
+		return fmt.Errorf("%w: answer does not cover batch %d", ErrBadAnswer, batch_index)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build elem64
+
+package pir
+
+// #cgo CFLAGS: -DPIR_ELEM64
+import "C"
+
+// Built with the elem64 tag: elems, and so ciphertexts, are 64 bits wide,
+// i.e., logq = 64. This allows for p up to 2^20, so records take fewer Z_p
+// elems (and DB rows), at the cost of twice the bits per hint and answer elem
+// and a larger n for the same security.
+
+// Number of bits per Z_p elem in a squished DB, so p can be at most 2^20.
+const squishBasis = 20
+
+var (
+	Preset128 = SecurityPreset{Name: "128", N: 1 << 11, Logq: 64, Security: 128}
+	Preset192 = SecurityPreset{Name: "192", N: 3072, Logq: 64, Security: 192}
+	Preset256 = SecurityPreset{Name: "256", N: 1 << 12, Logq: 64, Security: 256}
+)
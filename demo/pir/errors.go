@@ -0,0 +1,96 @@
+package pir
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the checked (error-returning) variants of the PIR API.
+// Callers should compare against these with errors.Is, since most of them
+// are wrapped with extra context about the failing input.
+var (
+	ErrEmptyDB           = errors.New("pir: empty database")
+	ErrBadInput          = errors.New("pir: bad input database")
+	ErrNeedDims          = errors.New("pir: need to specify n and q")
+	ErrInvalidParams     = errors.New("pir: params invalid")
+	ErrNoParams          = errors.New("pir: no suitable params known")
+	ErrBadParams         = errors.New("pir: bad params")
+	ErrUnreliableParams  = errors.New("pir: params decode wrongly too often")
+	ErrDBSizeMismatch    = errors.New("pir: params and database size don't match")
+	ErrIndexOutOfRange   = errors.New("pir: index out of range")
+	ErrTooManyQueries    = errors.New("pir: too many queries to handle")
+	ErrBadState          = errors.New("pir: malformed state")
+	ErrBadQuery          = errors.New("pir: malformed query")
+	ErrBadAnswer         = errors.New("pir: malformed answer")
+	ErrNotSetup          = errors.New("pir: database has not been preprocessed")
+	ErrAlreadySetup      = errors.New("pir: database has already been preprocessed")
+	ErrReconstructFailed = errors.New("pir: reconstruct failed")
+	ErrTampered          = errors.New("pir: answer failed integrity check")
+	ErrKeyNotFound       = errors.New("pir: key not found")
+	ErrNotSupported      = errors.New("pir: not supported by this scheme")
+	ErrUnknownScheme     = errors.New("pir: unknown scheme")
+
+	ErrBadEncoding        = errors.New("pir: malformed encoding")
+	ErrUnsupportedVersion = errors.New("pir: unsupported encoding version")
+)
+
+func hasDims(m *Matrix, rows, cols uint64) bool {
+	return m != nil && m.Rows == rows && m.Cols == cols && uint64(len(m.Data)) >= rows*cols
+}
+
+// Checks that the params allow for the in-memory DB compression done by Setup.
+func checkSquishParams(p Params) error {
+	if p.P == 0 || p.P > (1<<squishBasis) || p.Logq < squishBasis*squishCompression {
+		return fmt.Errorf("%w: p=%d and logq=%d do not allow DB compression", ErrBadParams, p.P, p.Logq)
+	}
+	if p.Logq != elemBits {
+		return fmt.Errorf("%w: logq=%d, but elems have %d bits", ErrBadParams, p.Logq, elemBits)
+	}
+	return nil
+}
+
+func checkSetupDB(DB *Database, p Params) error {
+	if DB == nil || DB.Data == nil {
+		return ErrEmptyDB
+	}
+	if DB.Info.Squishing != 0 {
+		return ErrAlreadySetup
+	}
+	if DB.Info.P != p.P || DB.Info.Logq != p.Logq {
+		return fmt.Errorf("%w: database built for p=%d, logq=%d", ErrBadParams, DB.Info.P, DB.Info.Logq)
+	}
+	if !hasDims(DB.Data, p.L, p.M) {
+		return fmt.Errorf("%w: %d-by-%d database, %d-by-%d params", ErrDBSizeMismatch,
+			DB.Data.Rows, DB.Data.Cols, p.L, p.M)
+	}
+	return checkSquishParams(p)
+}
+
+func checkAnswerDB(DB *Database, query MsgSlice) error {
+	if DB == nil || DB.Data == nil {
+		return ErrEmptyDB
+	}
+	if DB.Info.Squishing == 0 {
+		return ErrNotSetup
+	}
+	num_queries := uint64(len(query.Data))
+	if num_queries == 0 {
+		return fmt.Errorf("%w: empty batch", ErrBadQuery)
+	}
+	if DB.Data.Rows/num_queries < DB.Info.Ne {
+		return fmt.Errorf("%w: %d queries, %d rows", ErrTooManyQueries, num_queries, DB.Data.Rows)
+	}
+	return nil
+}
+
+// Checks that index i falls inside the DB that the params and info describe.
+func checkIndex(i uint64, p Params, info DBinfo) error {
+	if info.Ne == 0 || info.Squishing == 0 {
+		return ErrNotSetup
+	}
+	if i >= dbCapacity(p, info) {
+		return fmt.Errorf("%w: index %d, %d-by-%d database with %d elems per entry",
+			ErrIndexOutOfRange, i, p.L, p.M, info.Ne)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package pir
 import "C"
 import "fmt"
 import "math/big"
+import "sync"
 
 type Matrix struct {
 	Rows uint64
@@ -35,14 +36,14 @@ func MatrixNewNoAlloc(rows uint64, cols uint64) *Matrix {
 	return out
 }
 
-func MatrixRand(rows uint64, cols uint64, logmod uint64, mod uint64) *Matrix {
+func MatrixRand(prg *BufPRGReader, rows uint64, cols uint64, logmod uint64, mod uint64) *Matrix {
 	out := MatrixNew(rows, cols)
-	m := big.NewInt(int64(mod))
+	m := new(big.Int).SetUint64(mod)
 	if mod == 0 {
-		m = big.NewInt(1 << logmod)
+		m = new(big.Int).Lsh(big.NewInt(1), uint(logmod))
 	}
 	for i := 0; i < len(out.Data); i++ {
-		out.Data[i] = C.Elem(RandInt(m).Uint64())
+		out.Data[i] = C.Elem(prg.RandInt(m).Uint64())
 	}
 	return out
 }
@@ -55,10 +56,14 @@ func MatrixZeros(rows uint64, cols uint64) *Matrix {
 	return out
 }
 
-func MatrixGaussian(rows, cols uint64) *Matrix {
+func MatrixGaussian(prg *BufPRGReader, rows, cols uint64) *Matrix {
+	return MatrixGaussianSigma(prg, rows, cols, gaussSigma)
+}
+
+func MatrixGaussianSigma(prg *BufPRGReader, rows, cols uint64, sigma float64) *Matrix {
 	out := MatrixNew(rows, cols)
 	for i := 0; i < len(out.Data); i++ {
-		out.Data[i] = C.Elem(GaussSample())
+		out.Data[i] = C.Elem(GaussSampleSigma(prg, sigma))
 	}
 	return out
 }
@@ -131,6 +136,13 @@ func (a *Matrix) Sub(val uint64) {
 	}
 }
 
+func (a *Matrix) MulConst(val uint64) {
+	v := C.Elem(val)
+	for i := uint64(0); i < a.Cols*a.Rows; i++ {
+		a.Data[i] *= v
+	}
+}
+
 func MatrixMul(a *Matrix, b *Matrix) *Matrix {
 	if b.Cols == 1 {
 		return MatrixMulVec(a, b)
@@ -156,7 +168,7 @@ func MatrixMul(a *Matrix, b *Matrix) *Matrix {
 
 func MatrixMulTransposedPacked(a *Matrix, b *Matrix, basis, compression uint64) *Matrix {
         fmt.Printf("%d-by-%d vs. %d-by-%d\n", a.Rows, a.Cols, b.Cols, b.Rows)
-        if compression != 3 && basis != 10 {
+        if compression != squishCompression && basis != squishBasis {
                 panic("Must use hard-coded values!")
         }
 
@@ -205,7 +217,7 @@ func MatrixMulVecPacked(a *Matrix, b *Matrix, basis, compression uint64) *Matrix
 	if b.Cols != 1 {
 		panic("Second argument is not a vector")
 	}
-	if compression != 3 && basis != 10 {
+	if compression != squishCompression && basis != squishBasis {
 		panic("Must use hard-coded values!")
 	}
 
@@ -221,6 +233,52 @@ func MatrixMulVecPacked(a *Matrix, b *Matrix, basis, compression uint64) *Matrix
 	return out
 }
 
+// Same as MatrixMulVecPacked, but splits the rows of a across up to 'workers'
+// goroutines. Every worker gets a multiple of 8 rows (the C kernel handles 8
+// rows at a time), except possibly the last one.
+func MatrixMulVecPackedParallel(a *Matrix, b *Matrix, basis, compression uint64, workers int) *Matrix {
+	chunk := uint64(0)
+	if workers > 1 {
+		chunk = (a.Rows/uint64(workers) + 7) / 8 * 8
+	}
+	if chunk == 0 || chunk >= a.Rows {
+		return MatrixMulVecPacked(a, b, basis, compression)
+	}
+
+	if a.Cols*compression != b.Rows {
+		fmt.Printf("%d-by-%d vs. %d-by-%d\n", a.Rows, a.Cols, b.Rows, b.Cols)
+		panic("Dimension mismatch")
+	}
+	if b.Cols != 1 {
+		panic("Second argument is not a vector")
+	}
+	if compression != squishCompression && basis != squishBasis {
+		panic("Must use hard-coded values!")
+	}
+
+	out := MatrixNew(a.Rows+8, 1)
+	bPtr := (*C.Elem)(&b.Data[0])
+
+	var wg sync.WaitGroup
+	for start := uint64(0); start < a.Rows; start += chunk {
+		rows := chunk
+		if start+rows > a.Rows {
+			rows = a.Rows - start
+		}
+		wg.Add(1)
+		go func(start, rows uint64) {
+			defer wg.Done()
+			outPtr := (*C.Elem)(&out.Data[start])
+			aPtr := (*C.Elem)(&a.Data[start*a.Cols])
+			C.matMulVecPacked(outPtr, aPtr, bPtr, C.size_t(rows), C.size_t(a.Cols))
+		}(start, rows)
+	}
+	wg.Wait()
+	out.DropLastRows(8)
+
+	return out
+}
+
 func (m *Matrix) Transpose() {
 	if m.Cols == 1 {
 		m.Cols = m.Rows
@@ -0,0 +1,64 @@
+package pir
+
+// #include "pir.h"
+import "C"
+
+// Modulus switching: the server rounds Z_q elems to fewer bits before
+// sending them, and the client scales them back up, at the cost of a small
+// error that PickParams accounts for with a smaller p.
+//
+// For the hint, the client computes H·s from the rounded H, so the rounding
+// error gets multiplied by the secret s; clients with a compressed hint hence
+// draw s from the error distribution instead of uniformly, which is as hard
+// (and is what EstimateSecurity assumes anyway).
+
+// Rounds x in Z_{2^logq} to Z_{2^bits}.
+func switchModulus(x, logq, bits uint64) uint64 {
+	shift := logq - bits
+	if shift == 0 {
+		return x
+	}
+	// Overflowing into bit 64 only drops a multiple of 2^bits.
+	return modQ((x+(1<<(shift-1)))>>shift, bits)
+}
+
+// Returns a copy of m with every entry rounded from logq to bits bits.
+func (m *Matrix) switchModulus(logq, bits uint64) *Matrix {
+	out := MatrixNew(m.Rows, m.Cols)
+	for i := range out.Data {
+		out.Data[i] = C.Elem(switchModulus(uint64(m.Data[i]), logq, bits))
+	}
+	return out
+}
+
+// Returns the hint to send to clients: Setup's hint rounded to p.HintBits()
+// bits per elem, or the hint itself if p does not compress it. The server
+// keeps the full hint, e.g. to update it.
+func CompressHint(hint Msg, p Params) Msg {
+	if p.HintBits() == p.Logq {
+		return hint
+	}
+	var out Msg
+	for _, m := range hint.Data {
+		out.Data = append(out.Data, m.switchModulus(p.Logq, p.HintBits()))
+	}
+	return out
+}
+
+// Rounds a SimplePIR answer to p.AnswerBits() bits per elem; recoverElems
+// scales it back up. Unlike for the hint, the rounding error is only added
+// to the noise, so a few bits more than log(p) suffice.
+func compressAnswer(ans *Matrix, p Params) *Matrix {
+	if p.AnswerBits() == p.Logq {
+		return ans
+	}
+	return ans.switchModulus(p.Logq, p.AnswerBits())
+}
+
+// Same as CompressHint, for a hint patch.
+func CompressHintPatch(patch HintPatch, p Params) HintPatch {
+	if p.HintBits() == p.Logq || patch.Data == nil {
+		return patch
+	}
+	return HintPatch{Rows: patch.Rows, Data: patch.Data.switchModulus(p.Logq, p.HintBits())}
+}
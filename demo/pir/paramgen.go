@@ -0,0 +1,354 @@
+package pir
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Parameter generation: picks the LWE error stddev sigma for a target level
+// of security, and the largest plaintext modulus p for which decryption
+// fails with at most a given probability. PickParams uses it for the
+// (n, logq, m) that the embedded params.csv table does not cover.
+//
+// Security is estimated against the primal uSVP attack, with the 2016
+// estimate for when BKZ-beta finds the short vector, and the core-SVP cost
+// 0.292*beta + 16.4 + log(8d) of BKZ-beta in dimension d. This is simpler
+// and somewhat more conservative than the lattice estimator the table was
+// built with: it puts the table's n=2^10, sigma=6.4 at about 126 bits.
+
+// Target security, in bits, when none is given.
+const DefaultSecurity = 128
+
+// Log of the probability that some Z_p elem of an answer decodes wrongly,
+// as for the embedded table.
+const DefaultLogFailure = -40
+
+// Generated params never use a smaller error than this.
+const MinSigma = 3.2
+
+// Named choices of the LWE secret dimension and modulus, which PickParams
+// pairs with an error that gives the named level of security.
+type SecurityPreset struct {
+	Name     string
+	N        uint64  // LWE secret dimension
+	Logq     uint64  // (logarithm of) ciphertext modulus
+	Security float64 // target bits of security
+}
+
+// The presets for the elem width of this build (see elem32.go and elem64.go).
+var Presets = []SecurityPreset{Preset128, Preset192, Preset256}
+
+// Returns the preset with the given name.
+func LookupPreset(name string) (SecurityPreset, error) {
+	for _, s := range Presets {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return SecurityPreset{}, fmt.Errorf("%w: unknown security preset %q", ErrBadParams, name)
+}
+
+// One row of the params table: sigma and the plaintext moduli of SimplePIR
+// and DoublePIR for LWE with secret dimension N, modulus 2^Logq and up to M
+// samples.
+type LWEParams struct {
+	N       uint64
+	M       uint64
+	Logq    uint64
+	Sigma   float64
+	PSimple uint64
+	PDouble uint64
+}
+
+// Log of the root Hermite factor that BKZ-beta achieves.
+func logDelta0(beta float64) float64 {
+	return (math.Log(math.Pi*beta)/beta + math.Log(beta/(2*math.Pi*math.E))) / (2 * (beta - 1))
+}
+
+// Estimates the bits of security of LWE with secret dimension n, modulus
+// 2^logq and error stddev sigma, when the attacker sees up to m samples.
+// Returns +Inf if the primal attack fails for every block size.
+func EstimateSecurity(n, logq, m uint64, sigma float64) float64 {
+	best := math.Inf(1)
+	for k := uint64(1); k <= m && k <= 4*n; k++ {
+		d := k + n + 1
+		// Whether BKZ-beta finds the short vector. Holds for every block
+		// size past the smallest one that works, so binary search for it.
+		works := func(beta uint64) bool {
+			b := float64(beta)
+			lhs := math.Log(sigma) + math.Log(b)/2
+			rhs := (2*b-float64(d)-1)*logDelta0(b) + float64(k*logq)/float64(d)*math.Ln2
+			return lhs <= rhs
+		}
+		lo, hi := uint64(40), d
+		if !works(hi) {
+			continue
+		}
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if works(mid) {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		cost := 0.292*float64(lo) + 16.4 + math.Log2(8*float64(d))
+		if cost < best {
+			best = cost
+		}
+	}
+	return best
+}
+
+// Returns the largest plaintext modulus p with which a SimplePIR (or
+// DoublePIR) answer over m DB columns fails to decode with probability at
+// most 2^log_failure, for LWE error stddev sigma. Returns 0 if there is none.
+func PlaintextModulus(n, logq, m uint64, sigma, log_failure float64, doublepir bool) uint64 {
+	q := math.Exp2(float64(logq))
+	p := uint64(0)
+	kappa := 1.0 // number of base-p digits per Z_q elem, for DoublePIR
+	for try := 0; try < 4; try++ {
+		// A SimplePIR answer elem is correct if its error, a Gaussian of
+		// stddev p*sigma*sqrt(m)/2 at most, is below q/2p. DoublePIR
+		// decodes 2(n+1)*kappa such elems, so a union bound over them.
+		failure := math.Exp2(log_failure)
+		if doublepir {
+			failure /= 2 * float64(n+1) * kappa
+		}
+		z := math.Sqrt(2 * math.Log(2/failure))
+		p = uint64(math.Sqrt(q / (sigma * math.Sqrt(float64(m)) * z)))
+		if p < 2 || !doublepir {
+			break
+		}
+		kappa = math.Ceil(float64(logq) / math.Log2(float64(p)))
+	}
+	if p < 2 {
+		return 0
+	}
+	return p
+}
+
+// Same as PlaintextModulus for SimplePIR, when clients get the hint rounded
+// to hint_logq bits per elem. Each answer elem then also carries the error
+// e·s, where the n entries of e are uniform in [-2^(b-1), 2^(b-1)] for
+// b = logq-hint_logq, and the secret s is drawn from the error distribution.
+func HintPlaintextModulus(n, logq, hint_logq, m uint64, sigma, log_failure float64) uint64 {
+	if hint_logq == 0 || hint_logq >= logq {
+		return PlaintextModulus(n, logq, m, sigma, log_failure, false)
+	}
+	q := math.Exp2(float64(logq))
+	z := math.Sqrt(2 * math.Log(2/math.Exp2(log_failure)))
+
+	// The error has variance a*p^2 + b, and must stay below q/2p with
+	// z standard deviations to spare: a*p^4 + b*p^2 <= t.
+	a := sigma * sigma * float64(m) / 4
+	b := float64(n) * sigma * sigma * math.Exp2(2*float64(logq-hint_logq)) / 12
+	t := q * q / (4 * z * z)
+	p := uint64(math.Sqrt(2 * t / (b + math.Sqrt(b*b+4*a*t))))
+	if p < 2 {
+		return 0
+	}
+	return p
+}
+
+// Same as HintPlaintextModulus, when clients also get each answer elem
+// rounded to answer_logq bits, which adds an error of up to
+// 2^(logq-answer_logq-1) to it.
+func CompressedPlaintextModulus(n, logq, hint_logq, answer_logq, m uint64, sigma, log_failure float64) uint64 {
+	hint_p := HintPlaintextModulus(n, logq, hint_logq, m, sigma, log_failure)
+	if answer_logq == 0 || answer_logq >= logq || hint_p == 0 {
+		return hint_p
+	}
+	// The largest p whose error, with the rounding error, stays below q/2p
+	// with the target probability.
+	decodes := func(p uint64) bool {
+		return LogFailureBound(n, logq, hint_logq, answer_logq, m, p, sigma, false) <= log_failure
+	}
+	lo, hi := uint64(1), hint_p
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if decodes(mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo < 2 {
+		return 0
+	}
+	return lo
+}
+
+// Returns the smallest sigma (a multiple of 0.1, and at least MinSigma) that
+// gives the target bits of security to LWE with secret dimension n, modulus
+// 2^logq and up to m samples.
+func PickSigma(n, logq, m uint64, security float64) (float64, error) {
+	if n == 0 || logq == 0 || logq > 64 || m == 0 {
+		return 0, ErrNeedDims
+	}
+	secure := func(sigma float64) bool {
+		return EstimateSecurity(n, logq, m, sigma) >= security
+	}
+	if secure(MinSigma) {
+		return MinSigma, nil
+	}
+
+	// Grow the error until it is secure, as long as some p > 1 still decodes.
+	lo, hi := MinSigma, 2*MinSigma
+	for !secure(hi) {
+		if PlaintextModulus(n, logq, m, hi, DefaultLogFailure, false) == 0 {
+			return 0, fmt.Errorf("%w: no sigma gives %.0f bits of security for n=%d, logq=%d",
+				ErrNoParams, security, n, logq)
+		}
+		lo, hi = hi, 2*hi
+	}
+	for hi-lo > 0.01 {
+		mid := (lo + hi) / 2
+		if secure(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return math.Ceil(hi*10) / 10, nil
+}
+
+// Generates the params for LWE with secret dimension n, modulus 2^logq and
+// up to m samples, with the target bits of security and a probability of at
+// most 2^log_failure that an answer elem decodes wrongly.
+func GenerateLWEParams(n, logq, m uint64, security, log_failure float64) (LWEParams, error) {
+	sigma, err := PickSigma(n, logq, m, security)
+	if err != nil {
+		return LWEParams{}, err
+	}
+	return lweParamsFor(n, logq, m, sigma, log_failure)
+}
+
+// Same as GenerateLWEParams, with a given sigma.
+func lweParamsFor(n, logq, m uint64, sigma, log_failure float64) (LWEParams, error) {
+	out := LWEParams{N: n, M: m, Logq: logq, Sigma: sigma}
+	out.PSimple = PlaintextModulus(n, logq, m, sigma, log_failure, false)
+	out.PDouble = PlaintextModulus(n, logq, m, sigma, log_failure, true)
+	if out.PSimple == 0 || out.PDouble == 0 {
+		return LWEParams{}, fmt.Errorf("%w: no plaintext modulus for n=%d, logq=%d, m=%d, sigma=%f",
+			ErrNoParams, n, logq, m, sigma)
+	}
+	return out, nil
+}
+
+// Generates a table of params for n and logq in the format of the embedded
+// params.csv, with one row for each power of two m from 2^min_logm to
+// 2^max_logm, and writes it to w.
+func GenerateParamsTable(w io.Writer, n, logq, min_logm, max_logm uint64, security, log_failure float64) error {
+	var rows []LWEParams
+	for logm := min_logm; logm <= max_logm; logm++ {
+		row, err := GenerateLWEParams(n, logq, 1<<logm, security, log_failure)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	return WriteParamsTable(w, rows)
+}
+
+// Writes rows in the format of the embedded params.csv. N and M must be
+// powers of two.
+func WriteParamsTable(w io.Writer, rows []LWEParams) error {
+	if _, err := fmt.Fprintln(w, "log(n),log(m),log(q),sigma,log(p_simple),p_simple,p_double"); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if bits.OnesCount64(r.N) != 1 || bits.OnesCount64(r.M) != 1 {
+			return fmt.Errorf("%w: n=%d and m=%d must be powers of two", ErrBadParams, r.N, r.M)
+		}
+		_, err := fmt.Fprintf(w, "%d,%d,%d,%f,%d,%d,%d\n", bits.TrailingZeros64(r.N),
+			bits.TrailingZeros64(r.M), r.Logq, r.Sigma, bits.Len64(r.PSimple)-1, r.PSimple, r.PDouble)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sigmaKey struct {
+	n, logq, m uint64
+	security   float64
+}
+
+// Sigmas already picked by generatedSigma, since PickParams tries many m.
+var (
+	sigmaCacheMu sync.Mutex
+	sigmaCache   = map[sigmaKey]float64{}
+)
+
+// Returns the sigma to generate params with when the embedded table has no
+// row for (n, logq, m): the table's sigma if it has rows for (n, logq) at
+// smaller m, since sigma does not grow with m; otherwise the sigma of the
+// preset for (n, logq), or else of DefaultSecurity.
+func generatedSigma(n, logq, m uint64) (float64, error) {
+	for _, r := range paramsTable() {
+		if r.N == n && r.Logq == logq {
+			return r.Sigma, nil
+		}
+	}
+	security := float64(DefaultSecurity)
+	for _, s := range Presets {
+		if s.N == n && s.Logq == logq {
+			security = s.Security
+		}
+	}
+
+	// EstimateSecurity ignores samples past the first 4n.
+	if m > 4*n {
+		m = 4 * n
+	}
+	key := sigmaKey{n, logq, m, security}
+	sigmaCacheMu.Lock()
+	defer sigmaCacheMu.Unlock()
+	if sigma, ok := sigmaCache[key]; ok {
+		return sigma, nil
+	}
+	sigma, err := PickSigma(n, logq, m, security)
+	if err == nil {
+		sigmaCache[key] = sigma
+	}
+	return sigma, err
+}
+
+// Returns log2 of an upper bound on the probability that a SimplePIR answer
+// elem over m DB columns decodes wrongly, or, for DoublePIR, that any of the
+// 2(n+1)*kappa elems that the client decodes per query does, with plaintext
+// modulus p and LWE error stddev sigma. As for CompressedPlaintextModulus,
+// hint_logq and answer_logq are the bits per elem of the hint and answers
+// that clients download. Returns 0 if decoding can always fail.
+func LogFailureBound(n, logq, hint_logq, answer_logq, m, p uint64, sigma float64, doublepir bool) float64 {
+	if p < 2 {
+		return 0
+	}
+	q := math.Exp2(float64(logq))
+	f := float64(p)
+	variance := sigma * sigma * float64(m) * f * f / 4
+	if hint_logq != 0 && hint_logq < logq {
+		variance += float64(n) * sigma * sigma * math.Exp2(2*float64(logq-hint_logq)) / 12
+	}
+	slack := q / (2 * f)
+	if answer_logq != 0 && answer_logq < logq {
+		slack -= math.Exp2(float64(logq - answer_logq - 1))
+	}
+	if slack <= 0 {
+		return 0
+	}
+
+	// Gaussian tail: an error of stddev s exceeds z*s with probability at
+	// most 2*exp(-z^2/2).
+	z := slack / math.Sqrt(variance)
+	log_failure := 1 - z*z/(2*math.Ln2)
+	if doublepir {
+		kappa := math.Ceil(float64(logq) / math.Log2(f))
+		log_failure += math.Log2(2 * float64(n+1) * kappa)
+	}
+	return math.Min(log_failure, 0)
+}
@@ -4,7 +4,11 @@
 
 // Hard-coded, to allow for compiler optimizations:
 #define COMPRESSION 3
+#ifdef PIR_ELEM64
+#define BASIS       20
+#else
 #define BASIS       10
+#endif
 #define BASIS2      BASIS*2
 #define MASK        (1<<BASIS)-1
 
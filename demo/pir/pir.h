@@ -1,7 +1,12 @@
 #include <stdint.h>
 #include <stddef.h>
 
+// Elems are 32 bits wide, unless built with the elem64 tag.
+#ifdef PIR_ELEM64
+typedef uint64_t Elem;
+#else
 typedef uint32_t Elem;
+#endif
 
 void transpose(Elem *out, const Elem *in, size_t rows, size_t cols);
 
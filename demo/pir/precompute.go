@@ -0,0 +1,84 @@
+package pir
+
+import (
+	"context"
+	"fmt"
+)
+
+// Query precomputation: most of the work of a query does not depend on the
+// queried index. For SimplePIR, the client spends m·n operations on
+// A·secret + error when it builds a query and l·n on H·secret when it
+// recovers the answer, while the index only adds Delta at one position. A
+// client can thus build a pool of queries while idle (see Client.Precompute),
+// and only finish one off when it is asked for an index.
+//
+// Each precomputed query is used once: reusing its secret would let the
+// server subtract two queries and learn the difference of their indices.
+
+// Implemented by schemes that can build a query before its index is known.
+type QueryPrecomputer interface {
+	// Returns the client state and the query for an index still to be chosen,
+	// having done whatever Query and Recover do that does not depend on it.
+	PrecomputeQuery(shared State, offline Msg, p Params, info DBinfo, prg *BufPRGReader) (State, Msg, error)
+
+	// Turns a precomputed query into a query for index i, in place.
+	FinishQuery(i uint64, query Msg, p Params, info DBinfo) error
+}
+
+type precomputedQuery struct {
+	secret State
+	query  Msg
+}
+
+// Adds count precomputed queries to the client's pool, which Query draws
+// from before it builds any query from scratch. Meant to run while the client
+// is idle, e.g., in a goroutine of its own: it only holds the client for one
+// query at a time, and stops with ctx's error once ctx is cancelled. Fails
+// with ErrNotSupported for schemes that are not QueryPrecomputers.
+func (c *Client) Precompute(ctx context.Context, count int) error {
+	pre, ok := c.pi.(QueryPrecomputer)
+	if !ok {
+		return fmt.Errorf("%w: %s cannot precompute queries", ErrNotSupported, c.pi.Name())
+	}
+
+	for k := 0; k < count; k++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		secret, query, err := pre.PrecomputeQuery(c.shared, c.hint, c.params, c.info, c.prg)
+		if err == nil {
+			c.pool = append(c.pool, precomputedQuery{secret, query})
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the number of precomputed queries left in the client's pool.
+func (c *Client) Precomputed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pool)
+}
+
+// Builds the query for index i, from the pool if it is not empty.
+func (c *Client) nextQuery(i uint64) (State, Msg, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The pool is used in order, so that a seeded client sends the same
+	// queries whether or not it precomputed them.
+	if len(c.pool) > 0 {
+		pre := c.pool[0]
+		if err := c.pi.(QueryPrecomputer).FinishQuery(i, pre.query, c.params, c.info); err != nil {
+			return State{}, Msg{}, err
+		}
+		c.pool = c.pool[1:]
+		return pre.secret, pre.query, nil
+	}
+	return c.pi.QueryChecked(i, c.shared, c.params, c.info, c.prg)
+}
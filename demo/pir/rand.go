@@ -33,23 +33,8 @@ import (
 
 type PRGKey [aes.BlockSize]byte
 
-var prgMutex sync.Mutex
-var bufPrgReader *BufPRGReader
-
 const bufSize = 8192
 
-// Produce a random integer in Z_p where mod is the value p.
-func RandInt(mod *big.Int) *big.Int {
-	prgMutex.Lock()
-	out := bufPrgReader.RandInt(mod)
-	prgMutex.Unlock()
-	return out
-}
-
-func MathRand() *mrand.Rand {
-	return mrand.New(bufPrgReader)
-}
-
 // We use the AES-CTR to generate pseudo-random  numbers using a
 // stream cipher. Go's native rand.Reader is extremely slow because
 // it makes tons of system calls to generate a small number of
@@ -62,10 +47,13 @@ type PRGReader struct {
 	stream cipher.Stream
 }
 
+// Buffered PRG, safe for concurrent use. Each session should own its own
+// reader, so that sessions with different seeds do not interfere.
 type BufPRGReader struct {
 	mrand.Source64
 	Key    PRGKey
 	stream *bufio.Reader
+	mu     sync.Mutex
 }
 
 func NewPRG(key *PRGKey) *PRGReader {
@@ -116,8 +104,16 @@ func NewBufPRG(prg *PRGReader) *BufPRGReader {
 	return out
 }
 
+// Returns a new buffered PRG with a fresh random key.
+func RandomBufPRG() *BufPRGReader {
+	return NewBufPRG(RandomPRG())
+}
+
+// Produce a random integer in Z_p where mod is the value p.
 func (b *BufPRGReader) RandInt(mod *big.Int) *big.Int {
+	b.mu.Lock()
 	out, err := rand.Int(b.stream, mod)
+	b.mu.Unlock()
 	if err != nil {
 		// TODO: Replace this with non-absurd error handling.
 		panic("Catastrophic randomness failure!")
@@ -134,7 +130,7 @@ func (b *BufPRGReader) Int63() int64 {
 func (b *BufPRGReader) Uint64() uint64 {
 	var buf [8]byte
 
-	prgMutex.Lock()
+	b.mu.Lock()
 	read := 0
 	for read < 8 {
 		n, err := b.stream.Read(buf[read:8])
@@ -143,7 +139,7 @@ func (b *BufPRGReader) Uint64() uint64 {
 		}
 		read += n
 	}
-	prgMutex.Unlock()
+	b.mu.Unlock()
 
 	return binary.LittleEndian.Uint64(buf[:])
 }
@@ -152,6 +148,6 @@ func (b *BufPRGReader) Seed(int64) {
 	panic("Should never call seed")
 }
 
-func init() {
-	bufPrgReader = NewBufPRG(RandomPRG())
+func (b *BufPRGReader) MathRand() *mrand.Rand {
+	return mrand.New(b)
 }
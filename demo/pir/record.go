@@ -0,0 +1,314 @@
+package pir
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Record PIR: each DB entry holds one variable-length record (e.g., all the
+// fields of a product), padded to a fixed size and spread over the entry's Ne
+// Z_p elems, floor(log p) bits per elem. The first recordHeaderBytes bytes of
+// an entry store the record's length.
+//
+// Records are wider than 64 bits, so they cannot be read with GetElem or
+// Client.Recover; use GetRecord and Client.RecoverRecords instead.
+
+const recordHeaderBytes = 4
+
+const maxRecordParamsTries = 8
+
+// Returns the number of bits of a record that each Z_p elem stores.
+func recordBitsPerElem(p uint64) uint64 {
+	return uint64(math.Floor(math.Log2(float64(p))))
+}
+
+// Returns the number of Z_p elems needed to store a record of up to max_len bytes.
+func recordElems(max_len, p uint64) uint64 {
+	bits := recordBitsPerElem(p)
+	return (8*(max_len+recordHeaderBytes) + bits - 1) / bits
+}
+
+// Returns the largest DB entry size (in bits) that still maps to
+// recordElems(max_len, p) Z_p elems.
+func recordRowLength(max_len, p uint64) uint64 {
+	ne := recordElems(max_len, p)
+	row_length := uint64(float64(ne) * math.Log2(float64(p)))
+	for Compute_num_entries_base_p(p, row_length) > ne {
+		row_length -= 1
+	}
+	return row_length
+}
+
+// Picks params for a DB of N records of up to max_len bytes each, and returns
+// them along with the DB entry size (in bits) to pass to MakeRecordDB.
+func PickRecordParams(pi CheckedPIR, N, max_len, n, logq uint64) (Params, uint64, error) {
+	if N == 0 {
+		return Params{}, 0, ErrEmptyDB
+	}
+
+	// The entry size sets p, which in turn sets how many bits each Z_p elem
+	// holds, so grow the entry until the picked p leaves enough room.
+	row_length := 8 * (max_len + recordHeaderBytes)
+	for try := 0; try < maxRecordParamsTries; try++ {
+		p, err := pi.PickParamsChecked(N, row_length, n, logq)
+		if err != nil {
+			return Params{}, 0, err
+		}
+		if p.P < 4 {
+			return Params{}, 0, fmt.Errorf("%w: p=%d", ErrInvalidParams, p.P)
+		}
+
+		_, ne, _ := Num_DB_entries(N, row_length, p.P)
+		if ne >= recordElems(max_len, p.P) {
+			return p, row_length, nil
+		}
+		row_length = recordRowLength(max_len, p.P)
+	}
+
+	return Params{}, 0, fmt.Errorf("%w: no stable params for %d-byte records", ErrNoParams, max_len)
+}
+
+// Builds a DB that holds records[i] as entry i, for params and entry size
+// (in bits) returned by PickRecordParams.
+func MakeRecordDB(records [][]byte, row_length uint64, p *Params) (*Database, error) {
+	D, err := SetupDBChecked(uint64(len(records)), row_length, p)
+	if err != nil {
+		return nil, err
+	}
+	if D.Info.Packing > 0 {
+		return nil, fmt.Errorf("%w: %d-bit entries fit in a single Z_p elem", ErrBadParams, row_length)
+	}
+
+	bits := recordBitsPerElem(p.P)
+	if D.Info.Ne*bits/8 < recordHeaderBytes {
+		return nil, fmt.Errorf("%w: %d-bit entries are too small for records", ErrBadParams, row_length)
+	}
+	max_len := D.Info.Ne*bits/8 - recordHeaderBytes
+	for i, rec := range records {
+		if uint64(len(rec)) > max_len {
+			return nil, fmt.Errorf("%w: record %d has %d bytes, entries hold %d",
+				ErrBadInput, i, len(rec), max_len)
+		}
+	}
+
+	D.Data = MatrixZeros(p.L, p.M)
+	for i, rec := range records {
+		elems := recordToElems(rec, D.Info.Ne, bits)
+		for j := uint64(0); j < D.Info.Ne; j++ {
+			D.Data.Set(elems[j], (uint64(i)/p.M)*D.Info.Ne+j, uint64(i)%p.M)
+		}
+	}
+
+	// Map DB elems to [-p/2; p/2]
+	D.Data.Sub(p.P / 2)
+
+	return D, nil
+}
+
+// Returns record i of a DB built with MakeRecordDB. The DB must not be squished.
+func (DB *Database) GetRecord(i uint64) ([]byte, error) {
+	if i >= DB.Info.Num {
+		return nil, fmt.Errorf("%w: index %d, database has %d entries", ErrIndexOutOfRange, i, DB.Info.Num)
+	}
+	if DB.Info.Squishing != 0 {
+		return nil, fmt.Errorf("%w: database is squished", ErrBadState)
+	}
+
+	col := i % DB.Data.Cols
+	row := i / DB.Data.Cols
+	var vals []uint64
+	for j := row * DB.Info.Ne; j < (row+1)*DB.Info.Ne; j++ {
+		vals = append(vals, DB.Data.Get(j, col))
+	}
+
+	return elemsToRecord(unmapElems(vals, DB.Info), recordBitsPerElem(DB.Info.P))
+}
+
+// Splits the length-prefixed record into ne chunks of bits bits each.
+func recordToElems(rec []byte, ne, bits uint64) []uint64 {
+	buf := make([]byte, recordHeaderBytes, recordHeaderBytes+len(rec))
+	binary.LittleEndian.PutUint32(buf, uint32(len(rec)))
+	buf = append(buf, rec...)
+
+	elems := make([]uint64, ne)
+	for pos := uint64(0); pos < 8*uint64(len(buf)); pos++ {
+		bit := uint64(buf[pos/8]>>(pos%8)) & 1
+		elems[pos/bits] |= bit << (pos % bits)
+	}
+	return elems
+}
+
+// Inverse of recordToElems; fails if the elems do not decode to a record,
+// e.g. because the answer was corrupted.
+func elemsToRecord(elems []uint64, bits uint64) ([]byte, error) {
+	buf := make([]byte, uint64(len(elems))*bits/8)
+	for j, e := range elems {
+		if e>>bits != 0 {
+			return nil, fmt.Errorf("%w: elem %d out of range", ErrReconstructFailed, j)
+		}
+	}
+	for pos := uint64(0); pos < 8*uint64(len(buf)); pos++ {
+		bit := byte(elems[pos/bits]>>(pos%bits)) & 1
+		buf[pos/8] |= bit << (pos % 8)
+	}
+
+	if len(buf) < recordHeaderBytes {
+		return nil, fmt.Errorf("%w: entry too small for a record", ErrReconstructFailed)
+	}
+	length := uint64(binary.LittleEndian.Uint32(buf))
+	if length > uint64(len(buf)-recordHeaderBytes) {
+		return nil, fmt.Errorf("%w: record length %d", ErrReconstructFailed, length)
+	}
+	return buf[recordHeaderBytes : recordHeaderBytes+length], nil
+}
+
+// Same as Recover, for a DB built with MakeRecordDB: returns the whole record
+// stored at each queried index.
+func (c *Client) RecoverRecords(pending *PendingQuery, answer Msg) ([][]byte, error) {
+	if pending == nil {
+		return nil, fmt.Errorf("%w: no pending query", ErrBadState)
+	}
+
+	bits := recordBitsPerElem(c.params.P)
+	var records [][]byte
+	for j, i := range pending.indices {
+		elems, err := c.pi.RecoverElemsChecked(i, uint64(j), c.Hint(), pending.query.Data[j], answer,
+			c.shared, pending.secrets[j], c.params, c.info)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := elemsToRecord(elems, bits)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Bucketed records: padding every record to the longest one blows a DB of
+// mostly short records up many times over, so records can instead be split
+// into buckets of doubling widths (minBytes, 2·minBytes, ...), each served as
+// its own record DB. Each record sits in the narrowest bucket that holds it,
+// which pads it to less than twice its length. To hide which bucket a record
+// is in, a client queries every bucket, at slot 0 of those it does not need.
+type RecordLayout struct {
+	MinBytes uint64 // width of bucket 0, in bytes
+
+	bucket []uint8  // bucket of each record
+	slot   []uint64 // index of each record in its bucket
+	sizes  []uint64 // number of records in each bucket
+}
+
+// Buckets past this many would hold records of over 2^40 minBytes.
+const maxRecordBuckets = 40
+
+// Assigns records of the given lengths (in bytes) to buckets whose widths
+// start at minBytes.
+func NewRecordLayout(lengths []uint64, minBytes uint64) (*RecordLayout, error) {
+	if minBytes == 0 {
+		return nil, fmt.Errorf("%w: buckets of 0 bytes", ErrBadParams)
+	}
+	bucket := make([]uint8, len(lengths))
+	for i, l := range lengths {
+		b := uint8(0)
+		for minBytes<<b < l {
+			b++
+			if b >= maxRecordBuckets {
+				return nil, fmt.Errorf("%w: record %d has %d bytes", ErrBadInput, i, l)
+			}
+		}
+		bucket[i] = b
+	}
+	return newRecordLayout(bucket, minBytes), nil
+}
+
+func newRecordLayout(bucket []uint8, minBytes uint64) *RecordLayout {
+	l := &RecordLayout{MinBytes: minBytes, bucket: bucket, slot: make([]uint64, len(bucket))}
+	for i, b := range bucket {
+		for uint64(len(l.sizes)) <= uint64(b) {
+			l.sizes = append(l.sizes, 0)
+		}
+		l.slot[i] = l.sizes[b]
+		l.sizes[b]++
+	}
+	return l
+}
+
+// Returns the number of records.
+func (l *RecordLayout) Num() uint64 {
+	return uint64(len(l.bucket))
+}
+
+// Returns the number of buckets; some of them may be empty.
+func (l *RecordLayout) Buckets() uint64 {
+	return uint64(len(l.sizes))
+}
+
+// Returns the number of records in bucket b.
+func (l *RecordLayout) Size(b uint64) uint64 {
+	return l.sizes[b]
+}
+
+// Returns the largest record length (in bytes) that bucket b holds.
+func (l *RecordLayout) Width(b uint64) uint64 {
+	return l.MinBytes << b
+}
+
+// Returns the bucket of record i, and its index in that bucket.
+func (l *RecordLayout) Locate(i uint64) (uint64, uint64, error) {
+	if i >= l.Num() {
+		return 0, 0, fmt.Errorf("%w: index %d, layout has %d records", ErrIndexOutOfRange, i, l.Num())
+	}
+	return uint64(l.bucket[i]), l.slot[i], nil
+}
+
+// Splits records, laid out by l, into the records of each bucket.
+func (l *RecordLayout) Split(records [][]byte) ([][][]byte, error) {
+	if uint64(len(records)) != l.Num() {
+		return nil, fmt.Errorf("%w: %d records, layout has %d", ErrBadInput, len(records), l.Num())
+	}
+	out := make([][][]byte, l.Buckets())
+	for b := range out {
+		out[b] = make([][]byte, 0, l.sizes[b])
+	}
+	for i, rec := range records {
+		b := l.bucket[i]
+		if uint64(len(rec)) > l.Width(uint64(b)) {
+			return nil, fmt.Errorf("%w: record %d has %d bytes, bucket %d holds %d",
+				ErrBadInput, i, len(rec), b, l.Width(uint64(b)))
+		}
+		out[b] = append(out[b], rec)
+	}
+	return out, nil
+}
+
+func (l RecordLayout) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireRecordLayout)
+	buf = appendUvarint(buf, l.MinBytes)
+	buf = appendBlob(buf, l.bucket)
+	return buf, nil
+}
+
+// Decodes a layout, and recomputes where every record lives.
+func (l *RecordLayout) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireRecordLayout)
+	minBytes := d.uvarint()
+	bucket := d.blob()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if minBytes == 0 {
+		return fmt.Errorf("%w: buckets of 0 bytes", ErrBadEncoding)
+	}
+	for i, b := range bucket {
+		if b >= maxRecordBuckets {
+			return fmt.Errorf("%w: record %d in bucket %d", ErrBadEncoding, i, b)
+		}
+	}
+
+	*l = *newRecordLayout(append([]uint8(nil), bucket...), minBytes)
+	return nil
+}
@@ -2,6 +2,8 @@ package pir
 
 import (
 	"bufio"
+	"context"
+	"encoding"
 	"encoding/binary"
 	"encoding/csv"
 	"encoding/gob"
@@ -9,21 +11,32 @@ import (
 	"io"
 	"math"
 	"os"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 type TestingInterface interface {
 	Fatalf(format string, args ...interface{})
 }
 
-const LOGQ = uint64(32)
-const SEC_PARAM = uint64(1 << 10)
+// Security level of the demo's PIR params: the LWE dimension and modulus,
+// from which PickParams picks the error and plaintext modulus.
+var demoPreset = Preset128
+
+// Returns the scheme that serves the demo's queries: the one named by the
+// PIR_SCHEME environment variable (e.g., DoublePIR), or SimplePIR if unset.
+func DemoScheme() (CheckedPIR, error) {
+	name := os.Getenv("PIR_SCHEME")
+	if name == "" {
+		name = "SimplePIR"
+	}
+	return SchemeByName(name)
+}
 
 var (
 	globalDB               *EnhancedDatabase
@@ -592,92 +605,6 @@ func TestConvertCSVToBinary(t *testing.T) {
 	}
 }
 
-// CAPTURING AND EXTRACTION OUTPUT FUNCTIONS USED BY LOGGER -----------------------------------------------------------------------------
-func CaptureOutput(f func()) string {
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	f()
-
-	w.Close()
-	os.Stdout = old
-
-	var buf strings.Builder
-	io.Copy(&buf, r)
-
-	fmt.Print(buf.String())
-
-	return buf.String()
-}
-
-func ExtractPIRMetrics(output string) map[string]float64 {
-	metrics := map[string]float64{
-		"setup_time":       0.0,
-		"query_time":       0.0,
-		"answer_time":      0.0,
-		"reconstruct_time": 0.0,
-		"offline_download": 0.0,
-		"online_upload":    0.0,
-		"online_download":  0.0,
-	}
-
-	timePatterns := map[string]string{
-		"setup_time":       `Setup\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-		"query_time":       `Building query\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-		"answer_time":      `Answering query\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-		"reconstruct_time": `Reconstructing\.\.\.\s+Success!\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-	}
-
-	dataPatterns := map[string]string{
-		"offline_download": `Offline download: ([\d\.]+) KB`,
-		"online_upload":    `Online upload: ([\d\.]+) KB`,
-		"online_download":  `Online download: ([\d\.]+) KB`,
-	}
-
-	convertToMs := func(value float64, unit string) float64 {
-		switch unit {
-		case "µs":
-			return value / 1000
-		case "ms":
-			return value
-		case "s":
-			return value * 1000
-		default:
-			return value
-		}
-	}
-
-	metricsFound := make(map[string]bool)
-
-	// extract time metrics
-	for name, pattern := range timePatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(output); len(matches) >= 3 {
-			value, _ := strconv.ParseFloat(matches[1], 64)
-			metrics[name] = convertToMs(value, matches[2])
-			metricsFound[name] = true
-		}
-	}
-
-	// extract data transfer metrics
-	for name, pattern := range dataPatterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(output); len(matches) >= 2 {
-			value, _ := strconv.ParseFloat(matches[1], 64)
-			metrics[name] = value
-			metricsFound[name] = true
-		}
-	}
-	for name := range metrics {
-		if !metricsFound[name] {
-			fmt.Printf("Metric '%s' not found in output\n", name)
-		}
-	}
-
-	return metrics
-}
-
 // LOGGING RESULTS FUNCTION --------------------------------------------------------------------------------------------------
 func LogTestResults(testName string, params map[string]string, metrics map[string]float64) {
 	os.MkdirAll("../../results", 0755)
@@ -732,33 +659,25 @@ func LogTestResults(testName string, params map[string]string, metrics map[strin
 }
 
 // TESTING QUERY PRODUCT BY ID FUNCTION ---------------------------------------------------------------------------------------------
-func QueryProductByID(t TestingInterface, productID uint64, DBSize uint64, recordSize uint64) {
-	fmt.Printf("Starting SimplePIR query for product ID %d...\n", productID)
-
-	pir := SimplePIR{}
-
-	_, allPirKeys, columns, baseRecordSize, err := LoadDatabaseOnce()
-	if err != nil {
-		t.Fatalf("Failed to load database: %v", err)
+func QueryProductByID(t TestingInterface, pi CheckedPIR, productID uint64, DBSize uint64, recordSize uint64) {
+	if err := QueryProduct(pi, productID, DBSize, recordSize); err != nil {
+		t.Fatalf("%v", err)
 	}
+}
 
-	var pirKeys []uint64
-	var actualRecordSize uint64
-
-	pirKeys = allPirKeys
+// Same as QueryProductByID, but returns an error instead of failing the test,
+// so that the demo server can report bad queries without crashing. The full
+// record is retrieved through PIR from a DB of the first DBSize records (all
+// of them if DBSize is 0) with scheme pi; if recordSize is non-zero, each
+// record is cut to recordSize bits.
+func QueryProduct(pi CheckedPIR, productID uint64, DBSize uint64, recordSize uint64) error {
+	fmt.Printf("Starting %s query for product ID %d...\n", pi.Name(), productID)
 
-	if recordSize > 0 {
-		actualRecordSize = recordSize
-	} else {
-		actualRecordSize = baseRecordSize
+	_, allPirKeys, columns, _, err := LoadDatabaseOnce()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %w", err)
 	}
 
-	actualDBSize := uint64(len(pirKeys))
-
-	p := pir.PickParams(actualDBSize, actualRecordSize, SEC_PARAM, LOGQ)
-
-	DB := MakeDB(actualDBSize, actualRecordSize, &p, pirKeys)
-
 	var queryIndex uint64
 	var found bool = false
 
@@ -776,16 +695,32 @@ func QueryProductByID(t TestingInterface, productID uint64, DBSize uint64, recor
 		queryIndex = 0
 	}
 
-	RunPIR(&pir, DB, p, []uint64{queryIndex})
-
+	var records *ProductRecordPIR
+	if DBSize == 0 && recordSize == 0 {
+		records, err = LoadRecordPIROnce(pi)
+	} else {
+		records = newProductRecordPIR(pi, DBSize, recordSize/8)
+		err = records.err
+	}
+	if err != nil {
+		return err
+	}
 
-	binPath := "../db/en.openfoodfacts.org.products.bin"
-	recordData, err := GetRecordFromBinary(binPath, columns, queryIndex)
+	var m Metrics
+	recordData, truncated, err := RetrieveProductRecord(context.Background(), records, columns, queryIndex, &m)
 	if err != nil {
-		fmt.Printf("Error retrieving record: %v\n", err)
-		return
+		return fmt.Errorf("error retrieving record: %w", err)
 	}
+	printQueryMetrics(pi, m)
 
+	printRecord(productID, queryIndex, columns, recordData)
+	if truncated {
+		fmt.Printf("Record was truncated to %d bytes\n", recordSize/8)
+	}
+	return nil
+}
+
+func printRecord(productID uint64, queryIndex uint64, columns []string, recordData map[string]string) {
 	fmt.Printf("\n=== Retrieved Full Record for Product ID %d (Index: %d) ===\n", productID, queryIndex)
 
 	for _, column := range columns {
@@ -800,5 +735,690 @@ func QueryProductByID(t TestingInterface, productID uint64, DBSize uint64, recor
 		}
 	}
 	fmt.Printf("=== End Record ===\n")
+}
 
+// SERVER SNAPSHOTS ---------------------------------------------------------------------------------------------
+// Preprocessed servers are saved next to the product DB, so that restarts skip
+// Setup. A snapshot older than any of the product DB files is rebuilt. Each
+// scheme has its own snapshots, named after it.
+const (
+	keywordSnapPath      = "../db/keyword-%s.snap"
+	keywordLayoutPath    = "../db/keyword-%s.layout"
+	membershipSnapPath   = "../db/membership-%s.snap"
+	membershipLayoutPath = "../db/membership-%s.layout"
+	recordSnapPath       = "../db/record-%s-%d-%d-%d.snap"
+	recordLayoutPath     = "../db/record-%s-%d-%d.layout"
+)
+
+var productDBPaths = []string{
+	"../db/en.openfoodfacts.org.products.csv",
+	"../db/en.openfoodfacts.org.products.bin",
+	"../db/en.openfoodfacts.org.products.keys.bin",
+}
+
+func snapshotIsFresh(snapPath string) bool {
+	snap, err := os.Stat(snapPath)
+	if err != nil {
+		return false
+	}
+	for _, path := range productDBPaths {
+		if src, err := os.Stat(path); err == nil && src.ModTime().After(snap.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Loads the server snapshot at snapPath if it is fresh and valid; otherwise
+// builds the server with setup and saves a snapshot of it.
+func loadOrSetupServer(pi CheckedPIR, snapPath string, setup func() (*Server, error)) (*Server, error) {
+	if snapshotIsFresh(snapPath) {
+		server, err := LoadServerFile(pi, snapPath)
+		if err == nil && (server.Params().N != demoPreset.N || server.Params().Logq != demoPreset.Logq) {
+			err = fmt.Errorf("built for n=%d, logq=%d", server.Params().N, server.Params().Logq)
+		}
+		if err == nil {
+			fmt.Printf("Loaded preprocessed server from %s\n", snapPath)
+			return server, nil
+		}
+		fmt.Printf("Ignoring snapshot %s: %v\n", snapPath, err)
+	}
+
+	server, err := setup()
+	if err != nil {
+		return nil, err
+	}
+	if err := server.SaveFile(snapPath); err != nil {
+		fmt.Printf("Could not save snapshot %s: %v\n", snapPath, err)
+	}
+	return server, nil
+}
+
+// Reads the layout (of a keyword table or a membership filter) saved at path.
+func readLayout(path string, layout encoding.BinaryUnmarshaler) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return layout.UnmarshalBinary(data)
+}
+
+func writeLayout(path string, layout encoding.BinaryMarshaler) error {
+	data, err := layout.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// KEYWORD PIR BY BARCODE ---------------------------------------------------------------------------------------------
+type keywordPIR struct {
+	server *Server
+	client *Client
+	layout KeywordLayout
+	err    error
+}
+
+// Keyword PIR servers built so far, by scheme name.
+var (
+	globalKeywordPIRs  = make(map[string]*keywordPIR)
+	globalKeywordMutex sync.Mutex
+)
+
+// Maps a barcode to its key in the code column, the same way the loaders do.
+func BarcodeKey(barcode string) uint64 {
+	if v, err := strconv.ParseUint(strings.TrimSpace(barcode), 10, 64); err == nil {
+		return v
+	}
+	return stringToUint64Hash(barcode)
+}
+
+// Builds (once per scheme) a keyword PIR server that maps every barcode key to
+// its record index, and a client set up from what the server publishes.
+func LoadKeywordPIROnce(pi CheckedPIR) (*Server, *Client, KeywordLayout, error) {
+	globalKeywordMutex.Lock()
+	defer globalKeywordMutex.Unlock()
+	k, ok := globalKeywordPIRs[pi.Name()]
+	if !ok {
+		k = newKeywordPIR(pi)
+		globalKeywordPIRs[pi.Name()] = k
+	}
+	return k.server, k.client, k.layout, k.err
+}
+
+func newKeywordPIR(pi CheckedPIR) *keywordPIR {
+	_, pirKeys, _, _, err := LoadDatabaseOnce()
+	if err != nil {
+		return &keywordPIR{err: fmt.Errorf("failed to load database: %w", err)}
+	}
+
+	// The snapshot is useless without the layout of its cuckoo table.
+	snapPath := fmt.Sprintf(keywordSnapPath, pi.Name())
+	layoutPath := fmt.Sprintf(keywordLayoutPath, pi.Name())
+	var layout KeywordLayout
+	if err := readLayout(layoutPath, &layout); err != nil {
+		os.Remove(snapPath)
+	}
+
+	server, err := loadOrSetupServer(pi, snapPath, func() (*Server, error) {
+		n := uint64(len(pirKeys))
+		valueBits := uint64(math.Ceil(math.Log2(float64(n + 1))))
+		indices := make([]uint64, n)
+		for i := range indices {
+			indices[i] = uint64(i)
+		}
+
+		p, err := PickKeywordParams(pi, n, demoPreset.N, demoPreset.Logq)
+		if err != nil {
+			return nil, err
+		}
+		var DB *Database
+		DB, layout, err = MakeKeywordDB(pirKeys, indices, valueBits, &p)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeLayout(layoutPath, layout); err != nil {
+			fmt.Printf("Could not save keyword layout: %v\n", err)
+		}
+		return NewServerContext(context.Background(), pi, DB, p, printSetupProgress("keyword"))
+	})
+	if err != nil {
+		return &keywordPIR{err: err}
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		return &keywordPIR{err: err}
+	}
+
+	return &keywordPIR{server: server, client: client, layout: layout}
+}
+
+// Finds the record index of a barcode through keyword PIR with scheme pi; the
+// server only sees the encrypted queries, never the barcode. Gives up when ctx
+// is cancelled. The time and bytes of each online phase are added to m.
+func LookupBarcode(ctx context.Context, pi CheckedPIR, barcode string, m *Metrics) (uint64, error) {
+	server, client, layout, err := LoadKeywordPIROnce(pi)
+	if err != nil {
+		return 0, err
+	}
+
+	key := BarcodeKey(barcode)
+	start := time.Now()
+	pending, query, err := client.QueryKeyword(layout, key)
+	if err != nil {
+		return 0, err
+	}
+	m.Query.Time += time.Since(start)
+	answer, err := answerMeasured(ctx, server, query, m)
+	if err != nil {
+		return 0, err
+	}
+
+	start = time.Now()
+	index, err := client.RecoverKeyword(layout, key, pending, answer)
+	m.Reconstruct.Time += time.Since(start)
+	return index, err
+}
+
+// PRIVATE MEMBERSHIP BY BARCODE ---------------------------------------------------------------------------------------------
+// Bits per slot of the barcode filter: a barcode that is not in the DB is
+// reported as present with probability 2^-16.
+const membershipFingerprintBits = 16
+
+type membershipPIR struct {
+	server *Server
+	client *Client
+	layout MembershipLayout
+	err    error
+}
+
+// Membership PIR servers built so far, by scheme name.
+var (
+	globalMembershipPIRs  = make(map[string]*membershipPIR)
+	globalMembershipMutex sync.Mutex
+)
+
+// Builds (once per scheme) a PIR server over an XOR filter of every barcode
+// key, and a client set up from what the server publishes.
+func LoadMembershipPIROnce(pi CheckedPIR) (*Server, *Client, MembershipLayout, error) {
+	globalMembershipMutex.Lock()
+	defer globalMembershipMutex.Unlock()
+	f, ok := globalMembershipPIRs[pi.Name()]
+	if !ok {
+		f = newMembershipPIR(pi)
+		globalMembershipPIRs[pi.Name()] = f
+	}
+	return f.server, f.client, f.layout, f.err
+}
+
+func newMembershipPIR(pi CheckedPIR) *membershipPIR {
+	_, pirKeys, _, _, err := LoadDatabaseOnce()
+	if err != nil {
+		return &membershipPIR{err: fmt.Errorf("failed to load database: %w", err)}
+	}
+
+	// The snapshot is useless without the seed of its filter.
+	snapPath := fmt.Sprintf(membershipSnapPath, pi.Name())
+	layoutPath := fmt.Sprintf(membershipLayoutPath, pi.Name())
+	var layout MembershipLayout
+	if err := readLayout(layoutPath, &layout); err != nil {
+		os.Remove(snapPath)
+	}
+
+	server, err := loadOrSetupServer(pi, snapPath, func() (*Server, error) {
+		p, err := PickMembershipParams(pi, uint64(len(pirKeys)), membershipFingerprintBits, demoPreset.N,
+			demoPreset.Logq)
+		if err != nil {
+			return nil, err
+		}
+		var DB *Database
+		DB, layout, err = MakeMembershipDB(pirKeys, membershipFingerprintBits, &p)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeLayout(layoutPath, layout); err != nil {
+			fmt.Printf("Could not save membership layout: %v\n", err)
+		}
+		return NewServerContext(context.Background(), pi, DB, p, printSetupProgress("membership"))
+	})
+	if err != nil {
+		return &membershipPIR{err: err}
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		return &membershipPIR{err: err}
+	}
+
+	return &membershipPIR{server: server, client: client, layout: layout}
+}
+
+// Reports whether a product with barcode exists, through PIR with scheme pi on
+// a filter of every barcode; the server only sees the encrypted queries, never
+// the barcode, and so does not learn the answer either. Gives up when ctx is
+// cancelled. The time and bytes of each online phase are added to m.
+func ProductExists(ctx context.Context, pi CheckedPIR, barcode string, m *Metrics) (bool, error) {
+	server, client, layout, err := LoadMembershipPIROnce(pi)
+	if err != nil {
+		return false, err
+	}
+
+	key := BarcodeKey(barcode)
+	start := time.Now()
+	pending, query, err := client.QueryMembership(layout, key)
+	if err != nil {
+		return false, err
+	}
+	m.Query.Time += time.Since(start)
+	answer, err := answerMeasured(ctx, server, query, m)
+	if err != nil {
+		return false, err
+	}
+
+	start = time.Now()
+	found, err := client.RecoverMembership(layout, key, pending, answer)
+	m.Reconstruct.Time += time.Since(start)
+	return found, err
+}
+
+// Sends query to server and returns its answer, adding the encoded size of
+// both messages (the answer with AnswerBits() bits per elem, as it is sent)
+// and the time to answer to m.
+func answerMeasured(ctx context.Context, server *Server, query MsgSlice, m *Metrics) (Msg, error) {
+	p := server.Params()
+	buf, err := query.Marshal(p.Logq)
+	if err != nil {
+		return Msg{}, err
+	}
+	m.Query.Bytes += uint64(len(buf))
+
+	start := time.Now()
+	answer, err := server.AnswerContext(ctx, query)
+	if err != nil {
+		return Msg{}, err
+	}
+	m.Answer.Time += time.Since(start)
+
+	buf, err = answer.Marshal(p.AnswerBits())
+	if err != nil {
+		return Msg{}, err
+	}
+	m.Answer.Bytes += uint64(len(buf))
+	return answer, nil
+}
+
+func printQueryMetrics(pi CheckedPIR, m Metrics) {
+	fmt.Printf("%s metrics: query %v, answer %v, reconstruct %v, upload %.2f KB, download %.2f KB\n",
+		pi.Name(), m.Query.Time, m.Answer.Time, m.Reconstruct.Time,
+		float64(m.Query.Bytes)/1024.0, float64(m.Answer.Bytes)/1024.0)
+}
+
+// Prints the progress of a server's setup every 10%.
+func printSetupProgress(name string) ProgressFunc {
+	lastPct := uint64(0)
+	return func(done, total uint64) {
+		pct := done * 100 / total
+		if pct/10 > lastPct/10 || done == total {
+			fmt.Printf("Setting up %s PIR server: %d/%d rows (%d%%)\n", name, done, total, pct)
+			lastPct = pct
+		}
+	}
+}
+
+// Same as QueryProduct, but looks the product up privately by barcode, and
+// gives up when ctx is cancelled (e.g., when the HTTP client goes away).
+// Returns the product's record, by column, and the metrics of both online
+// rounds (keyword lookup and record retrieval).
+func QueryProductByBarcode(ctx context.Context, pi CheckedPIR, barcode string) (map[string]string, Metrics, error) {
+	fmt.Printf("Starting keyword %s query for barcode %s...\n", pi.Name(), barcode)
+
+	var m Metrics
+	_, _, columns, _, err := LoadDatabaseOnce()
+	if err != nil {
+		return nil, m, fmt.Errorf("failed to load database: %w", err)
+	}
+
+	queryIndex, err := LookupBarcode(ctx, pi, barcode, &m)
+	if err != nil {
+		return nil, m, err
+	}
+
+	records, err := LoadRecordPIROnce(pi)
+	if err != nil {
+		return nil, m, err
+	}
+	// The records of the whole DB are never truncated.
+	recordData, _, err := RetrieveProductRecord(ctx, records, columns, queryIndex, &m)
+	if err != nil {
+		return nil, m, fmt.Errorf("error retrieving record: %w", err)
+	}
+
+	printQueryMetrics(pi, m)
+	return recordData, m, nil
+}
+
+// RECORD PIR ---------------------------------------------------------------------------------------------
+// Products are served as whole records: a flags byte, then the values of all
+// columns of a product, each prefixed with its length as a uvarint. Records
+// are split into buckets of doubling widths (see RecordLayout), starting at
+// MinProductRecordBytes, so that a few long records do not pad every entry.
+const MinProductRecordBytes = uint64(256)
+
+// Set in the flags byte of a record whose values were cut to fit.
+const productRecordTruncated = byte(1)
+
+var (
+	globalRecords     [][]byte
+	globalRecordsErr  error
+	globalRecordsOnce sync.Once
+
+	// Record PIR servers over the whole DB built so far, by scheme name.
+	globalRecordPIRs  = make(map[string]*ProductRecordPIR)
+	globalRecordMutex sync.Mutex
+)
+
+// Record PIR over the products: one server per non-empty bucket of the
+// layout, and a client for each.
+type ProductRecordPIR struct {
+	layout  RecordLayout
+	servers []*Server
+	clients []*Client
+	err     error
+}
+
+// Encodes the column values of a product into at most maxBytes bytes (without
+// limit if maxBytes is 0). Values that do not fit are cut at a rune boundary,
+// and the columns after them are dropped; the record is then flagged as
+// truncated, and the second return value is true.
+func EncodeProductRecord(values []string, maxBytes uint64) ([]byte, bool) {
+	rec := []byte{0}
+	var hdr [binary.MaxVarintLen64]byte
+	for _, v := range values {
+		n := binary.PutUvarint(hdr[:], uint64(len(v)))
+		if maxBytes > 0 && uint64(len(rec)+n+len(v)) > maxBytes {
+			rec[0] |= productRecordTruncated
+			cut := int(maxBytes) - len(rec) - n
+			if cut <= 0 {
+				break
+			}
+			for cut > 0 && !utf8.RuneStart(v[cut]) {
+				cut--
+			}
+			if cut > 0 {
+				n = binary.PutUvarint(hdr[:], uint64(cut))
+				rec = append(rec, hdr[:n]...)
+				rec = append(rec, v[:cut]...)
+			}
+			break
+		}
+		rec = append(rec, hdr[:n]...)
+		rec = append(rec, v...)
+	}
+	return rec, rec[0]&productRecordTruncated != 0
+}
+
+// Returns the column values stored in a record built by EncodeProductRecord,
+// and whether they were cut to fit.
+func decodeProductValues(rec []byte) ([]string, bool, error) {
+	if len(rec) == 0 {
+		return nil, false, fmt.Errorf("malformed record: no flags")
+	}
+	truncated := rec[0]&productRecordTruncated != 0
+	rec = rec[1:]
+	var values []string
+	for len(rec) > 0 {
+		l, n := binary.Uvarint(rec)
+		if n <= 0 || l > uint64(len(rec)-n) {
+			return nil, false, fmt.Errorf("malformed record at column %d", len(values))
+		}
+		values = append(values, string(rec[n:n+int(l)]))
+		rec = rec[n+int(l):]
+	}
+	return values, truncated, nil
+}
+
+// Decodes a record built by EncodeProductRecord; columns missing from the
+// record are left out of the map. Reports whether the record was truncated,
+// in which case its last value may be cut short.
+func DecodeProductRecord(columns []string, rec []byte) (map[string]string, bool, error) {
+	values, truncated, err := decodeProductValues(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	recordData := make(map[string]string)
+	for j, value := range values {
+		if j < len(columns) {
+			recordData[columns[j]] = value
+		}
+	}
+	return recordData, truncated, nil
+}
+
+// Reads the first limit records (all of them if limit is 0) of the binary
+// database, encoded with EncodeProductRecord and cut to maxBytes bytes if
+// maxBytes is non-zero.
+func LoadProductRecordsFromBinary(binPath string, limit uint64, maxBytes uint64) ([][]byte, error) {
+	file, err := os.Open(binPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	var numColumns uint32
+	if err := binary.Read(r, binary.LittleEndian, &numColumns); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < numColumns; i++ {
+		var colLen uint32
+		binary.Read(r, binary.LittleEndian, &colLen)
+		r.Discard(int(colLen))
+	}
+
+	var totalRecords uint64
+	if err := binary.Read(r, binary.LittleEndian, &totalRecords); err != nil {
+		return nil, err
+	}
+	if limit > 0 && limit < totalRecords {
+		totalRecords = limit
+	}
+
+	records := make([][]byte, 0, totalRecords)
+	values := make([]string, numColumns)
+	truncated := 0
+	for i := uint64(0); i < totalRecords; i++ {
+		var key uint64
+		if err := binary.Read(r, binary.LittleEndian, &key); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		for j := range values {
+			var valueLen uint32
+			binary.Read(r, binary.LittleEndian, &valueLen)
+			valueBytes := make([]byte, valueLen)
+			if _, err := io.ReadFull(r, valueBytes); err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+			values[j] = string(valueBytes)
+		}
+		rec, cut := EncodeProductRecord(values, maxBytes)
+		if cut {
+			truncated++
+		}
+		records = append(records, rec)
+	}
+
+	fmt.Printf("Loaded %d product records from binary (%d truncated)\n", len(records), truncated)
+	return records, nil
+}
+
+func LoadProductRecordsOnce() ([][]byte, error) {
+	globalRecordsOnce.Do(func() {
+		binPath := "../db/en.openfoodfacts.org.products.bin"
+		globalRecords, globalRecordsErr = LoadProductRecordsFromBinary(binPath, 0, 0)
+	})
+	return globalRecords, globalRecordsErr
+}
+
+// Builds record PIR servers over the first DBSize product records (all of
+// them if DBSize is 0) with scheme pi, cut to maxBytes bytes if maxBytes is
+// non-zero, and clients set up from what the servers publish.
+func newProductRecordPIR(pi CheckedPIR, DBSize uint64, maxBytes uint64) *ProductRecordPIR {
+	// The snapshots are useless without the layout of their buckets.
+	layoutPath := fmt.Sprintf(recordLayoutPath, pi.Name(), DBSize, maxBytes)
+	var layout RecordLayout
+	var buckets [][][]byte
+	if err := readLayout(layoutPath, &layout); err != nil || !snapshotIsFresh(layoutPath) {
+		records, err := productRecords(DBSize, maxBytes)
+		if err != nil {
+			return &ProductRecordPIR{err: err}
+		}
+		lengths := make([]uint64, len(records))
+		for i, rec := range records {
+			lengths[i] = uint64(len(rec))
+		}
+		l, err := NewRecordLayout(lengths, MinProductRecordBytes)
+		if err != nil {
+			return &ProductRecordPIR{err: err}
+		}
+		if buckets, err = l.Split(records); err != nil {
+			return &ProductRecordPIR{err: err}
+		}
+		layout = *l
+		for b := uint64(0); b < maxRecordBuckets; b++ {
+			os.Remove(fmt.Sprintf(recordSnapPath, pi.Name(), DBSize, maxBytes, b))
+		}
+		if err := writeLayout(layoutPath, layout); err != nil {
+			fmt.Printf("Could not save record layout: %v\n", err)
+		}
+	}
+
+	r := &ProductRecordPIR{
+		layout:  layout,
+		servers: make([]*Server, layout.Buckets()),
+		clients: make([]*Client, layout.Buckets()),
+	}
+	for b := uint64(0); b < layout.Buckets(); b++ {
+		if layout.Size(b) == 0 {
+			continue
+		}
+		snapPath := fmt.Sprintf(recordSnapPath, pi.Name(), DBSize, maxBytes, b)
+		server, err := loadOrSetupServer(pi, snapPath, func() (*Server, error) {
+			if buckets == nil {
+				records, err := productRecords(DBSize, maxBytes)
+				if err != nil {
+					return nil, err
+				}
+				if buckets, err = layout.Split(records); err != nil {
+					return nil, err
+				}
+			}
+			return setupProductRecordPIR(pi, buckets[b], layout.Width(b))
+		})
+		if err != nil {
+			return &ProductRecordPIR{err: err}
+		}
+		client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+		if err != nil {
+			return &ProductRecordPIR{err: err}
+		}
+		r.servers[b], r.clients[b] = server, client
+	}
+	return r
+}
+
+// Returns the first DBSize product records (all of them if DBSize is 0), cut
+// to maxBytes bytes if maxBytes is non-zero.
+func productRecords(DBSize uint64, maxBytes uint64) ([][]byte, error) {
+	records, err := LoadProductRecordsOnce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load records: %w", err)
+	}
+	if DBSize > 0 && DBSize < uint64(len(records)) {
+		records = records[:DBSize]
+	}
+	if maxBytes == 0 {
+		return records, nil
+	}
+	cut := make([][]byte, len(records))
+	for i, rec := range records {
+		if uint64(len(rec)) > maxBytes {
+			values, _, err := decodeProductValues(rec)
+			if err != nil {
+				return nil, err
+			}
+			rec, _ = EncodeProductRecord(values, maxBytes)
+		}
+		cut[i] = rec
+	}
+	return cut, nil
+}
+
+// Builds and preprocesses the DB of one bucket of newProductRecordPIR, which
+// holds records of up to width bytes.
+func setupProductRecordPIR(pi CheckedPIR, records [][]byte, width uint64) (*Server, error) {
+	p, rowLength, err := PickRecordParams(pi, uint64(len(records)), width, demoPreset.N, demoPreset.Logq)
+	if err != nil {
+		return nil, err
+	}
+	DB, err := MakeRecordDB(records, rowLength, &p)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerContext(context.Background(), pi, DB, p, printSetupProgress(fmt.Sprintf("%d-byte record", width)))
+}
+
+// Builds (once per scheme) a record PIR server over the whole product DB.
+func LoadRecordPIROnce(pi CheckedPIR) (*ProductRecordPIR, error) {
+	globalRecordMutex.Lock()
+	defer globalRecordMutex.Unlock()
+	r, ok := globalRecordPIRs[pi.Name()]
+	if !ok {
+		r = newProductRecordPIR(pi, 0, 0)
+		globalRecordPIRs[pi.Name()] = r
+	}
+	return r, r.err
+}
+
+// Retrieves record queryIndex through PIR, with one query to each bucket so
+// that the server learns neither the index nor the record's length. Gives up
+// when ctx is cancelled. The time and bytes of each online phase are added to
+// m. Reports whether the record was truncated.
+func RetrieveProductRecord(ctx context.Context, r *ProductRecordPIR, columns []string, queryIndex uint64,
+	m *Metrics) (map[string]string, bool, error) {
+	bucket, slot, err := r.layout.Locate(queryIndex)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var rec []byte
+	for b, server := range r.servers {
+		if server == nil {
+			continue
+		}
+		i := uint64(0)
+		if uint64(b) == bucket {
+			i = slot
+		}
+		start := time.Now()
+		pending, query, err := r.clients[b].Query(i)
+		if err != nil {
+			return nil, false, err
+		}
+		m.Query.Time += time.Since(start)
+		answer, err := answerMeasured(ctx, server, query, m)
+		if err != nil {
+			return nil, false, err
+		}
+		if uint64(b) != bucket {
+			continue
+		}
+
+		start = time.Now()
+		records, err := r.clients[b].RecoverRecords(pending, answer)
+		m.Reconstruct.Time += time.Since(start)
+		if err != nil {
+			return nil, false, err
+		}
+		rec = records[0]
+	}
+	return DecodeProductRecord(columns, rec)
 }
@@ -0,0 +1,103 @@
+package pir
+
+import (
+	"context"
+	"fmt"
+)
+
+// Server side of a PIR scheme. It owns the preprocessed database, the server
+// state, and the seed from which the shared state (i.e., the LWE matrices) is
+// derived, so that it can run in a different process than its clients.
+// A Server can answer many clients concurrently.
+type Server struct {
+	db   *SharedDB
+	seed CompressedState
+}
+
+// Preprocesses a copy of DB for scheme pi; DB itself is left untouched.
+func NewServer(pi CheckedPIR, DB *Database, p Params) (*Server, error) {
+	return NewServerContext(context.Background(), pi, DB, p, nil)
+}
+
+// Same as NewServer, but stops preprocessing when ctx is cancelled, and
+// reports the DB rows processed so far to progress (if not nil).
+func NewServerContext(ctx context.Context, pi CheckedPIR, DB *Database, p Params,
+	progress ProgressFunc) (*Server, error) {
+	if err := checkSquishParams(p); err != nil {
+		return nil, err
+	}
+	if DB == nil {
+		return nil, ErrEmptyDB
+	}
+	shared, seed := pi.InitCompressed(DB.Info, p)
+	db, err := NewSharedDBContext(ctx, pi, DB, shared, p, 0, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{db: db, seed: seed}, nil
+}
+
+// Preprocesses a copy of DB as one shard of a sharded DB, deriving the
+// shared state from a seed that all shards have in common, e.g. in a worker
+// process (see ShardedServer).
+func NewShardServer(pi CheckedPIR, DB *Database, p Params, seed CompressedState) (*Server, error) {
+	if err := checkSquishParams(p); err != nil {
+		return nil, err
+	}
+	if DB == nil {
+		return nil, ErrEmptyDB
+	}
+	if seed.Seed == nil {
+		return nil, fmt.Errorf("%w: missing seed", ErrBadState)
+	}
+	db, err := NewSharedDB(pi, DB, pi.DecompressState(DB.Info, p, seed), p, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{db: db, seed: seed}, nil
+}
+
+func (s *Server) Params() Params {
+	return s.db.Params()
+}
+
+// Returns the description of the preprocessed DB that clients need to build queries.
+func (s *Server) DBInfo() DBinfo {
+	return s.db.Info()
+}
+
+// Returns the seed of the shared state, for clients to decompress.
+func (s *Server) Seed() CompressedState {
+	return s.seed
+}
+
+// Returns the hint (i.e., the offline download) for clients.
+func (s *Server) Hint() Msg {
+	return s.db.Hint()
+}
+
+// Answers a batch of queries, built by a Client with this server's params.
+// Safe for concurrent use.
+func (s *Server) Answer(query MsgSlice) (Msg, error) {
+	return s.db.Answer(query)
+}
+
+// Same as Answer, but gives up when ctx is cancelled (e.g., when the client
+// hangs up or a deadline passes).
+func (s *Server) AnswerContext(ctx context.Context, query MsgSlice) (Msg, error) {
+	return s.db.AnswerContext(ctx, query)
+}
+
+// Answers a single query from rows [start, end) of the DB only (see
+// SharedDB.AnswerRowsContext).
+func (s *Server) AnswerRowsContext(ctx context.Context, query Msg, start, end uint64) (Msg, error) {
+	return s.db.AnswerRowsContext(ctx, query, start, end)
+}
+
+// Changes DB entries in place and returns the hint patch to send to clients
+// (see SharedDB.Update).
+func (s *Server) Update(updates ...Update) (HintPatch, error) {
+	return s.db.Update(updates...)
+}
@@ -0,0 +1,142 @@
+package pir
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Preprocessed copy of a database. Setup runs once on a private copy of the
+// DB, which is only modified afterwards by Update, so any number of goroutines
+// can answer queries against a SharedDB at the same time.
+type SharedDB struct {
+	mu      sync.RWMutex // held for writing by Update
+	pi      CheckedPIR
+	params  Params
+	db      *Database
+	state   State
+	shared  State
+	hint    Msg
+	workers int
+}
+
+// Preprocesses a copy of DB for scheme pi; DB itself is left untouched.
+// Each call to Answer splits its work across 'workers' goroutines, or across
+// runtime.GOMAXPROCS(0) goroutines if workers <= 0.
+func NewSharedDB(pi CheckedPIR, DB *Database, shared State, p Params, workers int) (*SharedDB, error) {
+	return NewSharedDBContext(context.Background(), pi, DB, shared, p, workers, nil)
+}
+
+// Same as NewSharedDB, but stops preprocessing when ctx is cancelled, and
+// reports the rows processed so far to progress (if not nil), if pi
+// implements ContextPIR.
+func NewSharedDBContext(ctx context.Context, pi CheckedPIR, DB *Database, shared State, p Params,
+	workers int, progress ProgressFunc) (*SharedDB, error) {
+	if DB == nil || DB.Data == nil {
+		return nil, ErrEmptyDB
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db := DB.Copy()
+	var state State
+	var hint Msg
+	var err error
+	if cp, ok := pi.(ContextPIR); ok {
+		state, hint, err = cp.SetupContext(ctx, db, shared, p, progress)
+	} else {
+		state, hint, err = pi.SetupChecked(db, shared, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SharedDB{
+		pi:      pi,
+		params:  p,
+		db:      db,
+		state:   state,
+		shared:  shared,
+		hint:    hint,
+		workers: workers,
+	}, nil
+}
+
+func (s *SharedDB) Params() Params {
+	return s.params
+}
+
+// Returns the description of the preprocessed DB.
+func (s *SharedDB) Info() DBinfo {
+	return s.db.Info
+}
+
+// Returns the hint (i.e., the offline download) for clients, rounded to
+// HintBits() bits per elem if the params compress it.
+func (s *SharedDB) Hint() Msg {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return CompressHint(s.hint, s.params)
+}
+
+// Answers a batch of queries. Safe for concurrent use.
+func (s *SharedDB) Answer(query MsgSlice) (Msg, error) {
+	return s.AnswerContext(context.Background(), query)
+}
+
+// Same as Answer, but gives up when ctx is cancelled, if the scheme
+// implements ContextPIR.
+func (s *SharedDB) AnswerContext(ctx context.Context, query MsgSlice) (Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return Msg{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cp, ok := s.pi.(ContextPIR); ok {
+		return cp.AnswerContext(ctx, s.db, query, s.state, s.shared, s.params, s.workers, nil)
+	}
+	if pa, ok := s.pi.(ParallelAnswerer); ok {
+		return pa.AnswerParallel(s.db, query, s.state, s.shared, s.params, s.workers)
+	}
+	return s.pi.AnswerChecked(s.db, query, s.state, s.shared, s.params)
+}
+
+// Answers a single query from rows [start, end) of the DB only, if the scheme
+// implements RowAnswerer. Safe for concurrent use.
+func (s *SharedDB) AnswerRowsContext(ctx context.Context, query Msg, start, end uint64) (Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return Msg{}, err
+	}
+	ra, ok := s.pi.(RowAnswerer)
+	if !ok {
+		return Msg{}, fmt.Errorf("%w: %s cannot answer from a range of rows", ErrNotSupported, s.pi.Name())
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return ra.AnswerRows(ctx, s.db, query, start, end, s.params, s.workers)
+}
+
+// Applies updates to the preprocessed DB and its hint, if the scheme
+// implements HintUpdater, and returns the patch that brings clients' hints up
+// to date. Waits for the answers in progress to finish; answers computed
+// afterwards reflect the updates.
+func (s *SharedDB) Update(updates ...Update) (HintPatch, error) {
+	hu, ok := s.pi.(HintUpdater)
+	if !ok {
+		return HintPatch{}, fmt.Errorf("%w: %s cannot update its hint", ErrNotSupported, s.pi.Name())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hint, patch, err := hu.UpdateChecked(s.db, updates, s.shared, s.hint, s.params)
+	if err != nil {
+		return HintPatch{}, err
+	}
+	s.hint = hint
+	return CompressHintPatch(patch, s.params), nil
+}
@@ -0,0 +1,273 @@
+package pir
+
+// #include "pir.h"
+import "C"
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Two-server PIR from distributed point functions (see dpf.go), for
+// comparison with the single-server LWE schemes. The client sends each of two
+// non-colluding servers one DPF key for the point function at the DB entry it
+// wants; each server XORs together the entries its key selects, and the XOR
+// of the two answers is the entry. There is no hint, the query is
+// logarithmic in the DB size, and the answer is one entry per server, but
+// privacy rests on the servers not colluding.
+//
+// Both servers run in-process: a query carries both keys, and an answer both
+// servers' answers. The DB layout is that of SimplePIR (with the same p, so
+// that records take as many Z_p elems), and each server XORs the ne Z_p elems
+// of each selected column segment; the DPF domain is thus the set of
+// (l/ne)*m such segments.
+type TwoServerDPF struct{}
+
+func (pi *TwoServerDPF) Name() string {
+	return "TwoServerDPF"
+}
+
+func (pi *TwoServerDPF) PickParams(N, d, n, logq uint64) Params {
+	p, err := pi.PickParamsChecked(N, d, n, logq)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Picks a roughly square DB for p = 2^squishBasis. n is unused, as there are
+// no LWE samples; answers are sent with log(p) bits per elem.
+func (pi *TwoServerDPF) PickParamsChecked(N, d, n, logq uint64) (Params, error) {
+	if N == 0 || d == 0 {
+		return Params{}, ErrEmptyDB
+	}
+	if logq < squishBasis || logq > 64 {
+		return Params{}, fmt.Errorf("%w: logq=%d", ErrBadParams, logq)
+	}
+	l, m := ApproxSquareDatabaseDims(N, d, 1<<squishBasis)
+	p := pi.PickParamsGivenDimensions(l, m, n, logq)
+	p.PrintParams()
+	return p, nil
+}
+
+func (pi *TwoServerDPF) PickParamsGivenDimensions(l, m, n, logq uint64) Params {
+	return Params{
+		N:          n,
+		Logq:       logq,
+		L:          l,
+		M:          m,
+		P:          1 << squishBasis,
+		AnswerLogq: squishBasis,
+	}
+}
+
+// Number of points in the DPF domain, and the depth of its tree.
+func dpfDomain(p Params, info DBinfo) (uint64, int) {
+	n := p.L / info.Ne * p.M
+	return n, bits.Len64(n - 1)
+}
+
+// Number of Z_q elems per encoded key.
+func dpfKeyElems(p Params, info DBinfo) uint64 {
+	_, depth := dpfDomain(p, info)
+	per_elem := p.Logq / 8
+	return (dpfKeyBytes(depth) + per_elem - 1) / per_elem
+}
+
+func (pi *TwoServerDPF) GetBW(info DBinfo, p Params) {
+	fmt.Printf("\t\tOffline download: 0 KB\n")
+
+	online_upload := float64(2*dpfKeyElems(p, info)*p.Logq) / (8.0 * 1024.0)
+	fmt.Printf("\t\tOnline upload: %f KB\n", online_upload)
+
+	online_download := float64(2*info.Ne*p.AnswerBits()) / (8.0 * 1024.0)
+	fmt.Printf("\t\tOnline download: %f KB\n", online_download)
+}
+
+// There is no shared state, but clients still expect a seed.
+func (pi *TwoServerDPF) Init(info DBinfo, p Params, prg *BufPRGReader) State {
+	return MakeState()
+}
+
+func (pi *TwoServerDPF) InitCompressed(info DBinfo, p Params) (State, CompressedState) {
+	return MakeState(), MakeCompressedState(RandomPRGKey())
+}
+
+func (pi *TwoServerDPF) DecompressState(info DBinfo, p Params, comp CompressedState) State {
+	return MakeState()
+}
+
+// Maps the DB entries to [0, p), so that the XOR of an answer stays below p.
+// There is no hint.
+func (pi *TwoServerDPF) Setup(DB *Database, shared State, p Params) (State, Msg) {
+	server, _ := pi.FakeSetup(DB, p)
+	return server, MakeMsg()
+}
+
+func (pi *TwoServerDPF) FakeSetup(DB *Database, p Params) (State, float64) {
+	DB.Data.Add(p.P / 2)
+
+	// Marks the DB as preprocessed, with one Z_p elem per Z_q elem.
+	DB.Info.Basis = p.Logq
+	DB.Info.Squishing = 1
+	DB.Info.Cols = DB.Data.Cols
+
+	return MakeState(), 0
+}
+
+// Packs b into Z_q elems of logq/8 bytes each.
+func bytesToMatrix(b []byte, logq uint64) *Matrix {
+	per_elem := logq / 8
+	out := MatrixNew((uint64(len(b))+per_elem-1)/per_elem, 1)
+	for k, v := range b {
+		i := uint64(k) / per_elem
+		out.Data[i] |= C.Elem(v) << (8 * (uint64(k) % per_elem))
+	}
+	return out
+}
+
+func matrixToBytes(m *Matrix, n, logq uint64) []byte {
+	per_elem := logq / 8
+	out := make([]byte, n)
+	for k := range out {
+		out[k] = byte(m.Data[uint64(k)/per_elem] >> (8 * (uint64(k) % per_elem)))
+	}
+	return out
+}
+
+func (pi *TwoServerDPF) Query(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg) {
+	_, depth := dpfDomain(p, info)
+	keys := dpfGen(elemIndex(i, info), depth, prg)
+	return MakeState(), MakeMsg(bytesToMatrix(keys[0].marshal(), p.Logq),
+		bytesToMatrix(keys[1].marshal(), p.Logq))
+}
+
+// Returns the answers of both servers, each with ne Z_p elems per query.
+func (pi *TwoServerDPF) Answer(DB *Database, query MsgSlice, server State, shared State, p Params) Msg {
+	ans, err := pi.answer(DB, query, p)
+	if err != nil {
+		panic(err)
+	}
+	return ans
+}
+
+func (pi *TwoServerDPF) answer(DB *Database, query MsgSlice, p Params) (Msg, error) {
+	n, depth := dpfDomain(p, DB.Info)
+	key_bytes := dpfKeyBytes(depth)
+	ne, m := DB.Info.Ne, DB.Data.Cols
+	num_queries := uint64(len(query.Data))
+
+	out := MakeMsg(MatrixNew(num_queries*ne, 1), MatrixNew(num_queries*ne, 1))
+	for batch, q := range query.Data {
+		for party, ans := range out.Data {
+			key, ok := unmarshalDPFKey(matrixToBytes(q.Data[party], key_bytes, p.Logq), depth)
+			if !ok {
+				return Msg{}, fmt.Errorf("%w: malformed key for server %d in query %d", ErrBadQuery,
+					party, batch)
+			}
+			acc := ans.Data[uint64(batch)*ne : uint64(batch+1)*ne]
+			key.evalAll(byte(party), n, func(x uint64) {
+				row, col := x/m*ne, x%m
+				for k := range acc {
+					acc[k] ^= DB.Data.Data[(row+uint64(k))*m+col]
+				}
+			})
+		}
+	}
+	return out, nil
+}
+
+func (pi *TwoServerDPF) Recover(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) uint64 {
+	vals := pi.recoverElems(batch_index, answer, info)
+
+	// ReconstructElem expects elems in [-p/2, p/2].
+	for k := range vals {
+		vals[k] = modQ(vals[k]-info.P/2, info.Logq)
+	}
+	return ReconstructElem(vals, i, info)
+}
+
+// Returns the ne Z_p elems, in [0, p), of the entry queried in the batch.
+func (pi *TwoServerDPF) recoverElems(batch_index uint64, answer Msg, info DBinfo) []uint64 {
+	vals := make([]uint64, info.Ne)
+	for k := range vals {
+		row := batch_index*info.Ne + uint64(k)
+		vals[k] = answer.Data[0].Get(row, 0) ^ answer.Data[1].Get(row, 0)
+	}
+	return vals
+}
+
+func (pi *TwoServerDPF) Reset(DB *Database, p Params) {
+	DB.Data.Sub(p.P / 2)
+}
+
+func (pi *TwoServerDPF) SetupChecked(DB *Database, shared State, p Params) (State, Msg, error) {
+	if err := checkSetupDB(DB, p); err != nil {
+		return State{}, Msg{}, err
+	}
+	server, offline := pi.Setup(DB, shared, p)
+	return server, offline, nil
+}
+
+func (pi *TwoServerDPF) QueryChecked(i uint64, shared State, p Params, info DBinfo,
+	prg *BufPRGReader) (State, Msg, error) {
+	if err := checkIndex(i, p, info); err != nil {
+		return State{}, Msg{}, err
+	}
+	client, query := pi.Query(i, shared, p, info, prg)
+	return client, query, nil
+}
+
+func (pi *TwoServerDPF) AnswerChecked(DB *Database, query MsgSlice, server State, shared State,
+	p Params) (Msg, error) {
+	if err := checkAnswerDB(DB, query); err != nil {
+		return Msg{}, err
+	}
+	if !hasDims(DB.Data, p.L, p.M) {
+		return Msg{}, fmt.Errorf("%w: %d-by-%d database, %d-by-%d params", ErrDBSizeMismatch,
+			DB.Data.Rows, DB.Data.Cols, p.L, p.M)
+	}
+	key_elems := dpfKeyElems(p, DB.Info)
+	for batch, q := range query.Data {
+		if len(q.Data) != 2 || !hasDims(q.Data[0], key_elems, 1) || !hasDims(q.Data[1], key_elems, 1) {
+			return Msg{}, fmt.Errorf("%w: query %d does not hold two keys of %d elems", ErrBadQuery,
+				batch, key_elems)
+		}
+	}
+	return pi.answer(DB, query, p)
+}
+
+func (pi *TwoServerDPF) RecoverChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) (uint64, error) {
+	if err := pi.checkRecover(i, batch_index, answer, p, info); err != nil {
+		return 0, err
+	}
+	return pi.Recover(i, batch_index, offline, query, answer, shared, client, p, info), nil
+}
+
+func (pi *TwoServerDPF) RecoverElemsChecked(i uint64, batch_index uint64, offline Msg, query Msg,
+	answer Msg, shared State, client State, p Params, info DBinfo) ([]uint64, error) {
+	if err := pi.checkRecover(i, batch_index, answer, p, info); err != nil {
+		return nil, err
+	}
+	return pi.recoverElems(batch_index, answer, info), nil
+}
+
+func (pi *TwoServerDPF) checkRecover(i, batch_index uint64, answer Msg, p Params, info DBinfo) error {
+	if err := checkIndex(i, p, info); err != nil {
+		return err
+	}
+	rows := (batch_index + 1) * info.Ne
+	if len(answer.Data) != 2 || answer.Data[0].Cols != 1 || answer.Data[1].Cols != 1 ||
+		answer.Data[0].Rows < rows || answer.Data[1].Rows < rows {
+		return fmt.Errorf("%w: expected two answers of at least %d elems", ErrBadAnswer, rows)
+	}
+	for _, a := range answer.Data {
+		for k := rows - info.Ne; k < rows; k++ {
+			if a.Get(k, 0) >= p.P {
+				return fmt.Errorf("%w: answer elem not in Z_p", ErrBadAnswer)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package pir
+
+// #include "pir.h"
+import "C"
+import (
+	"fmt"
+	"sort"
+)
+
+// Incremental updates: changing a few DB entries after Setup only changes a
+// few rows of a linear hint (e.g., SimplePIR's H = DB·A), so the server can
+// patch its preprocessed DB and hint in place, and send clients only the hint
+// rows that changed instead of the whole hint.
+
+// Sets DB entry Index to Value.
+type Update struct {
+	Index uint64
+	Value uint64
+}
+
+// New contents of the hint rows changed by a batch of updates: row j of Data
+// replaces row Rows[j] of the hint.
+type HintPatch struct {
+	Rows []uint64
+	Data *Matrix
+}
+
+// Implemented by schemes that can update a preprocessed DB and its hint
+// without running Setup again.
+type HintUpdater interface {
+	// Applies updates to DB (as preprocessed by Setup) in place, and returns
+	// the updated hint along with the patch that turns offline into it;
+	// offline itself is left untouched. Either all updates are applied, or
+	// none are.
+	UpdateChecked(DB *Database, updates []Update, shared State, offline Msg, p Params) (Msg, HintPatch, error)
+}
+
+// One Z_p elem of a preprocessed DB that an update changed by delta.
+type elemChange struct {
+	row, col uint64
+	delta    C.Elem
+}
+
+func (DB *Database) checkUpdates(updates []Update) error {
+	if DB == nil || DB.Data == nil {
+		return ErrEmptyDB
+	}
+	if DB.Info.Squishing == 0 {
+		return ErrNotSetup
+	}
+	for _, u := range updates {
+		if u.Index >= DB.Info.Num {
+			return fmt.Errorf("%w: index %d, database has %d entries", ErrIndexOutOfRange, u.Index, DB.Info.Num)
+		}
+		if DB.Info.Row_length < 64 && u.Value>>DB.Info.Row_length != 0 {
+			return fmt.Errorf("%w: value %d does not fit in %d bits", ErrBadInput, u.Value, DB.Info.Row_length)
+		}
+	}
+	return nil
+}
+
+// Returns the Z_p elem at (row, col) of a squished DB.
+func (DB *Database) squishedGet(row, col uint64) uint64 {
+	shift := (col % DB.Info.Squishing) * DB.Info.Basis
+	return (DB.Data.Get(row, col/DB.Info.Squishing) >> shift) & (1<<DB.Info.Basis - 1)
+}
+
+func (DB *Database) squishedSet(val, row, col uint64) {
+	shift := (col % DB.Info.Squishing) * DB.Info.Basis
+	mask := uint64(1<<DB.Info.Basis-1) << shift
+	packed := DB.Data.Get(row, col/DB.Info.Squishing)
+	DB.Data.Set(packed&^mask|val<<shift, row, col/DB.Info.Squishing)
+}
+
+// Sets entry i of a squished DB to value, and returns the Z_p elems that changed.
+func (DB *Database) setEntry(i, value uint64) []elemChange {
+	var changes []elemChange
+	set := func(val, row, col uint64) {
+		old := DB.squishedGet(row, col)
+		if old != val {
+			DB.squishedSet(val, row, col)
+			changes = append(changes, elemChange{row, col, C.Elem(val) - C.Elem(old)})
+		}
+	}
+
+	if DB.Info.Packing > 0 {
+		// Entry i is one base-2^row_length digit of a single Z_p elem.
+		at := i / DB.Info.Packing
+		row, col := at/DB.Info.Cols, at%DB.Info.Cols
+		old := DB.squishedGet(row, col)
+		coeff := uint64(1) << (DB.Info.Row_length * (i % DB.Info.Packing))
+		digit := Base_p(1<<DB.Info.Row_length, old, i%DB.Info.Packing)
+		set(old-digit*coeff+value*coeff, row, col)
+	} else {
+		for j := uint64(0); j < DB.Info.Ne; j++ {
+			set(Base_p(DB.Info.P, value, j), (i/DB.Info.Cols)*DB.Info.Ne+j, i%DB.Info.Cols)
+		}
+	}
+	return changes
+}
+
+// Copies the given rows of the hint into a patch.
+func makeHintPatch(H *Matrix, rows map[uint64]bool) HintPatch {
+	patch := HintPatch{Data: MatrixNew(uint64(len(rows)), H.Cols)}
+	for row := range rows {
+		patch.Rows = append(patch.Rows, row)
+	}
+	sort.Slice(patch.Rows, func(a, b int) bool { return patch.Rows[a] < patch.Rows[b] })
+	for j, row := range patch.Rows {
+		copy(patch.Data.Data[uint64(j)*H.Cols:], H.Data[row*H.Cols:(row+1)*H.Cols])
+	}
+	return patch
+}
+
+// Returns a copy of hint with patch applied.
+func applyHintPatch(hint *Matrix, patch HintPatch) (*Matrix, error) {
+	if hint == nil {
+		return nil, fmt.Errorf("%w: missing hint", ErrBadState)
+	}
+	if !hasDims(patch.Data, uint64(len(patch.Rows)), hint.Cols) {
+		return nil, fmt.Errorf("%w: patch does not match a %d-column hint", ErrBadInput, hint.Cols)
+	}
+	for _, row := range patch.Rows {
+		if row >= hint.Rows {
+			return nil, fmt.Errorf("%w: patch row %d, hint has %d rows", ErrBadInput, row, hint.Rows)
+		}
+	}
+
+	H := hint.RowsDeepCopy(0, hint.Rows)
+	for j, row := range patch.Rows {
+		copy(H.Data[row*H.Cols:(row+1)*H.Cols], patch.Data.Data[uint64(j)*H.Cols:])
+	}
+	return H, nil
+}
+
+// Applies a patch produced by the server's Update to the client's hint.
+// Queries built before the patch can still be recovered afterwards only if
+// they target entries that the patch left unchanged. Precomputed queries
+// are dropped, as they hold products with the old hint.
+func (c *Client) ApplyHintPatch(patch HintPatch) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	H, err := applyHintPatch(c.hint.Data[0], patch)
+	if err != nil {
+		return err
+	}
+	c.hint = MakeMsg(H)
+	c.pool = nil
+	return nil
+}
+
+// Returns the client's hint, e.g. to cache it across restarts.
+func (c *Client) Hint() Msg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hint
+}
+
+// Encodes the patch for the wire, packing each entry into logq bits.
+func (h *HintPatch) Marshal(logq uint64) ([]byte, error) {
+	if err := checkLogq(logq); err != nil {
+		return nil, err
+	}
+	buf := putHeader(nil, wireHintPatch)
+	buf = append(buf, byte(logq))
+	buf = appendUvarint(buf, uint64(len(h.Rows)))
+	for _, row := range h.Rows {
+		buf = appendUvarint(buf, row)
+	}
+	return putMatrix(buf, h.Data, logq)
+}
+
+// Decodes a patch produced by Marshal with the same logq.
+func (h *HintPatch) Unmarshal(data []byte, logq uint64) error {
+	if err := checkLogq(logq); err != nil {
+		return err
+	}
+	d := decoder{buf: data}
+	d.header(wireHintPatch)
+	if got := d.bytes(1); d.err == nil && uint64(got[0]) != logq {
+		d.fail("encoded with logq=%d, want %d", got[0], logq)
+	}
+	num := d.uvarint()
+	if num > uint64(len(d.buf)) {
+		d.fail("%d rows do not fit in %d bytes", num, len(d.buf))
+	}
+	var rows []uint64
+	for j := uint64(0); j < num && d.err == nil; j++ {
+		rows = append(rows, d.uvarint())
+	}
+	m := d.matrix(logq)
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if m.Rows != num {
+		return fmt.Errorf("%w: %d rows, %d-row patch", ErrBadEncoding, num, m.Rows)
+	}
+	h.Rows, h.Data = rows, m
+	return nil
+}
@@ -0,0 +1,319 @@
+package pir
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	mrand "math/rand"
+)
+
+// Batch PIR with cuckoo batch codes: every entry of the original DB is
+// stored in NumBatchHashes of B buckets, and bucket j lives in the DB rows
+// that the j-th query of a batch of B queries is answered from. To fetch k
+// arbitrary entries, a client assigns each of them to a distinct bucket that
+// holds it (with cuckoo hashing, so B = 1.5k buckets suffice), and sends one
+// query per bucket, with dummy queries for the buckets it does not need.
+//
+// The server processes every DB row once per batch, i.e., about
+// NumBatchHashes*N entries, instead of k*N for k separate queries. Each
+// bucket holds the entries that hash to it in increasing order, so the
+// client can compute where an entry lives from the layout alone. Every
+// bucket needs at least one entry's worth of rows, so this suits schemes with
+// tall DBs, such as SimplePIR, rather than DoublePIR's wide ones.
+
+const NumBatchHashes = 3
+
+const maxBatchParamsTries = 8
+
+// Public description of a batch DB, which clients need to find the buckets
+// that hold each entry of the original DB.
+type BatchLayout struct {
+	Seed    uint64 // seed of the hash functions
+	Num     uint64 // number of entries of the original DB
+	Buckets uint64 // number of buckets, i.e., of queries per batch
+
+	slots []uint32 // slots[NumBatchHashes*i+h]: slot of entry i in its h-th bucket
+	loads []uint64 // number of entries per bucket
+}
+
+// Builds the layout of num entries hashed into the given number of buckets.
+func NewBatchLayout(seed, num, buckets uint64) (*BatchLayout, error) {
+	if num == 0 {
+		return nil, ErrEmptyDB
+	}
+	if num > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d entries", ErrBadParams, num)
+	}
+	if buckets < NumBatchHashes {
+		return nil, fmt.Errorf("%w: need at least %d buckets, got %d", ErrBadParams, NumBatchHashes, buckets)
+	}
+
+	b := &BatchLayout{Seed: seed, Num: num, Buckets: buckets}
+	b.slots = make([]uint32, NumBatchHashes*num)
+	b.loads = make([]uint64, buckets)
+	for i := uint64(0); i < num; i++ {
+		for h, bucket := range b.buckets(i) {
+			b.slots[NumBatchHashes*i+uint64(h)] = uint32(b.loads[bucket])
+			b.loads[bucket] += 1
+		}
+	}
+	return b, nil
+}
+
+// Returns the NumBatchHashes distinct buckets that hold entry i.
+func (b *BatchLayout) buckets(i uint64) [NumBatchHashes]uint64 {
+	var out [NumBatchHashes]uint64
+	n := 0
+	for h := uint64(0); n < NumBatchHashes; h++ {
+		bucket := mix64(i^mix64(b.Seed+h)) % b.Buckets
+		dup := false
+		for _, o := range out[:n] {
+			dup = dup || o == bucket
+		}
+		if !dup {
+			out[n] = bucket
+			n += 1
+		}
+	}
+	return out
+}
+
+// Returns the slot of entry i in bucket, which must be one of its buckets.
+func (b *BatchLayout) slot(i, bucket uint64) uint64 {
+	for h, o := range b.buckets(i) {
+		if o == bucket {
+			return uint64(b.slots[NumBatchHashes*i+uint64(h)])
+		}
+	}
+	panic("entry is not in bucket")
+}
+
+// Whether every bucket fits in the rows of its query.
+func (b *BatchLayout) fits(p Params, info DBinfo) bool {
+	for bucket, load := range b.loads {
+		if _, sz := batchSubTable(uint64(bucket), b.Buckets, p, info); sz < load {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of Z_p elems per entry of a batch DB with d-bit entries.
+func batchElems(d uint64, p Params) (uint64, error) {
+	_, ne, packing := Num_DB_entries(1, d, p.P)
+	if packing > 1 {
+		return 0, fmt.Errorf("%w: %d-bit entries are packed %d per Z_p elem", ErrBadParams, d, packing)
+	}
+	return ne, nil
+}
+
+// Picks params for a batch DB over N entries of d bits each, from which
+// clients fetch up to k entries per batch, along with the DB's layout.
+func PickBatchParams(pi CheckedPIR, N, d, k, n, logq uint64) (Params, *BatchLayout, error) {
+	if k == 0 {
+		return Params{}, nil, fmt.Errorf("%w: empty batch", ErrBadParams)
+	}
+	buckets := (3*k + 1) / 2
+	if buckets < NumBatchHashes {
+		buckets = NumBatchHashes
+	}
+	layout, err := NewBatchLayout(RandomBufPRG().Uint64(), N, buckets)
+	if err != nil {
+		return Params{}, nil, err
+	}
+
+	max_load := uint64(0)
+	for _, load := range layout.loads {
+		if load > max_load {
+			max_load = load
+		}
+	}
+
+	// Buckets are aligned to entry boundaries, which wastes a few rows, so
+	// grow the DB until every bucket fits.
+	size := buckets * max_load
+	for try := 0; try < maxBatchParamsTries; try++ {
+		p, err := pi.PickParamsChecked(size, d, n, logq)
+		if err != nil {
+			return Params{}, nil, err
+		}
+		ne, err := batchElems(d, p)
+		if err != nil {
+			return Params{}, nil, err
+		}
+		if p.L/buckets < ne {
+			return Params{}, nil, fmt.Errorf("%w: %d buckets, %d rows", ErrTooManyQueries, buckets, p.L)
+		}
+		if layout.fits(p, DBinfo{Ne: ne}) {
+			return p, layout, nil
+		}
+		size += size/8 + buckets
+	}
+
+	return Params{}, nil, fmt.Errorf("%w: no params fit %d buckets of up to %d entries",
+		ErrNoParams, buckets, max_load)
+}
+
+// Builds a batch DB that stores vals[i] in each of entry i's buckets, for
+// params and layout returned by PickBatchParams.
+func MakeBatchDB(vals []uint64, d uint64, p *Params, layout *BatchLayout) (*Database, error) {
+	if uint64(len(vals)) != layout.Num {
+		return nil, fmt.Errorf("%w: got %d values for %d entries", ErrBadInput, len(vals), layout.Num)
+	}
+	ne, err := batchElems(d, *p)
+	if err != nil {
+		return nil, err
+	}
+	info := DBinfo{Ne: ne}
+	if p.L/layout.Buckets < ne || !layout.fits(*p, info) {
+		return nil, fmt.Errorf("%w: %d buckets do not fit in %d-by-%d matrix", ErrDBSizeMismatch,
+			layout.Buckets, p.L, p.M)
+	}
+
+	entries := make([]uint64, (p.L/ne)*p.M)
+	for i, v := range vals {
+		for _, bucket := range layout.buckets(uint64(i)) {
+			first, _ := batchSubTable(bucket, layout.Buckets, *p, info)
+			entries[first+layout.slot(uint64(i), bucket)] = v
+		}
+	}
+	return MakeDBChecked(uint64(len(entries)), d, p, entries)
+}
+
+// Assigns each index to a distinct bucket that holds it, with random-walk
+// cuckoo hashing; repeated indices share a bucket. Returns the bucket of
+// each index, and the index in each bucket (or -1 if the bucket is unused).
+func (b *BatchLayout) assign(indices []uint64) ([]uint64, []int64, error) {
+	owner := make([]int64, b.Buckets) // index of the entry in each bucket, or -1
+	for j := range owner {
+		owner[j] = -1
+	}
+	rnd := mrand.New(mrand.NewSource(int64(b.Seed)))
+
+	for _, i := range indices {
+		if i >= b.Num {
+			return nil, nil, fmt.Errorf("%w: index %d, database has %d entries", ErrIndexOutOfRange, i, b.Num)
+		}
+	}
+
+	placed := make(map[uint64]bool, len(indices))
+	for _, i := range indices {
+		if placed[i] {
+			continue
+		}
+		placed[i] = true
+
+		cur := int64(i)
+		for kick := 0; cur >= 0; kick++ {
+			if kick == maxCuckooKicks {
+				return nil, nil, fmt.Errorf("%w: could not place %d indices in %d buckets",
+					ErrTooManyQueries, len(placed), b.Buckets)
+			}
+			candidates := b.buckets(uint64(cur))
+			done := false
+			for _, c := range candidates {
+				if owner[c] < 0 {
+					owner[c], cur, done = cur, -1, true
+					break
+				}
+			}
+			if !done {
+				c := candidates[rnd.Intn(NumBatchHashes)]
+				owner[c], cur = cur, owner[c]
+			}
+		}
+	}
+
+	bucket_of := make(map[uint64]uint64, len(placed))
+	for bucket, i := range owner {
+		if i >= 0 {
+			bucket_of[uint64(i)] = uint64(bucket)
+		}
+	}
+	out := make([]uint64, len(indices))
+	for j, i := range indices {
+		out[j] = bucket_of[i]
+	}
+	return out, owner, nil
+}
+
+// Secrets of an outstanding batch built by QueryBatch.
+type PendingBatch struct {
+	pending *PendingQuery
+	buckets []uint64 // query that fetches each requested index
+}
+
+// Builds a batch of layout.Buckets queries that fetches the entries of the
+// original DB at the given indices, in any order and any rows.
+func (c *Client) QueryBatch(layout *BatchLayout, indices ...uint64) (*PendingBatch, MsgSlice, error) {
+	if len(indices) == 0 {
+		return nil, MsgSlice{}, fmt.Errorf("%w: empty batch", ErrBadQuery)
+	}
+	buckets, owner, err := layout.assign(indices)
+	if err != nil {
+		return nil, MsgSlice{}, err
+	}
+
+	// Unused buckets get a dummy query for their first slot.
+	queries := make([]uint64, layout.Buckets)
+	for bucket, i := range owner {
+		queries[bucket], _ = batchSubTable(uint64(bucket), layout.Buckets, c.params, c.info)
+		if i >= 0 {
+			queries[bucket] += layout.slot(uint64(i), uint64(bucket))
+		}
+	}
+
+	pending, query, err := c.Query(queries...)
+	if err != nil {
+		return nil, MsgSlice{}, err
+	}
+	return &PendingBatch{pending: pending, buckets: buckets}, query, nil
+}
+
+// Decodes the answer to QueryBatch, returning the entry at each requested
+// index, in the order they were requested.
+func (c *Client) RecoverBatch(pending *PendingBatch, answer Msg) ([]uint64, error) {
+	if pending == nil {
+		return nil, fmt.Errorf("%w: no pending query", ErrBadState)
+	}
+
+	vals := make([]uint64, len(pending.buckets))
+	for j, bucket := range pending.buckets {
+		val, err := c.recoverAt(pending.pending, int(bucket), answer)
+		if err != nil {
+			return nil, err
+		}
+		vals[j] = val
+	}
+	return vals, nil
+}
+
+func (b BatchLayout) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireBatchLayout)
+	buf = appendUint64(buf, b.Seed)
+	buf = appendUvarint(buf, b.Num)
+	buf = appendUvarint(buf, b.Buckets)
+	return buf, nil
+}
+
+// Decodes a layout, and recomputes where every entry lives.
+func (b *BatchLayout) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireBatchLayout)
+	var seed uint64
+	if s := d.bytes(8); d.err == nil {
+		seed = binary.LittleEndian.Uint64(s)
+	}
+	num := d.uvarint()
+	buckets := d.uvarint()
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	out, err := NewBatchLayout(seed, num, buckets)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadEncoding, err)
+	}
+	*b = *out
+	return nil
+}
@@ -0,0 +1,113 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+// Fetches k arbitrary entries, including repeated ones and ones from the same
+// rows, in a single batch.
+func testBatchPir(t *testing.T, pi CheckedPIR, N, d, k uint64) {
+	p, layout, err := PickBatchParams(pi, N, d, k, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prg := RandomBufPRG()
+	vals := make([]uint64, N)
+	for i := range vals {
+		vals[i] = prg.Uint64() & (1<<d - 1)
+	}
+	DB, err := MakeBatchDB(vals, d, &p, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := layout.MarshalBinary()
+	var client_layout BatchLayout
+	if err := client_layout.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indices := []uint64{N - 1, 0, 1, 2, N - 1}
+	for uint64(len(indices)) < k {
+		indices = append(indices, prg.Uint64()%N)
+	}
+	pending, query, err := client.QueryBatch(&client_layout, indices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(query.Data)) != layout.Buckets {
+		t.Fatalf("%d queries for %d buckets", len(query.Data), layout.Buckets)
+	}
+	answer, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.RecoverBatch(pending, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, i := range indices {
+		if got[j] != vals[i] {
+			t.Fatalf("index %d: got %d instead of %d", i, got[j], vals[i])
+		}
+	}
+
+	// The server processes fewer entries than k separate queries would.
+	if p.L*p.M >= k*N*DB.Info.Ne {
+		t.Fatalf("%d-by-%d batch DB for %d queries over %d entries", p.L, p.M, k, N)
+	}
+}
+
+func TestSimplePirBatchCodes(t *testing.T) {
+	testBatchPir(t, &SimplePIR{}, 1<<14, squishBasis-2, 32)
+}
+
+func TestSimplePirBatchCodesLongRow(t *testing.T) {
+	testBatchPir(t, &SimplePIR{}, 1<<12, 32, 16)
+}
+
+func TestBatchRejectsBadInput(t *testing.T) {
+	pi := &SimplePIR{}
+	p, layout, err := PickBatchParams(pi, 1<<12, squishBasis-2, 4, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeBatchDB(make([]uint64, 3), squishBasis-2, &p, layout); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("wrong number of values: got %v", err)
+	}
+	if _, _, err := PickBatchParams(pi, 1<<12, 1, 4, SEC_PARAM, LOGQ); !errors.Is(err, ErrBadParams) {
+		t.Fatalf("packed entries: got %v", err)
+	}
+
+	DB, err := MakeBatchDB(make([]uint64, 1<<12), squishBasis-2, &p, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.QueryBatch(layout, 1<<12); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("index out of range: got %v", err)
+	}
+	var many []uint64
+	for i := uint64(0); i <= layout.Buckets; i++ {
+		many = append(many, i)
+	}
+	if _, _, err := client.QueryBatch(layout, many...); !errors.Is(err, ErrTooManyQueries) {
+		t.Fatalf("more indices than buckets: got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+// Runs a server and a client that only talk through encoded messages, as they
+// would across a network.
+func runClientServer(t *testing.T, pi CheckedPIR, N, d uint64, indices []uint64) {
+	p, err := pi.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB := MakeRandomDB(N, d, &p)
+	var expected []uint64
+	for _, i := range indices {
+		expected = append(expected, DB.GetElem(i))
+	}
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// offline phase: the server publishes its params, DB info, seed and hint
+	p_buf, _ := server.Params().MarshalBinary()
+	info_buf, _ := server.DBInfo().MarshalBinary()
+	seed_buf, err := server.Seed().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hint := server.Hint()
+	hint_buf, err := hint.Marshal(p.Logq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var client_p Params
+	var client_info DBinfo
+	var client_seed CompressedState
+	var client_hint Msg
+	if err := client_p.UnmarshalBinary(p_buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := client_info.UnmarshalBinary(info_buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := client_seed.UnmarshalBinary(seed_buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := client_hint.Unmarshal(hint_buf, client_p.Logq); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, client_p, client_info, client_seed, client_hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// online phase
+	pending, query, err := client.Query(indices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query_buf, err := query.Marshal(client_p.Logq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server_query MsgSlice
+	if err := server_query.Unmarshal(query_buf, p.Logq); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(server_query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer_buf, err := answer.Marshal(p.Logq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var client_answer Msg
+	if err := client_answer.Unmarshal(answer_buf, client_p.Logq); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := client.Recover(pending, client_answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := range indices {
+		if vals[j] != expected[j] {
+			t.Fatalf("index %d: got %d instead of %d", indices[j], vals[j], expected[j])
+		}
+	}
+}
+
+func TestSimplePirClientServer(t *testing.T) {
+	runClientServer(t, &SimplePIR{}, 1<<16, 8, []uint64{3})
+}
+
+func TestSimplePirClientServerBatch(t *testing.T) {
+	pi := SimplePIR{}
+	p := pi.PickParams(1<<16, 8, SEC_PARAM, LOGQ)
+	// the second query must come from the second half of the DB rows
+	runClientServer(t, &pi, 1<<16, 8, []uint64{5, (p.L/2)*p.M + 7})
+}
+
+func TestDoublePirClientServer(t *testing.T) {
+	runClientServer(t, &DoublePIR{}, 1<<12, 8, []uint64{100})
+}
+
+func TestClientRejectsBadBatch(t *testing.T) {
+	pi := SimplePIR{}
+	p := pi.PickParams(1<<12, 8, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(1<<12, 8, &p)
+	server, err := NewServer(&pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(&pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Query(); !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("empty batch: %v", err)
+	}
+	// both queries fall in the first half of the rows
+	if _, _, err := client.Query(0, 1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("misplaced batch index: %v", err)
+	}
+	if _, _, err := client.Query(dbCapacity(p, DB.Info)); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("index past the DB: %v", err)
+	}
+}
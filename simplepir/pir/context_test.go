@@ -0,0 +1,97 @@
+package pir
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSetupContextCancelled(t *testing.T) {
+	pi := SimplePIR{}
+	p := pi.PickParams(1<<18, 8, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(1<<18, 8, &p)
+	orig := DB.Copy()
+	shared := pi.Init(DB.Info, p, RandomBufPRG())
+
+	// cancel after the first block of rows
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, _, err := pi.SetupContext(ctx, DB, shared, p, func(done, total uint64) {
+		calls += 1
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v instead of context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Fatalf("setup went on for %d blocks after cancel", calls)
+	}
+	if DB.Info.Squishing != 0 || !sameMatrix(DB.Data, orig.Data) {
+		t.Fatal("cancelled setup modified the DB")
+	}
+
+	if _, err := NewServerContext(ctx, &pi, DB, p, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("server: got %v instead of context.Canceled", err)
+	}
+}
+
+func runSetupAndAnswerContext(t *testing.T, pi CheckedPIR, N, d uint64, i uint64) {
+	cp := pi.(ContextPIR)
+	p, err := pi.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB := MakeRandomDB(N, d, &p)
+	expected := DB.GetElem(i)
+	DB_copy := DB.Copy()
+	shared := pi.Init(DB.Info, p, RandomBufPRG())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last_done, last_total uint64
+	server_state, hint, err := cp.SetupContext(ctx, DB, shared, p, func(done, total uint64) {
+		if done <= last_done || done > total {
+			t.Fatalf("bad progress: %d of %d after %d", done, total, last_done)
+		}
+		last_done, last_total = done, total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last_done != last_total {
+		t.Fatalf("setup stopped at %d of %d rows", last_done, last_total)
+	}
+	_, want_hint := pi.Setup(DB_copy, shared, p)
+	if !sameMatrix(hint.Data[0], want_hint.Data[0]) {
+		t.Fatal("blockwise hint differs from Setup's")
+	}
+
+	client_state, query, err := pi.QueryChecked(i, shared, p, DB.Info, RandomBufPRG())
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := cp.AnswerContext(ctx, DB, MakeMsgSlice(query), server_state, shared, p, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := pi.RecoverChecked(i, 0, hint, query, answer, shared, client_state, p, DB.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != expected {
+		t.Fatalf("got %d instead of %d", val, expected)
+	}
+
+	cancel()
+	if _, err := cp.AnswerContext(ctx, DB, MakeMsgSlice(query), server_state, shared, p, 2, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("answer: got %v instead of context.Canceled", err)
+	}
+}
+
+func TestSimplePirContext(t *testing.T) {
+	runSetupAndAnswerContext(t, &SimplePIR{}, 1<<18, 8, 1<<17+5)
+}
+
+func TestDoublePirContext(t *testing.T) {
+	runSetupAndAnswerContext(t, &DoublePIR{}, 1<<12, 8, 100)
+}
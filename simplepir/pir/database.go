@@ -27,12 +27,24 @@ type Database struct {
 	Data *Matrix
 }
 
+// Returns a deep copy of the database.
+func (DB *Database) Copy() *Database {
+	D := new(Database)
+	D.Info = DB.Info
+	D.Data = DB.Data.RowsDeepCopy(0, DB.Data.Rows)
+	return D
+}
+
+// Number of Z_p elems that Setup packs in each Z_q elem, of squishBasis bits
+// each; hard-coded in the C kernels.
+const squishCompression = 3
+
 func (DB *Database) Squish() {
 	//fmt.Printf("Original DB dims: ")
 	//DB.Data.Dim()
 
-	DB.Info.Basis = 10
-	DB.Info.Squishing = 3 
+	DB.Info.Basis = squishBasis
+	DB.Info.Squishing = squishCompression
 	DB.Info.Cols = DB.Data.Cols
 	DB.Data.Squish(DB.Info.Basis, DB.Info.Squishing)
 
@@ -49,17 +61,19 @@ func (DB *Database) Unsquish() {
 	DB.Data.Unsquish(DB.Info.Basis, DB.Info.Squishing, DB.Info.Cols)
 }
 
-// Store the database with entries decomposed into Z_p elements, and mapped to [-p/2, p/2]
-// Z_p elements that encode the same database entry are stacked vertically below each other.
-func ReconstructElem(vals []uint64, index uint64, info DBinfo) uint64 {
-	q := uint64(1 << info.Logq)
-
+// Maps the Z_p elems recovered for a DB entry from [-p/2, p/2] back to [0, p), in place.
+func unmapElems(vals []uint64, info DBinfo) []uint64 {
 	for i, _ := range vals {
-		vals[i] = (vals[i] + info.P/2) % q
+		vals[i] = modQ(vals[i] + info.P/2, info.Logq)
 		vals[i] = vals[i] % info.P
 	}
+	return vals
+}
 
-	val := Reconstruct_from_base_p(info.P, vals)
+// Store the database with entries decomposed into Z_p elements, and mapped to [-p/2, p/2]
+// Z_p elements that encode the same database entry are stacked vertically below each other.
+func ReconstructElem(vals []uint64, index uint64, info DBinfo) uint64 {
+	val := Reconstruct_from_base_p(info.P, unmapElems(vals, info))
 
 	if info.Packing > 0 {
 		val = Base_p((1 << info.Row_length), val, index%info.Packing)
@@ -68,9 +82,35 @@ func ReconstructElem(vals []uint64, index uint64, info DBinfo) uint64 {
 	return val
 }
 
+// Returns the number of entries that fit in a DB built for params p.
+func dbCapacity(p Params, info DBinfo) uint64 {
+	if info.Packing > 0 {
+		return p.L * p.M * info.Packing
+	}
+	return (p.L / info.Ne) * p.M
+}
+
+// Returns the index of the Z_p elem (or of the column of ne Z_p elems) that
+// holds entry i, counting row by row.
+func elemIndex(i uint64, info DBinfo) uint64 {
+	if info.Packing > 0 {
+		return i / info.Packing
+	}
+	return i
+}
+
 func (DB *Database) GetElem(i uint64) uint64 {
+	val, err := DB.GetElemChecked(i)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
+// Same as GetElem, but returns ErrIndexOutOfRange instead of panicking.
+func (DB *Database) GetElemChecked(i uint64) (uint64, error) {
 	if i >= DB.Info.Num {
-		panic("Index out of range")
+		return 0, fmt.Errorf("%w: index %d, database has %d entries", ErrIndexOutOfRange, i, DB.Info.Num)
 	}
 
 	col := i % DB.Data.Cols
@@ -87,7 +127,7 @@ func (DB *Database) GetElem(i uint64) uint64 {
 		vals = append(vals, DB.Data.Get(j, col))
 	}
 
-	return ReconstructElem(vals, i, DB.Info)
+	return ReconstructElem(vals, i, DB.Info), nil
 }
 
 // Find smallest l, m such that l*m >= N*ne and ne divides l, where ne is
@@ -127,9 +167,44 @@ func ApproxDatabaseDims(N, row_length, p, lower_bound_m uint64) (uint64, uint64)
 	return l, m
 }
 
+// Returns the divisor X of ne (see DBinfo) that minimizes the number of Z_q
+// elems a DoublePIR client downloads and uploads for its first query, hint
+// included (see DoublePIR.GetBW). A larger X shrinks the ne/X second-level
+// queries, but grows the hint X-fold, which for entries of many Z_p elems
+// takes GBs.
+func pickRepetitions(ne uint64, p *Params) uint64 {
+	delta := p.delta()
+	best, best_cost := uint64(1), uint64(math.MaxUint64)
+	for x := uint64(1); x <= ne; x++ {
+		if ne%x != 0 {
+			continue
+		}
+		hint := delta * x * p.N * p.N
+		upload := p.M + (ne/x)*(p.L/x)
+		download := delta*x*p.N + delta*p.N*ne + delta*ne
+		if cost := hint + upload + download; cost < best_cost {
+			best, best_cost = x, cost
+		}
+	}
+	return best
+}
+
 func SetupDB(Num, row_length uint64, p *Params) *Database {
+	D, err := SetupDBChecked(Num, row_length, p)
+	if err != nil {
+		panic(err)
+	}
+	return D
+}
+
+// Same as SetupDB, but returns ErrEmptyDB, ErrInvalidParams, ErrDBSizeMismatch
+// or ErrBadParams instead of panicking.
+func SetupDBChecked(Num, row_length uint64, p *Params) (*Database, error) {
 	if (Num == 0) || (row_length == 0) {
-		panic("Empty database!")
+		return nil, ErrEmptyDB
+	}
+	if p.P < 2 || p.L == 0 || p.M == 0 {
+		return nil, fmt.Errorf("%w: p=%d, l=%d, m=%d", ErrInvalidParams, p.P, p.L, p.M)
 	}
 
 	D := new(Database)
@@ -141,13 +216,9 @@ func SetupDB(Num, row_length uint64, p *Params) *Database {
 
 	db_elems, elems_per_entry, entries_per_elem := Num_DB_entries(Num, row_length, p.P)
 	D.Info.Ne = elems_per_entry
-	D.Info.X = D.Info.Ne
+	D.Info.X = pickRepetitions(D.Info.Ne, p)
 	D.Info.Packing = entries_per_elem
 
-	for D.Info.Ne%D.Info.X != 0 {
-		D.Info.X += 1
-	}
-
 	D.Info.Basis = 0
 	D.Info.Squishing = 0
 
@@ -155,19 +226,25 @@ func SetupDB(Num, row_length uint64, p *Params) *Database {
 		float64(p.L*p.M)*math.Log2(float64(p.P))/(1024.0*1024.0*8.0))
 
 	if db_elems > p.L*p.M {
-		panic("Params and database size don't match")
+		return nil, fmt.Errorf("%w: %d Z_p elems, %d-by-%d matrix", ErrDBSizeMismatch, db_elems, p.L, p.M)
 	}
 
 	if p.L%D.Info.Ne != 0 {
-		panic("Number of DB elems per entry must divide DB height")
+		return nil, fmt.Errorf("%w: number of DB elems per entry (%d) must divide DB height (%d)",
+			ErrBadParams, D.Info.Ne, p.L)
 	}
 
-	return D
+	return D, nil
 }
 
 func MakeRandomDB(Num, row_length uint64, p *Params) *Database {
+	return MakeRandomDBSeeded(Num, row_length, p, RandomBufPRG())
+}
+
+// Same as MakeRandomDB, with the DB elems drawn from prg.
+func MakeRandomDBSeeded(Num, row_length uint64, p *Params, prg *BufPRGReader) *Database {
 	D := SetupDB(Num, row_length, p)
-	D.Data = MatrixRand(p.L, p.M, 0, p.P)
+	D.Data = MatrixRand(prg, p.L, p.M, 0, p.P)
 
 	// Map DB elems to [-p/2; p/2]
 	D.Data.Sub(p.P / 2)
@@ -176,12 +253,25 @@ func MakeRandomDB(Num, row_length uint64, p *Params) *Database {
 }
 
 func MakeDB(Num, row_length uint64, p *Params, vals []uint64) *Database {
-	D := SetupDB(Num, row_length, p)
-	D.Data = MatrixZeros(p.L, p.M)
+	D, err := MakeDBChecked(Num, row_length, p, vals)
+	if err != nil {
+		panic(err)
+	}
+	return D
+}
 
+// Same as MakeDB, but returns an error (see SetupDBChecked, plus ErrBadInput)
+// instead of panicking.
+func MakeDBChecked(Num, row_length uint64, p *Params, vals []uint64) (*Database, error) {
 	if uint64(len(vals)) != Num {
-		panic("Bad input DB")
+		return nil, fmt.Errorf("%w: got %d values for %d entries", ErrBadInput, len(vals), Num)
+	}
+
+	D, err := SetupDBChecked(Num, row_length, p)
+	if err != nil {
+		return nil, err
 	}
+	D.Data = MatrixZeros(p.L, p.M)
 
 	if D.Info.Packing > 0 {
 		// Pack multiple DB elems into each Z_p elem
@@ -210,5 +300,5 @@ func MakeDB(Num, row_length uint64, p *Params, vals []uint64) *Database {
 	// Map DB elems to [-p/2; p/2]
 	D.Data.Sub(p.P / 2)
 
-	return D
+	return D, nil
 }
@@ -0,0 +1,193 @@
+package pir
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// Distributed point functions over GF(2), after Boyle, Gilboa and Ishai
+// ("Function Secret Sharing: Improvements and Extensions", CCS 2016): gen
+// splits the point function that is 1 at alpha and 0 elsewhere, over a domain
+// of 2^depth points, into two keys. Each key alone looks random, and the
+// bits that the two keys evaluate to at x XOR to 1 exactly when x = alpha.
+//
+// Keys take 16 + 17*depth bytes. The PRG that expands a seed into two child
+// seeds and control bits is fixed-key AES in Matyas-Meyer-Oseas mode.
+
+const dpfSeedBytes = aes.BlockSize
+
+type dpfSeed [dpfSeedBytes]byte
+
+// Correction word of one level of the tree.
+type dpfCW struct {
+	s      dpfSeed
+	tl, tr byte
+}
+
+type dpfKey struct {
+	seed dpfSeed
+	cws  []dpfCW
+}
+
+// Fixed keys of the two halves of the PRG.
+var dpfCiphers = func() [2]cipher.Block {
+	var out [2]cipher.Block
+	for j, key := range []string{"simplepir dpf  L", "simplepir dpf  R"} {
+		block, err := aes.NewCipher([]byte(key))
+		if err != nil {
+			panic(err)
+		}
+		out[j] = block
+	}
+	return out
+}()
+
+// Expands s into the seeds and control bits of its left and right children.
+func dpfExpand(s, sl, sr *dpfSeed) (tl, tr byte) {
+	dpfCiphers[0].Encrypt(sl[:], s[:])
+	dpfCiphers[1].Encrypt(sr[:], s[:])
+	for k := range s {
+		sl[k] ^= s[k]
+		sr[k] ^= s[k]
+	}
+	tl, tr = sl[0]&1, sr[0]&1
+	sl[0] &^= 1
+	sr[0] &^= 1
+	return
+}
+
+func (s *dpfSeed) xor(t *dpfSeed) {
+	for k := range s {
+		s[k] ^= t[k]
+	}
+}
+
+func randomDPFSeed(prg *BufPRGReader) dpfSeed {
+	var s dpfSeed
+	for k := 0; k < dpfSeedBytes; k += 8 {
+		v := prg.Uint64()
+		for j := 0; j < 8; j++ {
+			s[k+j] = byte(v >> (8 * j))
+		}
+	}
+	return s
+}
+
+// Returns the keys of the two parties for the point function at alpha, over
+// the domain [0, 2^depth).
+func dpfGen(alpha uint64, depth int, prg *BufPRGReader) [2]dpfKey {
+	var keys [2]dpfKey
+	var s [2]dpfSeed
+	t := [2]byte{0, 1}
+	for b := range s {
+		s[b] = randomDPFSeed(prg)
+		keys[b].seed = s[b]
+	}
+
+	for level := 0; level < depth; level++ {
+		bit := byte(alpha>>(depth-1-level)) & 1
+		var sl, sr [2]dpfSeed
+		var tl, tr [2]byte
+		for b := range s {
+			tl[b], tr[b] = dpfExpand(&s[b], &sl[b], &sr[b])
+		}
+
+		// The seeds off alpha's path become equal, and so do their control
+		// bits, while on the path the control bits keep differing.
+		cw := dpfCW{tl: tl[0] ^ tl[1] ^ bit ^ 1, tr: tr[0] ^ tr[1] ^ bit}
+		if bit == 0 {
+			cw.s = sr[0]
+			cw.s.xor(&sr[1])
+		} else {
+			cw.s = sl[0]
+			cw.s.xor(&sl[1])
+		}
+		for b := range s {
+			next, next_t, cw_t := sl[b], tl[b], cw.tl
+			if bit == 1 {
+				next, next_t, cw_t = sr[b], tr[b], cw.tr
+			}
+			if t[b] == 1 {
+				next.xor(&cw.s)
+				next_t ^= cw_t
+			}
+			s[b], t[b] = next, next_t
+		}
+		keys[0].cws = append(keys[0].cws, cw)
+		keys[1].cws = append(keys[1].cws, cw)
+	}
+	return keys
+}
+
+// Evaluates party's key at every point x < n of the domain, and calls leaf
+// with the points at which it evaluates to 1.
+func (k *dpfKey) evalAll(party byte, n uint64, leaf func(x uint64)) {
+	depth := len(k.cws)
+	// The children of the node being expanded at each level; a depth-first
+	// walk only needs one pair per level.
+	children := make([][2]dpfSeed, depth)
+
+	var walk func(level int, prefix uint64, s *dpfSeed, t byte)
+	walk = func(level int, prefix uint64, s *dpfSeed, t byte) {
+		if prefix<<(depth-level) >= n {
+			return
+		}
+		if level == depth {
+			if t == 1 {
+				leaf(prefix)
+			}
+			return
+		}
+
+		sl, sr := &children[level][0], &children[level][1]
+		tl, tr := dpfExpand(s, sl, sr)
+		if t == 1 {
+			cw := &k.cws[level]
+			sl.xor(&cw.s)
+			sr.xor(&cw.s)
+			tl ^= cw.tl
+			tr ^= cw.tr
+		}
+		walk(level+1, prefix<<1, sl, tl)
+		walk(level+1, prefix<<1|1, sr, tr)
+	}
+	seed := k.seed
+	walk(0, 0, &seed, party)
+}
+
+// Number of bytes in a key over a domain of 2^depth points.
+func dpfKeyBytes(depth int) uint64 {
+	return dpfSeedBytes + uint64(depth)*(dpfSeedBytes+1)
+}
+
+func (k *dpfKey) marshal() []byte {
+	buf := append([]byte{}, k.seed[:]...)
+	for _, cw := range k.cws {
+		buf = append(buf, cw.s[:]...)
+		buf = append(buf, cw.tl|cw.tr<<1)
+	}
+	return buf
+}
+
+// Decodes a key over a domain of 2^depth points; returns false if buf is
+// malformed.
+func unmarshalDPFKey(buf []byte, depth int) (dpfKey, bool) {
+	if uint64(len(buf)) != dpfKeyBytes(depth) {
+		return dpfKey{}, false
+	}
+	var k dpfKey
+	copy(k.seed[:], buf)
+	buf = buf[dpfSeedBytes:]
+	for level := 0; level < depth; level++ {
+		var cw dpfCW
+		copy(cw.s[:], buf)
+		bits := buf[dpfSeedBytes]
+		if bits>>2 != 0 {
+			return dpfKey{}, false
+		}
+		cw.tl, cw.tr = bits&1, bits>>1
+		k.cws = append(k.cws, cw)
+		buf = buf[dpfSeedBytes+1:]
+	}
+	return k, true
+}
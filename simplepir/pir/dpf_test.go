@@ -0,0 +1,98 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+// The two keys evaluate to different bits exactly at alpha, and survive
+// encoding.
+func TestDPF(t *testing.T) {
+	prg := RandomBufPRG()
+	for depth := 0; depth <= 7; depth++ {
+		n := uint64(1) << depth
+		if depth > 2 {
+			n -= 3 // a domain that is not a power of two
+		}
+		for alpha := uint64(0); alpha < n; alpha++ {
+			keys := dpfGen(alpha, depth, prg)
+			ones := make([]byte, n)
+			for party := range keys {
+				buf := keys[party].marshal()
+				if uint64(len(buf)) != dpfKeyBytes(depth) {
+					t.Fatalf("depth %d: key of %d bytes", depth, len(buf))
+				}
+				key, ok := unmarshalDPFKey(buf, depth)
+				if !ok {
+					t.Fatalf("depth %d: cannot decode key", depth)
+				}
+				key.evalAll(byte(party), n, func(x uint64) {
+					ones[x] ^= 1
+				})
+			}
+			for x := range ones {
+				want := byte(0)
+				if uint64(x) == alpha {
+					want = 1
+				}
+				if ones[x] != want {
+					t.Fatalf("depth %d, alpha %d: keys XOR to %d at %d", depth, alpha, ones[x], x)
+				}
+			}
+		}
+	}
+
+	keys := dpfGen(5, 4, prg)
+	buf := keys[0].marshal()
+	if _, ok := unmarshalDPFKey(buf[1:], 4); ok {
+		t.Fatal("decoded short key")
+	}
+	buf[len(buf)-1] = 4
+	if _, ok := unmarshalDPFKey(buf, 4); ok {
+		t.Fatal("decoded key with bad control bits")
+	}
+}
+
+func TestTwoServerDPF(t *testing.T) {
+	pi := TwoServerDPF{}
+	for _, c := range []struct{ N, d uint64 }{{1 << 20, 1}, {1 << 16, 8}, {1 << 14, 32}, {1000, 64}} {
+		p := pi.PickParams(c.N, c.d, SEC_PARAM, LOGQ)
+		DB := MakeRandomDB(c.N, c.d, &p)
+		m, err := RunPIRChecked(&pi, DB, p, []uint64{c.N / 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// No hint, two keys up, and one record per server down.
+		_, depth := dpfDomain(p, DB.Info)
+		if m.Query.Bytes < 2*dpfKeyBytes(depth) || m.Query.Bytes > 2*dpfKeyBytes(depth)+64 {
+			t.Fatalf("N=%d, d=%d: sent %d bytes of query", c.N, c.d, m.Query.Bytes)
+		}
+		if want := 2 * DB.Info.Ne * p.AnswerBits() / 8; m.Setup.Bytes > 16 || m.Answer.Bytes > want+64 {
+			t.Fatalf("N=%d, d=%d: sent %d bytes of hint, %d of answer", c.N, c.d, m.Setup.Bytes,
+				m.Answer.Bytes)
+		}
+	}
+
+	N, d := uint64(1<<12), uint64(8)
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	RunPIR(&pi, DB, p, []uint64{N - 1})
+	RunPIRCompressed(&pi, DB.Copy(), p, []uint64{N / 2})
+
+	DB = MakeRandomDB(N, d, &p)
+	server, _, err := pi.SetupChecked(DB, MakeState(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, q, err := pi.QueryChecked(7, MakeState(), p, DB.Info, RandomBufPRG())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Data = q.Data[:1]
+	if _, err := pi.AnswerChecked(DB, MakeMsgSlice(q), server, MakeState(), p); !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("one key: got %v", err)
+	}
+	if _, _, err := pi.QueryChecked(N*2, MakeState(), p, DB.Info, RandomBufPRG()); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("index past the DB: got %v", err)
+	}
+}
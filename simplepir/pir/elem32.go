@@ -0,0 +1,16 @@
+//go:build !elem64
+
+package pir
+
+// The default build: elems, and so ciphertexts, are 32 bits wide, i.e.,
+// logq = 32. Build with the elem64 tag for logq = 64.
+
+// Number of bits per Z_p elem in a squished DB, so p can be at most 2^10.
+const squishBasis = 10
+
+var (
+	// The params of the SimplePIR paper, as in the embedded table.
+	Preset128 = SecurityPreset{Name: "128", N: 1 << 10, Logq: 32, Security: 128}
+	Preset192 = SecurityPreset{Name: "192", N: 1536, Logq: 32, Security: 192}
+	Preset256 = SecurityPreset{Name: "256", N: 1 << 11, Logq: 32, Security: 256}
+)
@@ -1,5 +1,7 @@
 package pir
 
+import "math"
+
 var cdf_table = [...]float64{
 	0.5, 0.987867, 0.952345, 0.895957, 0.822578, 0.736994, 0.644389, 0.549831, 0.457833, 0.372034,
 	0.295023, 0.22831, 0.172422, 0.127074, 0.0913938, 0.0641467, 0.0439369, 0.0293685, 0.0191572,
@@ -22,11 +24,15 @@ var cdf_table = [...]float64{
 	3.05465e-82, 1.46185e-83, 6.82713e-85, 3.11152e-86, 1.3839e-87,
 }
 
+// Stddev of the samples of GaussSample: cdf_table[x] = exp(-x^2/(2*sigma^2)),
+// for x up to about 20 sigma.
+const gaussSigma = 6.4
+
 // The function below is modeled on Martin Albrecht's discrete-Gaussian
 // sampler included in his dgs library:
 //    https://github.com/malb/dgs
-func GaussSample() int64 {
-	mrand := MathRand()
+func GaussSample(prg *BufPRGReader) int64 {
+	mrand := prg.MathRand()
 
 	var x int64
 	var y float64
@@ -45,3 +51,35 @@ func GaussSample() int64 {
 
 	return x
 }
+
+// Same as GaussSample, with stddev sigma. Falls back to GaussSample's table
+// when sigma is that of the table, or unset.
+func GaussSampleSigma(prg *BufPRGReader, sigma float64) int64 {
+	if sigma == gaussSigma || sigma == 0 {
+		return GaussSample(prg)
+	}
+	mrand := prg.MathRand()
+	tail := int(math.Ceil(20 * sigma))
+
+	var x int64
+	var y float64
+	for {
+		x = int64(mrand.Intn(tail))
+		y = mrand.Float64()
+
+		// 0 is drawn for both signs, so it gets half the weight.
+		rho := 0.5
+		if x != 0 {
+			rho = math.Exp(-float64(x*x) / (2 * sigma * sigma))
+		}
+		if y < rho {
+			break
+		}
+	}
+
+	if mrand.Uint64()%2 == 0 {
+		x = -x
+	}
+
+	return x
+}
@@ -2,16 +2,33 @@ package pir
 
 import (
 	"log"
+	"math"
 	"testing"
 )
 
 func TestGauss(t *testing.T) {
+	prg := RandomBufPRG()
 	buckets := make([]int, 256)
 	for i := 0; i < 1000000; i++ {
-		buckets[GaussSample()+128] += 1
+		buckets[GaussSample(prg)+128] += 1
 	}
 
 	for i := 0; i < len(buckets); i++ {
 		log.Printf("bucket[%v] = %v", i, buckets[i])
 	}
 }
+
+func TestGaussSigma(t *testing.T) {
+	prg := RandomBufPRG()
+	for _, sigma := range []float64{MinSigma, gaussSigma, 10} {
+		sum := 0.0
+		num := 200000
+		for i := 0; i < num; i++ {
+			x := float64(GaussSampleSigma(prg, sigma))
+			sum += x * x
+		}
+		if got := math.Sqrt(sum / float64(num)); math.Abs(got-sigma) > 0.02*sigma {
+			t.Fatalf("sigma=%f: got stddev %f", sigma, got)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package pir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Golden vectors: deterministic runs must send the same messages as recorded
+// in testdata, so that changes to the kernels, the samplers or the encodings
+// cannot silently change what goes over the wire. After an intended change,
+// record new vectors with GOLDEN_UPDATE=1 go test -run Golden (and again with
+// -tags elem64).
+
+var goldenKey = PRGKey{'s', 'i', 'm', 'p', 'l', 'e', 'p', 'i', 'r', ' ', 'g', 'o', 'l', 'd', 'e', 'n'}
+
+// The hint is too large to commit, so only its digest is; the query and
+// answer are committed in full, so that a mismatch shows which elems changed.
+type goldenVector struct {
+	Name       string
+	Params     string // hex of Params.MarshalBinary
+	Index      uint64
+	HintSHA256 string
+	Query      string // hex of MsgSlice.Marshal, with logq bits per elem
+	Answer     string // hex of Msg.Marshal, with AnswerBits() bits per elem
+	Values     []uint64
+}
+
+func goldenFile() string {
+	return filepath.Join("testdata", fmt.Sprintf("golden-elem%d.json", elemBits))
+}
+
+func digest(b []byte, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hexOf(b []byte, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Describes where two lists of matrices first differ, and in how many elems.
+func matricesDiff(got, want []*Matrix) string {
+	if len(got) != len(want) {
+		return fmt.Sprintf("%d matrices instead of %d", len(got), len(want))
+	}
+	first, count := "", 0
+	for k := range want {
+		g, w := got[k], want[k]
+		if g.Rows != w.Rows || g.Cols != w.Cols {
+			return fmt.Sprintf("matrix %d is %d-by-%d instead of %d-by-%d", k, g.Rows, g.Cols, w.Rows, w.Cols)
+		}
+		for j := range w.Data {
+			if g.Data[j] == w.Data[j] {
+				continue
+			}
+			if count == 0 {
+				first = fmt.Sprintf("matrix %d, elem (%d, %d) is %d instead of %d", k,
+					uint64(j)/w.Cols, uint64(j)%w.Cols, g.Data[j], w.Data[j])
+			}
+			count++
+		}
+	}
+	return fmt.Sprintf("%s (%d elems differ)", first, count)
+}
+
+// Decodes two hex-encoded messages of the given kind, and describes where
+// they differ.
+func msgDiff(kind string, got, want string, logq uint64) string {
+	g, err := hex.DecodeString(got)
+	if err != nil {
+		return err.Error()
+	}
+	w, err := hex.DecodeString(want)
+	if err != nil {
+		return err.Error()
+	}
+
+	var g_ms, w_ms []*Matrix
+	if kind == "query" {
+		var g_msg, w_msg MsgSlice
+		if err := w_msg.Unmarshal(w, logq); err != nil {
+			return err.Error()
+		}
+		if err := g_msg.Unmarshal(g, logq); err != nil {
+			return err.Error()
+		}
+		for k := range w_msg.Data {
+			w_ms = append(w_ms, w_msg.Data[k].Data...)
+		}
+		for k := range g_msg.Data {
+			g_ms = append(g_ms, g_msg.Data[k].Data...)
+		}
+	} else {
+		var g_msg, w_msg Msg
+		if err := w_msg.Unmarshal(w, logq); err != nil {
+			return err.Error()
+		}
+		if err := g_msg.Unmarshal(g, logq); err != nil {
+			return err.Error()
+		}
+		g_ms, w_ms = g_msg.Data, w_msg.Data
+	}
+	return matricesDiff(g_ms, w_ms)
+}
+
+func runGolden(t *testing.T, name string, pi CheckedPIR, N, d uint64, p Params) goldenVector {
+	prg := NewBufPRG(NewPRG(&goldenKey))
+	DB := MakeRandomDBSeeded(N, d, &p, prg)
+	_, tr, err := RunPIRDeterministic(pi, DB, p, []uint64{N / 3}, &goldenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := tr.Params.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return goldenVector{
+		Name:       name,
+		Params:     hex.EncodeToString(params),
+		Index:      N / 3,
+		HintSHA256: digest(tr.Hint.Marshal(p.HintBits())),
+		Query:      hexOf(tr.Query.Marshal(p.Logq)),
+		Answer:     hexOf(tr.Answer.Marshal(p.AnswerBits())),
+		Values:     tr.Values,
+	}
+}
+
+func TestGoldenVectors(t *testing.T) {
+	N, d := uint64(1<<12), uint64(8)
+	simple, double := SimplePIR{}, DoublePIR{}
+	compressed, err := simple.PickParamsCompressed(N, d, SEC_PARAM, LOGQ, LOGQ*5/8, LOGQ/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []goldenVector{
+		runGolden(t, "SimplePIR", &simple, N, d, simple.PickParams(N, d, SEC_PARAM, LOGQ)),
+		runGolden(t, "SimplePIR compressed", &simple, N, d, compressed),
+		// PickParams' DoublePIR dims take GBs of memory with 64-bit elems.
+		runGolden(t, "DoublePIR", &double, N, d, double.PickParamsGivenDimensions(64, 1024, SEC_PARAM, LOGQ)),
+	}
+
+	if os.Getenv("GOLDEN_UPDATE") != "" {
+		buf, err := json.MarshalIndent(got, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile(), append(buf, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	buf, err := os.ReadFile(goldenFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []goldenVector
+	if err := json.Unmarshal(buf, &want); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != len(got) {
+		t.Fatalf("%d golden vectors, ran %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Params != w.Params {
+			t.Errorf("%s: params changed: got %s instead of %s", w.Name, g.Params, w.Params)
+			continue
+		}
+		var p Params
+		if err := p.UnmarshalBinary(mustHex(t, w.Params)); err != nil {
+			t.Fatal(err)
+		}
+		if g.HintSHA256 != w.HintSHA256 {
+			t.Errorf("%s: hint changed: got SHA-256 %s instead of %s", w.Name, g.HintSHA256, w.HintSHA256)
+		}
+		if g.Query != w.Query {
+			t.Errorf("%s: query changed: %s", w.Name, msgDiff("query", g.Query, w.Query, p.Logq))
+		}
+		if g.Answer != w.Answer {
+			t.Errorf("%s: answer changed: %s", w.Name, msgDiff("answer", g.Answer, w.Answer, p.AnswerBits()))
+		}
+		if g.Index != w.Index || fmt.Sprint(g.Values) != fmt.Sprint(w.Values) {
+			t.Errorf("%s: recovered %v at %d instead of %v at %d", w.Name, g.Values, g.Index,
+				w.Values, w.Index)
+		}
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Seeded clients send the same queries for the same key, and only then.
+func TestSeededClient(t *testing.T) {
+	N, d := uint64(1<<12), uint64(8)
+	pi := SimplePIR{}
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	server, err := NewShardServer(&pi, DB, p, MakeCompressedState(&goldenKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(key PRGKey) []byte {
+		client, err := NewClientSeeded(&pi, server.Params(), server.DBInfo(), server.Seed(),
+			server.Hint(), &key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, q, err := client.Query(N / 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := server.Answer(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Recover(pending, answer)
+		if err != nil || res[0] != DB.GetElem(N/3) {
+			t.Fatalf("got %v, %v instead of %d", res, err, DB.GetElem(N/3))
+		}
+		buf, err := q.Marshal(p.Logq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	other := goldenKey
+	other[0] ^= 1
+	if a, b, c := query(goldenKey), query(goldenKey), query(other); !bytes.Equal(a, b) || bytes.Equal(a, c) {
+		t.Fatal("queries do not follow the key")
+	}
+}
@@ -0,0 +1,104 @@
+package pir
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Integrity mode for record PIR: the DB owner appends to each record an
+// ed25519 signature of the record and its index, under a private key that
+// neither the server nor clients hold. Clients get the public key along with
+// the hint, and a client that decodes a record checks its signature, so a
+// faulty or malicious server that alters the DB or the answer (LWE answers
+// are malleable) is caught rather than silently yielding a wrong record.
+// Since checking a tag needs only the public key, neither a server that is
+// itself a client nor one that colludes with clients can forge tags. This
+// detects tampering with the records a client fetches; it does not stop a
+// server from serving a stale, correctly signed copy of the DB.
+//
+// Tagged records take RecordTagBytes more room, so pick params for them with
+// PickRecordParams(pi, N, max_len+RecordTagBytes, n, logq).
+
+const RecordTagBytes = ed25519.SignatureSize
+
+// Signed along with each record, so that its tags are not valid signatures
+// of anything else.
+const recordTagContext = "simplepir record tag"
+
+// Returns a fresh key pair for record tags, from crypto/rand: the DB owner
+// signs records with the private key, and publishes the public key to clients.
+func GenerateRecordKey() (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return pub, priv
+}
+
+// Returns the message that the tag of record i signs.
+func recordTagMessage(i uint64, rec []byte) []byte {
+	msg := make([]byte, 0, len(recordTagContext)+8+len(rec))
+	msg = append(msg, recordTagContext...)
+	msg = appendUint64(msg, i)
+	return append(msg, rec...)
+}
+
+// Returns the records with their tags appended, for MakeRecordDB.
+func TagRecords(key ed25519.PrivateKey, records [][]byte) [][]byte {
+	tagged := make([][]byte, len(records))
+	for i, rec := range records {
+		tag := ed25519.Sign(key, recordTagMessage(uint64(i), rec))
+		tagged[i] = append(append([]byte{}, rec...), tag...)
+	}
+	return tagged
+}
+
+// Checks the tag of record i, and strips it off.
+func checkRecordTag(key ed25519.PublicKey, i uint64, tagged []byte) ([]byte, error) {
+	if len(tagged) < RecordTagBytes {
+		return nil, fmt.Errorf("%w: record %d has no tag", ErrTampered, i)
+	}
+	rec := tagged[:len(tagged)-RecordTagBytes]
+	if !ed25519.Verify(key, recordTagMessage(i, rec), tagged[len(rec):]) {
+		return nil, fmt.Errorf("%w: record %d has a bad tag", ErrTampered, i)
+	}
+	return rec, nil
+}
+
+// Same as MakeRecordDB, but tags every record with the private key.
+func MakeTaggedRecordDB(records [][]byte, row_length uint64, p *Params, key ed25519.PrivateKey) (*Database, error) {
+	return MakeRecordDB(TagRecords(key, records), row_length, p)
+}
+
+// Same as RecoverRecords, for a DB built with MakeTaggedRecordDB: checks the
+// tag of every record against the DB owner's public key, and returns
+// ErrTampered if one does not decode or does not match.
+func (c *Client) RecoverTaggedRecords(pending *PendingQuery, answer Msg, key ed25519.PublicKey) ([][]byte, error) {
+	if pending == nil {
+		return nil, fmt.Errorf("%w: no pending query", ErrBadState)
+	}
+
+	bits := recordBitsPerElem(c.params.P)
+	var records [][]byte
+	for j, i := range pending.indices {
+		elems, err := c.pi.RecoverElemsChecked(i, uint64(j), c.Hint(), pending.query.Data[j], answer,
+			c.shared, pending.secrets[j], c.params, c.info)
+		if err != nil {
+			return nil, err
+		}
+		tagged, err := elemsToRecord(elems, bits)
+		if errors.Is(err, ErrReconstructFailed) {
+			return nil, fmt.Errorf("%w: record %d: %v", ErrTampered, i, err)
+		} else if err != nil {
+			return nil, err
+		}
+		rec, err := checkRecordTag(key, i, tagged)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
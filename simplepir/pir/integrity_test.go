@@ -0,0 +1,158 @@
+package pir
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+// Returns a copy of answer with the top bit of one entry flipped, as an
+// adversarial server might.
+func flipAnswerEntry(answer Msg, k int, i, j, logq uint64) Msg {
+	var out Msg
+	for _, m := range answer.Data {
+		out.Data = append(out.Data, m.RowsDeepCopy(0, m.Rows))
+	}
+	m := out.Data[k]
+	m.Set(m.Get(i, j)^(1<<(logq-1)), i, j)
+	return out
+}
+
+// Sets up a record PIR server over the tagged records; if m is non-zero, the
+// DB is narrowed to m columns, which keeps DoublePIR's setup (over the
+// COMP_RATIO·n columns its PickParams asks for) from dominating the test.
+func setupTaggedRecordPIR(t *testing.T, pi CheckedPIR, records [][]byte, key ed25519.PrivateKey,
+	max_len, m uint64) (*Server, *Client) {
+	p, row_length, err := PickRecordParams(pi, uint64(len(records)), max_len+RecordTagBytes, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != 0 {
+		p.M = m
+	}
+	DB, err := MakeTaggedRecordDB(records, row_length, &p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// However the server flips an entry of the answer, the client either gets
+// the right record or ErrTampered.
+func runTamperedRecordPIR(t *testing.T, pi CheckedPIR, N, max_len, m, i, flips uint64) {
+	records := randomRecords(N, max_len)
+	pub, priv := GenerateRecordKey()
+	server, client := setupTaggedRecordPIR(t, pi, records, priv, max_len, m)
+
+	pending, query, err := client.Query(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.RecoverTaggedRecords(pending, answer, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[0], records[i]) {
+		t.Fatalf("record %d differs", i)
+	}
+
+	// Flip some entries of the answer, spread evenly over all of its parts.
+	total := uint64(0)
+	for _, part := range answer.Data {
+		total += part.Rows * part.Cols
+	}
+	tampered := 0
+	step := (total + flips - 1) / flips
+	for e := uint64(0); e < total; e += step {
+		k, pos := 0, e
+		for pos >= answer.Data[k].Rows*answer.Data[k].Cols {
+			pos -= answer.Data[k].Rows * answer.Data[k].Cols
+			k++
+		}
+		r, c := pos/answer.Data[k].Cols, pos%answer.Data[k].Cols
+		bad := flipAnswerEntry(answer, k, r, c, client.Params().Logq)
+		got, err := client.RecoverTaggedRecords(pending, bad, pub)
+		if errors.Is(err, ErrTampered) {
+			tampered++
+		} else if err != nil {
+			t.Fatalf("entry (%d, %d) of answer part %d: %v", r, c, k, err)
+		} else if !bytes.Equal(got[0], records[i]) {
+			t.Fatalf("entry (%d, %d) of answer part %d: got a wrong record", r, c, k)
+		}
+	}
+	if tampered == 0 {
+		t.Fatal("no flipped entry was detected")
+	}
+
+	other, _ := GenerateRecordKey()
+	if _, err := client.RecoverTaggedRecords(pending, answer, other); !errors.Is(err, ErrTampered) {
+		t.Fatalf("wrong key: got %v", err)
+	}
+}
+
+func TestSimplePirTamperedRecords(t *testing.T) {
+	runTamperedRecordPIR(t, &SimplePIR{}, 1<<8, 40, 0, 77, 64)
+}
+
+func TestDoublePirTamperedRecords(t *testing.T) {
+	runTamperedRecordPIR(t, &DoublePIR{}, 1<<4, 8, 1<<4, 5, 16)
+}
+
+// A server that serves correctly tagged records at the wrong indices is
+// caught too.
+func TestSwappedTaggedRecords(t *testing.T) {
+	pi := &SimplePIR{}
+	records := randomRecords(1<<8, 40)
+	pub, priv := GenerateRecordKey()
+	tagged := TagRecords(priv, records)
+	tagged[3], tagged[4] = tagged[4], tagged[3]
+
+	p, row_length, err := PickRecordParams(pi, uint64(len(records)), 40+RecordTagBytes, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, err := MakeRecordDB(tagged, row_length, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, query, err := client.Query(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RecoverTaggedRecords(pending, answer, pub); !errors.Is(err, ErrTampered) {
+		t.Fatalf("swapped records: got %v", err)
+	}
+	got, err := client.RecoverRecords(pending, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[0]) != len(records[4])+RecordTagBytes {
+		t.Fatalf("untagged recovery: got %d bytes", len(got[0]))
+	}
+}
@@ -0,0 +1,222 @@
+package pir
+
+import (
+	"fmt"
+	mrand "math/rand"
+)
+
+// Keyword PIR: (key, value) pairs are laid out in a cuckoo hash table with
+// one sub-table per hash function. Sub-table j lives in the DB rows that the
+// j-th query of a batch is answered from, so a client looks up a key with a
+// single batch of NumKeywordHashes queries, one per candidate slot, and the
+// server never sees the key.
+//
+// Each slot holds a 64-bit DB entry: the low Value_bits bits store the value,
+// and the remaining bits store a tag derived from the key. Empty slots are 0,
+// and tags are never 0.
+
+const NumKeywordHashes = 3
+
+const maxCuckooKicks = 1000
+const maxCuckooRetries = 16
+
+// Public description of a keyword table, which clients need to find the
+// candidate slots of a key.
+type KeywordLayout struct {
+	Seed       uint64 // seed of the hash functions
+	Value_bits uint64 // number of bits per slot used for the value
+}
+
+// Returns how many DB entries to ask for (e.g., in PickParams) to hold n keys.
+func KeywordDBSize(n uint64) uint64 {
+	return n + n*3/10 + 3*NumKeywordHashes
+}
+
+// splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (k *KeywordLayout) hash(key uint64, j uint64) uint64 {
+	return mix64(key ^ mix64(k.Seed+j))
+}
+
+func (k *KeywordLayout) tag(key uint64) uint64 {
+	t := k.hash(key, NumKeywordHashes) >> k.Value_bits
+	if t == 0 {
+		t = 1
+	}
+	return t
+}
+
+// Returns the DB indices of the candidate slots of key, one per sub-table, in
+// the order in which they must be queried (i.e., as a single batch).
+func (k *KeywordLayout) Candidates(key uint64, p Params, info DBinfo) ([]uint64, error) {
+	if info.Ne == 0 {
+		return nil, ErrNotSetup
+	}
+	var slots []uint64
+	for j := uint64(0); j < NumKeywordHashes; j++ {
+		first, sz := batchSubTable(j, NumKeywordHashes, p, info)
+		if sz == 0 {
+			return nil, fmt.Errorf("%w: DB too small for %d sub-tables", ErrBadParams, NumKeywordHashes)
+		}
+		slots = append(slots, first+k.hash(key, j)%sz)
+	}
+	return slots, nil
+}
+
+// Given the contents of key's candidate slots, returns the value stored for key.
+func (k *KeywordLayout) Match(key uint64, slots []uint64) (uint64, error) {
+	tag := k.tag(key)
+	for _, slot := range slots {
+		if slot>>k.Value_bits == tag {
+			return slot & (1<<k.Value_bits - 1), nil
+		}
+	}
+	return 0, ErrKeyNotFound
+}
+
+// Picks params with scheme pi for a keyword DB of num_keys keys.
+func PickKeywordParams(pi CheckedPIR, num_keys, n, logq uint64) (Params, error) {
+	return pickBatchedParams(pi, KeywordDBSize(num_keys), 64, NumKeywordHashes, n, logq)
+}
+
+// Picks params with scheme pi for a DB of N entries of row_length bits, split
+// into num sub-tables that a batch of num queries fetches one entry each from
+// (see batchSubTable). Each sub-table needs rows of its own, so DBs that pi
+// lays out in fewer rows of entries than there are sub-tables (e.g., those of
+// DoublePIR, which are wide) are reshaped into num rows.
+func pickBatchedParams(pi CheckedPIR, N, row_length, num, n, logq uint64) (Params, error) {
+	p, err := pi.PickParamsChecked(N, row_length, n, logq)
+	if err != nil {
+		return Params{}, err
+	}
+
+	// Reshaping can change p, and with it the number of Z_p elems per entry.
+	for try := 0; try < maxRecordParamsTries; try++ {
+		_, ne, packing := Num_DB_entries(1, row_length, p.P)
+		info := DBinfo{Ne: ne, Packing: packing}
+		fits := true
+		for j := uint64(0); j < num; j++ {
+			if _, sz := batchSubTable(j, num, p, info); sz == 0 {
+				fits = false
+			}
+		}
+		if fits {
+			return p, nil
+		}
+		per_row := num
+		if packing > 0 {
+			per_row *= packing
+		}
+		p = pi.PickParamsGivenDimensions(num*ne, (N+per_row-1)/per_row, n, logq)
+	}
+	return Params{}, fmt.Errorf("%w: no stable params for %d entries in %d sub-tables", ErrNoParams, N, num)
+}
+
+// Builds a keyword PIR database that maps keys[i] to values[i], for params p
+// picked for at least KeywordDBSize(len(keys)) 64-bit entries (e.g., by
+// PickKeywordParams). If a key appears
+// more than once, its first value is kept. Values must fit in value_bits bits;
+// the other 64-value_bits bits (at least 32) hold the key's tag.
+func MakeKeywordDB(keys, values []uint64, value_bits uint64, p *Params) (*Database, KeywordLayout, error) {
+	if len(keys) != len(values) {
+		return nil, KeywordLayout{}, fmt.Errorf("%w: %d keys, %d values", ErrBadInput, len(keys), len(values))
+	}
+	if value_bits == 0 || value_bits > 32 {
+		return nil, KeywordLayout{}, fmt.Errorf("%w: %d value bits", ErrBadParams, value_bits)
+	}
+	for _, v := range values {
+		if v>>value_bits != 0 {
+			return nil, KeywordLayout{}, fmt.Errorf("%w: value %d does not fit in %d bits",
+				ErrBadInput, v, value_bits)
+		}
+	}
+
+	if p.P < 2 || p.L == 0 || p.M == 0 {
+		return nil, KeywordLayout{}, fmt.Errorf("%w: p=%d, l=%d, m=%d", ErrInvalidParams, p.P, p.L, p.M)
+	}
+
+	// Find how many 64-bit entries fit in the params.
+	_, ne, _ := Num_DB_entries(1, 64, p.P)
+	info := DBinfo{Ne: ne}
+	capacity := (p.L / ne) * p.M
+
+	prg := RandomBufPRG()
+	for try := 0; try < maxCuckooRetries; try++ {
+		layout := KeywordLayout{Seed: prg.Uint64(), Value_bits: value_bits}
+		slots, ok := layout.insertAll(keys, values, capacity, *p, info)
+		if ok {
+			DB, err := MakeDBChecked(capacity, 64, p, slots)
+			return DB, layout, err
+		}
+	}
+	return nil, KeywordLayout{}, fmt.Errorf("%w: could not place %d keys in %d slots",
+		ErrDBSizeMismatch, len(keys), capacity)
+}
+
+// Inserts all pairs with random-walk cuckoo hashing; fails if some key gets
+// kicked out too many times.
+func (k *KeywordLayout) insertAll(keys, values []uint64, capacity uint64, p Params, info DBinfo) ([]uint64, bool) {
+	slots := make([]uint64, capacity)
+	slot_keys := make([]uint64, capacity)
+	seen := make(map[uint64]bool, len(keys))
+	rnd := mrand.New(mrand.NewSource(int64(k.Seed)))
+
+	for i, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		cur_key, cur_slot := key, k.tag(key)<<k.Value_bits|values[i]
+		placed := false
+		for kick := 0; kick < maxCuckooKicks && !placed; kick++ {
+			candidates, err := k.Candidates(cur_key, p, info)
+			if err != nil {
+				return nil, false
+			}
+			for _, c := range candidates {
+				if slots[c] == 0 {
+					slots[c], slot_keys[c] = cur_slot, cur_key
+					placed = true
+					break
+				}
+			}
+			if !placed {
+				c := candidates[rnd.Intn(len(candidates))]
+				slots[c], cur_slot = cur_slot, slots[c]
+				slot_keys[c], cur_key = cur_key, slot_keys[c]
+			}
+		}
+		if !placed {
+			return nil, false
+		}
+	}
+	return slots, true
+}
+
+// Builds the batch of queries that looks up key privately.
+func (c *Client) QueryKeyword(layout KeywordLayout, key uint64) (*PendingQuery, MsgSlice, error) {
+	candidates, err := layout.Candidates(key, c.params, c.info)
+	if err != nil {
+		return nil, MsgSlice{}, err
+	}
+	return c.Query(candidates...)
+}
+
+// Decodes the answer to QueryKeyword, returning the value stored for key, or
+// ErrKeyNotFound if key is not in the table.
+func (c *Client) RecoverKeyword(layout KeywordLayout, key uint64, pending *PendingQuery, answer Msg) (uint64, error) {
+	slots, err := c.Recover(pending, answer)
+	if err != nil {
+		return 0, err
+	}
+	return layout.Match(key, slots)
+}
@@ -0,0 +1,107 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeywordPir(t *testing.T) {
+	runKeywordPIR(t, &SimplePIR{})
+}
+
+func TestKeywordDoublePir(t *testing.T) {
+	runKeywordPIR(t, &DoublePIR{})
+}
+
+func runKeywordPIR(t *testing.T, pi CheckedPIR) {
+	prg := RandomBufPRG()
+	n := uint64(5000)
+	var keys, values []uint64
+	for i := uint64(0); i < n; i++ {
+		keys = append(keys, prg.Uint64())
+		values = append(values, i)
+	}
+
+	p, err := PickKeywordParams(pi, n, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, layout, err := MakeKeywordDB(keys, values, 20, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every key sits in one of its candidate slots
+	for i, key := range keys {
+		candidates, err := layout.Candidates(key, p, DB.Info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var slots []uint64
+		for _, c := range candidates {
+			slots = append(slots, DB.GetElem(c))
+		}
+		if v, err := layout.Match(key, slots); err != nil || v != values[i] {
+			t.Fatalf("key %d: got %d, %v instead of %d", key, v, err, values[i])
+		}
+	}
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := layout.MarshalBinary()
+	var client_layout KeywordLayout
+	if err := client_layout.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []uint64{0, 17, n - 1} {
+		pending, query, err := client.QueryKeyword(client_layout, keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := server.Answer(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := client.RecoverKeyword(client_layout, keys[i], pending, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != values[i] {
+			t.Fatalf("key %d: got %d instead of %d", keys[i], v, values[i])
+		}
+	}
+
+	missing := prg.Uint64()
+	pending, query, err := client.QueryKeyword(client_layout, missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RecoverKeyword(client_layout, missing, pending, answer); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("missing key: %v", err)
+	}
+}
+
+func TestKeywordDBRejectsBadInput(t *testing.T) {
+	pi := SimplePIR{}
+	p := pi.PickParams(KeywordDBSize(10), 64, SEC_PARAM, LOGQ)
+	if _, _, err := MakeKeywordDB([]uint64{1, 2}, []uint64{1}, 20, &p); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("mismatched lengths: %v", err)
+	}
+	if _, _, err := MakeKeywordDB([]uint64{1}, []uint64{1 << 20}, 20, &p); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("oversized value: %v", err)
+	}
+	if _, _, err := MakeKeywordDB([]uint64{1}, []uint64{1}, 40, &p); !errors.Is(err, ErrBadParams) {
+		t.Fatalf("short tags: %v", err)
+	}
+}
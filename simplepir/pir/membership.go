@@ -0,0 +1,197 @@
+package pir
+
+import (
+	"fmt"
+)
+
+// Private membership: the keys of a set are encoded in an XOR filter (Graf
+// and Lemire, "Xor Filters: Faster and Smaller Than Bloom and Cuckoo
+// Filters", 2020), which is served as a PIR database. The filter is an array
+// of fingerprints split into one segment per hash function, and a key is in
+// the set if the fingerprints in its slot of each segment XOR to the key's
+// own fingerprint. As with keyword PIR (see keyword.go), segment j lives in
+// the DB rows that the j-th query of a batch is answered from, so a client
+// checks a key with a single batch of NumMembershipHashes queries, and the
+// server never sees the key.
+//
+// Keys outside the set are reported as members with probability
+// 2^-Fingerprint_bits. The filter takes about 1.3*Fingerprint_bits bits per
+// key, against 1.3*64 bits per key for a keyword table.
+
+const NumMembershipHashes = 3
+
+const maxFilterRetries = 16
+
+// Public description of a filter, which clients need to find the slots of a
+// key and to check its fingerprint.
+type MembershipLayout struct {
+	Seed             uint64 // seed of the hash functions
+	Fingerprint_bits uint64 // number of bits per slot
+}
+
+// Returns how many DB entries to ask for (e.g., in PickParams) to hold a
+// filter of n keys.
+func MembershipDBSize(n uint64) uint64 {
+	return n + n*3/10 + 32*NumMembershipHashes
+}
+
+// Picks params with scheme pi for a filter of num_keys keys, with
+// fingerprint_bits bits per slot.
+func PickMembershipParams(pi CheckedPIR, num_keys, fingerprint_bits, n, logq uint64) (Params, error) {
+	return pickBatchedParams(pi, MembershipDBSize(num_keys), fingerprint_bits, NumMembershipHashes, n, logq)
+}
+
+func (m *MembershipLayout) hash(key uint64, j uint64) uint64 {
+	return mix64(key ^ mix64(m.Seed+j))
+}
+
+func (m *MembershipLayout) fingerprint(key uint64) uint64 {
+	return m.hash(key, NumMembershipHashes) & (1<<m.Fingerprint_bits - 1)
+}
+
+// Returns the DB indices of the slots of key, one per segment, in the order in
+// which they must be queried (i.e., as a single batch).
+func (m *MembershipLayout) Candidates(key uint64, p Params, info DBinfo) ([]uint64, error) {
+	if info.Ne == 0 {
+		return nil, ErrNotSetup
+	}
+	var slots []uint64
+	for j := uint64(0); j < NumMembershipHashes; j++ {
+		first, sz := batchSubTable(j, NumMembershipHashes, p, info)
+		if sz == 0 {
+			return nil, fmt.Errorf("%w: DB too small for %d segments", ErrBadParams, NumMembershipHashes)
+		}
+		slots = append(slots, first+m.hash(key, j)%sz)
+	}
+	return slots, nil
+}
+
+// Given the contents of key's slots, reports whether key is in the set.
+func (m *MembershipLayout) Contains(key uint64, slots []uint64) bool {
+	x := uint64(0)
+	for _, slot := range slots {
+		x ^= slot
+	}
+	return x == m.fingerprint(key)
+}
+
+// Builds a PIR database that holds an XOR filter of keys, for params p picked
+// for at least MembershipDBSize(len(keys)) entries of fingerprint_bits bits
+// (e.g., by PickMembershipParams). Keys may repeat.
+func MakeMembershipDB(keys []uint64, fingerprint_bits uint64, p *Params) (*Database, MembershipLayout, error) {
+	if fingerprint_bits == 0 || fingerprint_bits > 32 {
+		return nil, MembershipLayout{}, fmt.Errorf("%w: %d fingerprint bits", ErrBadParams, fingerprint_bits)
+	}
+	if p.P < 2 || p.L == 0 || p.M == 0 {
+		return nil, MembershipLayout{}, fmt.Errorf("%w: p=%d, l=%d, m=%d", ErrInvalidParams, p.P, p.L, p.M)
+	}
+
+	// Find how many entries fit in the params.
+	_, ne, packing := Num_DB_entries(1, fingerprint_bits, p.P)
+	info := DBinfo{Ne: ne, Packing: packing}
+	capacity := (p.L / ne) * p.M
+	if packing > 0 {
+		capacity *= packing
+	}
+
+	var unique []uint64
+	seen := make(map[uint64]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	prg := RandomBufPRG()
+	for try := 0; try < maxFilterRetries; try++ {
+		layout := MembershipLayout{Seed: prg.Uint64(), Fingerprint_bits: fingerprint_bits}
+		slots, ok := layout.build(unique, capacity, *p, info)
+		if ok {
+			DB, err := MakeDBChecked(capacity, fingerprint_bits, p, slots)
+			return DB, layout, err
+		}
+	}
+	return nil, MembershipLayout{}, fmt.Errorf("%w: could not build a filter of %d keys in %d slots",
+		ErrDBSizeMismatch, len(unique), capacity)
+}
+
+// Fills the filter of the (distinct) keys by peeling: a slot that only one
+// remaining key hashes to can be set last to make that key's fingerprint come
+// out right, so keys are peeled off in that order and then assigned in
+// reverse. Fails if some keys cannot be peeled off.
+func (m *MembershipLayout) build(keys []uint64, capacity uint64, p Params, info DBinfo) ([]uint64, bool) {
+	candidates := make([][]uint64, len(keys))
+	count := make([]uint32, capacity)
+	owners := make([]uint64, capacity) // XOR of the indices of the keys left in each slot
+	for i, key := range keys {
+		c, err := m.Candidates(key, p, info)
+		if err != nil {
+			return nil, false
+		}
+		for _, slot := range c {
+			count[slot]++
+			owners[slot] ^= uint64(i)
+		}
+		candidates[i] = c
+	}
+
+	var queue []uint64
+	for slot, c := range count {
+		if c == 1 {
+			queue = append(queue, uint64(slot))
+		}
+	}
+	type peeled struct{ key, slot uint64 }
+	var order []peeled
+	for len(queue) > 0 {
+		slot := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if count[slot] != 1 {
+			continue
+		}
+		i := owners[slot]
+		order = append(order, peeled{i, slot})
+		for _, c := range candidates[i] {
+			count[c]--
+			owners[c] ^= i
+			if count[c] == 1 {
+				queue = append(queue, c)
+			}
+		}
+	}
+	if len(order) != len(keys) {
+		return nil, false
+	}
+
+	slots := make([]uint64, capacity)
+	for k := len(order) - 1; k >= 0; k-- {
+		i, slot := order[k].key, order[k].slot
+		x := m.fingerprint(keys[i])
+		for _, c := range candidates[i] {
+			x ^= slots[c]
+		}
+		slots[slot] = x
+	}
+	return slots, true
+}
+
+// Builds the batch of queries that checks privately whether key is in the set.
+func (c *Client) QueryMembership(layout MembershipLayout, key uint64) (*PendingQuery, MsgSlice, error) {
+	candidates, err := layout.Candidates(key, c.params, c.info)
+	if err != nil {
+		return nil, MsgSlice{}, err
+	}
+	return c.Query(candidates...)
+}
+
+// Decodes the answer to QueryMembership, reporting whether key is in the set
+// (up to false positives; see MembershipLayout).
+func (c *Client) RecoverMembership(layout MembershipLayout, key uint64, pending *PendingQuery,
+	answer Msg) (bool, error) {
+	slots, err := c.Recover(pending, answer)
+	if err != nil {
+		return false, err
+	}
+	return layout.Contains(key, slots), nil
+}
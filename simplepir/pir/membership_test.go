@@ -0,0 +1,133 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMembershipPir(t *testing.T) {
+	// 4-bit fingerprints pack several per Z_p elem
+	for _, bits := range []uint64{4, 16} {
+		runMembershipPIR(t, &SimplePIR{}, bits)
+	}
+}
+
+func TestMembershipDoublePir(t *testing.T) {
+	runMembershipPIR(t, &DoublePIR{}, 16)
+}
+
+func runMembershipPIR(t *testing.T, pi CheckedPIR, bits uint64) {
+	prg := RandomBufPRG()
+	n := uint64(5000)
+	var keys []uint64
+	for i := uint64(0); i < n; i++ {
+		keys = append(keys, prg.Uint64())
+	}
+	keys = append(keys, keys[:10]...)
+
+	p, err := PickMembershipParams(pi, n, bits, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, layout, err := MakeMembershipDB(keys, bits, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slotsOf := func(key uint64) []uint64 {
+		candidates, err := layout.Candidates(key, p, DB.Info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var slots []uint64
+		for _, c := range candidates {
+			slots = append(slots, DB.GetElem(c))
+		}
+		return slots
+	}
+	for _, key := range keys {
+		if !layout.Contains(key, slotsOf(key)) {
+			t.Fatalf("%d bits: key %d is missing from the filter", bits, key)
+		}
+	}
+	// about 2^-bits of other keys are reported as members
+	false_positives := 0
+	for i := 0; i < 4096; i++ {
+		if layout.Contains(prg.Uint64(), slotsOf(prg.Uint64())) {
+			false_positives++
+		}
+	}
+	if limit := 3*4096>>bits + 2; false_positives > limit {
+		t.Fatalf("%d bits: %d false positives out of 4096", bits, false_positives)
+	}
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := layout.MarshalBinary()
+	var client_layout MembershipLayout
+	if err := client_layout.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(key uint64) bool {
+		pending, query, err := client.QueryMembership(client_layout, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := server.Answer(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := client.RecoverMembership(client_layout, key, pending, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+	for _, i := range []uint64{0, 17, n - 1} {
+		if !check(keys[i]) {
+			t.Fatalf("%d bits: key %d not found", bits, keys[i])
+		}
+	}
+	found := 0
+	for i := 0; i < 8; i++ {
+		if check(prg.Uint64()) {
+			found++
+		}
+	}
+	if bits >= 16 && found > 1 {
+		t.Fatalf("%d bits: %d of 8 random keys found", bits, found)
+	}
+}
+
+func TestMembershipDBRejectsBadInput(t *testing.T) {
+	pi := SimplePIR{}
+	p, err := PickMembershipParams(&pi, 10, 16, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bits := range []uint64{0, 40} {
+		if _, _, err := MakeMembershipDB([]uint64{1}, bits, &p); !errors.Is(err, ErrBadParams) {
+			t.Fatalf("%d fingerprint bits: %v", bits, err)
+		}
+	}
+	if _, _, err := MakeMembershipDB(make([]uint64, 100), 16, &p); err != nil {
+		t.Fatalf("repeated key: %v", err)
+	}
+
+	buf, _ := MembershipLayout{Seed: 1, Fingerprint_bits: 33}.MarshalBinary()
+	var layout MembershipLayout
+	if err := layout.UnmarshalBinary(buf); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("33 fingerprint bits: %v", err)
+	}
+	buf, _ = KeywordLayout{Seed: 1, Value_bits: 16}.MarshalBinary()
+	if err := layout.UnmarshalBinary(buf); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("keyword layout: %v", err)
+	}
+}
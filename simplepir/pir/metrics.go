@@ -0,0 +1,82 @@
+package pir
+
+import (
+	"runtime"
+	"time"
+)
+
+// Measurements of one phase of a PIR run.
+type PhaseMetrics struct {
+	Time       time.Duration
+	Bytes      uint64 // encoded size of the message the phase sends, if any
+	Allocs     uint64 // number of heap allocations
+	AllocBytes uint64 // bytes allocated on the heap
+}
+
+// Measurements of a full PIR run, as returned by the RunPIR drivers. The
+// message sizes are those of the encoded messages: Setup.Bytes is the offline
+// download, Query.Bytes the online upload and Answer.Bytes the online download.
+type Metrics struct {
+	Setup       PhaseMetrics
+	Query       PhaseMetrics
+	Answer      PhaseMetrics
+	Reconstruct PhaseMetrics
+
+	Rate float64 // MB/s of DB processed by Answer
+}
+
+// Returns the total communication, in KB.
+func (m Metrics) BW() float64 {
+	return kb(m.Setup.Bytes + m.Query.Bytes + m.Answer.Bytes)
+}
+
+// Returns the metrics keyed by name, with times in ms and sizes in KB, e.g.
+// for logging to CSV.
+func (m Metrics) Map() map[string]float64 {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return map[string]float64{
+		"setup_time":           ms(m.Setup.Time),
+		"query_time":           ms(m.Query.Time),
+		"answer_time":          ms(m.Answer.Time),
+		"reconstruct_time":     ms(m.Reconstruct.Time),
+		"offline_download":     kb(m.Setup.Bytes),
+		"online_upload":        kb(m.Query.Bytes),
+		"online_download":      kb(m.Answer.Bytes),
+		"rate":                 m.Rate,
+		"setup_alloc_kb":       kb(m.Setup.AllocBytes),
+		"query_alloc_kb":       kb(m.Query.AllocBytes),
+		"answer_alloc_kb":      kb(m.Answer.AllocBytes),
+		"reconstruct_alloc_kb": kb(m.Reconstruct.AllocBytes),
+	}
+}
+
+func kb(bytes uint64) float64 {
+	return float64(bytes) / 1024.0
+}
+
+// Measures the time and heap allocations of a phase, from startPhase to stop.
+type phaseTimer struct {
+	start time.Time
+	mem   runtime.MemStats
+}
+
+func startPhase() *phaseTimer {
+	t := new(phaseTimer)
+	runtime.ReadMemStats(&t.mem)
+	t.start = time.Now()
+	return t
+}
+
+// Prints the elapsed time, like printTime, and returns the phase's metrics.
+func (t *phaseTimer) stop() PhaseMetrics {
+	elapsed := printTime(t.start)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return PhaseMetrics{
+		Time:       elapsed,
+		Allocs:     mem.Mallocs - t.mem.Mallocs,
+		AllocBytes: mem.TotalAlloc - t.mem.TotalAlloc,
+	}
+}
@@ -0,0 +1,246 @@
+package pir
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSwitchModulus(t *testing.T) {
+	for _, c := range []struct{ x, logq, bits, want uint64 }{
+		{0, 32, 16, 0},
+		{1<<15 - 1, 32, 16, 0},
+		{1 << 15, 32, 16, 1},
+		{5 << 16, 32, 16, 5},
+		{1<<32 - 1, 32, 16, 0}, // rounds up to q, i.e. 0
+		{1<<64 - 1, 64, 20, 0},
+		{12345, 32, 32, 12345},
+	} {
+		if got := switchModulus(c.x, c.logq, c.bits); got != c.want {
+			t.Fatalf("x=%d from %d to %d bits: got %d instead of %d", c.x, c.logq, c.bits, got, c.want)
+		}
+	}
+}
+
+func TestSimplePirCompressedHint(t *testing.T) {
+	N, d := uint64(1<<18), uint64(8)
+	pi := SimplePIR{}
+	full := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	p, err := pi.PickParamsCompressedHint(N, d, SEC_PARAM, LOGQ, LOGQ/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HintBits() != LOGQ/2 || p.P > full.P || p.Sigma != full.Sigma {
+		t.Fatalf("got %v, uncompressed %v", p, full)
+	}
+	if p.L*p.HintBits() >= full.L*full.Logq {
+		t.Fatalf("%d-bit hint of %d rows is not smaller than %d-bit hint of %d rows",
+			p.HintBits(), p.L, full.Logq, full.L)
+	}
+
+	DB := MakeRandomDB(N, d, &p)
+	m, err := RunPIRChecked(&pi, DB, p, []uint64{N / 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := p.L * p.N * p.HintBits() / 8; m.Setup.Bytes < want || m.Setup.Bytes > want+64 {
+		t.Fatalf("sent %d bytes of hint, want about %d", m.Setup.Bytes, want)
+	}
+
+	if _, err := pi.PickParamsCompressedHint(N, d, SEC_PARAM, LOGQ, LOGQ+1); !errors.Is(err, ErrBadParams) {
+		t.Fatalf("hint bits past logq: got %v", err)
+	}
+	double := Params{N: SEC_PARAM, Logq: LOGQ, HintLogq: LOGQ / 2}
+	if err := double.PickParamsChecked(true, 1<<10); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("DoublePIR: got %v", err)
+	}
+}
+
+func TestSimplePirCompressedAnswer(t *testing.T) {
+	N, d := uint64(1<<18), uint64(8)
+	pi := SimplePIR{}
+	full := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	p, err := pi.PickParamsCompressed(N, d, SEC_PARAM, LOGQ, 0, LOGQ/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AnswerBits() != LOGQ/2 || p.HintBits() != LOGQ || p.P > full.P || 2*p.P < full.P {
+		t.Fatalf("got %v, uncompressed %v", p, full)
+	}
+
+	DB := MakeRandomDB(N, d, &p)
+	m, err := RunPIRChecked(&pi, DB, p, []uint64{N / 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := p.L * p.AnswerBits() / 8; m.Answer.Bytes < want || m.Answer.Bytes > want+64 {
+		t.Fatalf("sent %d bytes of answer, want about %d", m.Answer.Bytes, want)
+	}
+
+	// Both compressed, through a server and client.
+	p, err = pi.PickParamsCompressed(N, d, SEC_PARAM, LOGQ, LOGQ*5/8, LOGQ/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB = MakeRandomDB(N, d, &p)
+	server, err := NewServer(&pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := server.Params().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got_p Params
+	if err := got_p.UnmarshalBinary(buf); err != nil || got_p != p {
+		t.Fatalf("params: got %v, %v", got_p, err)
+	}
+	client, err := NewClient(&pi, got_p, server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint64{0, N / 2, N - 1} {
+		pending, query, err := client.Query(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := server.Answer(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range answer.Data[0].Data {
+			if uint64(v)>>p.AnswerBits() != 0 {
+				t.Fatalf("answer elem %d has more than %d bits", v, p.AnswerBits())
+			}
+		}
+		res, err := client.Recover(pending, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res[0] != DB.GetElem(i) {
+			t.Fatalf("index %d: got %d instead of %d", i, res[0], DB.GetElem(i))
+		}
+	}
+
+	if _, err := pi.PickParamsCompressed(N, d, SEC_PARAM, LOGQ, 0, LOGQ+1); !errors.Is(err, ErrBadParams) {
+		t.Fatalf("answer bits past logq: got %v", err)
+	}
+}
+
+// Decodes every elem of a SimplePIR answer to the query for column col, as
+// Recover does for the rows of one entry, and returns the largest noise (as
+// a fraction of the Delta/2 that decoding tolerates) and how many elems
+// decoded wrongly. orig is the DB before Setup.
+func measureAnswerNoise(p Params, hint Msg, secret, query, answer *Matrix, orig *Database,
+	col uint64) (float64, uint64) {
+	scale := uint64(1) << (p.Logq - p.AnswerBits())
+	interm := MatrixMul(hint.Data[0], secret)
+	if bits := p.HintBits(); bits < p.Logq {
+		interm.MulConst(1 << (p.Logq - bits))
+	}
+	offset := uint64(0)
+	for j := uint64(0); j < p.M; j++ {
+		offset += p.P / 2 * query.Get(j, 0)
+	}
+	offset = negModQ(offset, p.Logq)
+
+	max_noise := 0.0
+	failures := uint64(0)
+	for j := uint64(0); j < p.L; j++ {
+		noised := modQ(answer.Get(j, 0)*scale-interm.Get(j, 0)+offset, p.Logq)
+		val := orig.Data.Get(j, col) // in [-p/2, p/2], mod q
+		if p.Round(noised) != modQ(val+p.P, p.Logq)%p.P {
+			failures++
+		}
+		// noise, as a signed logq-bit integer
+		shift := 64 - p.Logq
+		noise := int64((noised-val*p.Delta())<<shift) >> shift
+		max_noise = math.Max(max_noise, math.Abs(float64(noise))/float64(p.Delta()/2))
+	}
+	return max_noise, failures
+}
+
+// Benchmark SimplePIR's download and decoding failures with the hint and
+// the answer rounded to fewer bits per elem.
+func BenchmarkSimplePirModSwitch(b *testing.B) {
+	flog, err := os.Create("simple-modswitch.log")
+	if err != nil {
+		panic("Error creating log file")
+	}
+	defer flog.Close()
+
+	N := uint64(1 << 20)
+	d := uint64(64)
+
+	log_N, _ := strconv.Atoi(os.Getenv("LOG_N"))
+	D, _ := strconv.Atoi(os.Getenv("D"))
+	if log_N != 0 {
+		N = uint64(1 << log_N)
+	}
+	if D != 0 {
+		d = uint64(D)
+	}
+	trials := 20
+
+	writer := csv.NewWriter(flog)
+	defer writer.Flush()
+	writer.Write([]string{"N", "d", "hint_bits", "answer_bits", "p", "l", "m", "offline_comm",
+		"online_comm", "elems_decoded", "failures", "max_noise"})
+
+	pi := SimplePIR{}
+	for _, bits := range [][2]uint64{{LOGQ, LOGQ}, {LOGQ * 3 / 4, LOGQ}, {LOGQ * 5 / 8, LOGQ},
+		{LOGQ / 2, LOGQ}, {LOGQ, LOGQ / 2}, {LOGQ, LOGQ * 3 / 8}, {LOGQ * 5 / 8, LOGQ / 2}} {
+		p, err := pi.PickParamsCompressed(N, d, SEC_PARAM, LOGQ, bits[0], bits[1])
+		if err != nil {
+			panic(err)
+		}
+		DB := MakeRandomDB(N, d, &p)
+		orig := DB.Copy()
+		shared := pi.Init(DB.Info, p, RandomBufPRG())
+		server, hint := pi.Setup(DB, shared, p)
+		hint = CompressHint(hint, p)
+		_, offline_bytes, err := sendMsg(hint, p.HintBits())
+		if err != nil {
+			panic(err)
+		}
+
+		prg := RandomBufPRG()
+		max_noise := 0.0
+		failures := uint64(0)
+		online_bytes := uint64(0)
+		for trial := 0; trial < trials; trial++ {
+			i := prg.Uint64() % N
+			client, query := pi.Query(i, shared, p, DB.Info, prg)
+			answer := pi.Answer(DB, MsgSlice{Data: []Msg{query}}, server, shared, p)
+			_, online_bytes, err = sendMsg(answer, p.AnswerBits())
+			if err != nil {
+				panic(err)
+			}
+			noise, fails := measureAnswerNoise(p, hint, client.Data[0], query.Data[0], answer.Data[0],
+				orig, elemIndex(i, DB.Info)%p.M)
+			max_noise = math.Max(max_noise, noise)
+			failures += fails
+		}
+		pi.Reset(DB, p)
+
+		fmt.Printf("Hint of %d bits, answer of %d bits per elem: p=%d, %d-by-%d DB, offline %f KB, online download %f KB, %d wrong elems out of %d, max noise %f of Delta/2\n",
+			p.HintBits(), p.AnswerBits(), p.P, p.L, p.M, kb(offline_bytes), kb(online_bytes), failures,
+			uint64(trials)*p.L, max_noise)
+		writer.Write([]string{strconv.FormatUint(N, 10),
+			strconv.FormatUint(d, 10),
+			strconv.FormatUint(p.HintBits(), 10),
+			strconv.FormatUint(p.AnswerBits(), 10),
+			strconv.FormatUint(p.P, 10),
+			strconv.FormatUint(p.L, 10),
+			strconv.FormatUint(p.M, 10),
+			strconv.FormatFloat(kb(offline_bytes), 'f', 4, 64),
+			strconv.FormatFloat(kb(online_bytes), 'f', 4, 64),
+			strconv.FormatUint(uint64(trials)*p.L, 10),
+			strconv.FormatUint(failures, 10),
+			strconv.FormatFloat(max_noise, 'f', 4, 64)})
+	}
+}
@@ -0,0 +1,196 @@
+package pir
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+// The generator reproduces the embedded table from its sigma: exactly for
+// SimplePIR, and within 1% (never above) for DoublePIR, whose table moduli
+// came from a slightly tighter bound.
+func TestRegenerateParamsTable(t *testing.T) {
+	table := paramsTable()
+	if len(table) == 0 {
+		t.Fatal("empty params table")
+	}
+
+	var rows []LWEParams
+	for _, want := range table {
+		got, err := lweParamsFor(want.N, want.Logq, want.M, want.Sigma, DefaultLogFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.PSimple != want.PSimple {
+			t.Fatalf("m=%d: SimplePIR p=%d instead of %d", want.M, got.PSimple, want.PSimple)
+		}
+		if got.PDouble > want.PDouble || 100*got.PDouble < 99*want.PDouble {
+			t.Fatalf("m=%d: DoublePIR p=%d instead of %d", want.M, got.PDouble, want.PDouble)
+		}
+		got.PDouble = want.PDouble
+		rows = append(rows, got)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteParamsTable(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != lwe_params {
+		t.Fatalf("regenerated table differs:\n%s", buf.String())
+	}
+}
+
+func TestGenerateParamsForPresets(t *testing.T) {
+	for _, preset := range Presets {
+		row, err := GenerateLWEParams(preset.N, preset.Logq, 1<<20, preset.Security, DefaultLogFailure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sec := EstimateSecurity(preset.N, preset.Logq, 1<<20, row.Sigma); sec < preset.Security {
+			t.Fatalf("preset %s: sigma=%f gives %.1f bits", preset.Name, row.Sigma, sec)
+		}
+		if row.Sigma > MinSigma {
+			if sec := EstimateSecurity(preset.N, preset.Logq, 1<<20, row.Sigma-0.1); sec >= preset.Security {
+				t.Fatalf("preset %s: sigma=%f is not the smallest", preset.Name, row.Sigma)
+			}
+		}
+		if got, err := LookupPreset(preset.Name); err != nil || got != preset {
+			t.Fatalf("preset %s: got %v, %v", preset.Name, got, err)
+		}
+	}
+	if _, err := LookupPreset("64"); !errors.Is(err, ErrBadParams) {
+		t.Fatalf("unknown preset: got %v", err)
+	}
+
+	// More error for the same n costs correctness.
+	small, _ := GenerateLWEParams(Preset128.N, Preset128.Logq, 1<<20, 100, DefaultLogFailure)
+	large, _ := GenerateLWEParams(Preset128.N, Preset128.Logq, 1<<20, 140, DefaultLogFailure)
+	if small.Sigma >= large.Sigma || small.PSimple <= large.PSimple {
+		t.Fatalf("100 bits: %v, 140 bits: %v", small, large)
+	}
+}
+
+// DBs too large for the table get generated params, with the table's sigma.
+func TestPickParamsBeyondTable(t *testing.T) {
+	p := Params{N: SEC_PARAM, Logq: LOGQ}
+	if err := p.PickParamsChecked(false, 1<<23); err != nil {
+		t.Fatal(err)
+	}
+	var last LWEParams
+	for _, r := range paramsTable() {
+		if r.N == p.N && r.Logq == p.Logq {
+			last = r
+		}
+	}
+	if last.N == 0 {
+		t.Skipf("no table rows for n=%d, logq=%d", p.N, p.Logq)
+	}
+	if p.Sigma != last.Sigma || p.P == 0 || p.P >= last.PSimple {
+		t.Fatalf("got sigma=%f, p=%d", p.Sigma, p.P)
+	}
+}
+
+// With fewer than n/4 samples, the attack still works for small sigma, so
+// PickSigma must grow sigma past MinSigma.
+func TestPickSigmaFewSamples(t *testing.T) {
+	n, logq := uint64(256), uint64(32)
+	m := n/4 - 1
+	if sec := EstimateSecurity(n, logq, m, MinSigma); math.IsInf(sec, 1) || sec >= 128 {
+		t.Fatalf("m=%d: %f bits of security at sigma=%f", m, sec, MinSigma)
+	}
+	sigma, err := PickSigma(n, logq, m, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sigma <= MinSigma || EstimateSecurity(n, logq, m, sigma) < 128 {
+		t.Fatalf("m=%d: got sigma=%f", m, sigma)
+	}
+}
+
+// The bound puts the table and generated params at about DefaultLogFailure,
+// and grows with p and with compression.
+func TestLogFailureBound(t *testing.T) {
+	for _, r := range paramsTable() {
+		simple := LogFailureBound(r.N, r.Logq, 0, 0, r.M, r.PSimple, r.Sigma, false)
+		double := LogFailureBound(r.N, r.Logq, 0, 0, r.M, r.PDouble, r.Sigma, true)
+		if simple > DefaultLogFailure || double > DoubleLogFailure || simple < DefaultLogFailure-2 {
+			t.Fatalf("%v: SimplePIR 2^%f, DoublePIR 2^%f", r, simple, double)
+		}
+	}
+
+	n, logq, m := uint64(SEC_PARAM), uint64(LOGQ), uint64(1<<20)
+	row, err := GenerateLWEParams(n, logq, m, DefaultSecurity, DefaultLogFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := LogFailureBound(n, logq, 0, 0, m, row.PSimple, row.Sigma, false)
+	past := LogFailureBound(n, logq, 0, 0, m, row.PSimple+1, row.Sigma, false)
+	if at > DefaultLogFailure || past <= DefaultLogFailure {
+		t.Fatalf("p=%d: 2^%f, p+1: 2^%f", row.PSimple, at, past)
+	}
+	if hint := LogFailureBound(n, logq, logq/2, 0, m, row.PSimple, row.Sigma, false); hint <= at {
+		t.Fatalf("compressed hint: 2^%f, full: 2^%f", hint, at)
+	}
+	if ans := LogFailureBound(n, logq, 0, logq/4, m, row.PSimple, row.Sigma, false); ans != 0 {
+		t.Fatalf("answer rounded past Delta: 2^%f", ans)
+	}
+	if LogFailureBound(n, logq, 0, 0, m, row.PDouble, row.Sigma, true) <=
+		LogFailureBound(n, logq, 0, 0, m, row.PDouble, row.Sigma, false) {
+		t.Fatal("DoublePIR bound is not above the per-elem bound")
+	}
+
+	pi := SimplePIR{}
+	p := pi.PickParams(1<<16, 8, SEC_PARAM, LOGQ)
+	if got := p.LogFailure(false); got > DefaultLogFailure {
+		t.Fatalf("picked params fail with probability 2^%f", got)
+	}
+}
+
+// PickParams refuses params past the threshold, or only warns.
+func TestPickParamsFailureThreshold(t *testing.T) {
+	for _, doublepir := range []bool{false, true} {
+		p := Params{N: SEC_PARAM, Logq: LOGQ, L: 1 << 13, M: 1 << 13}
+		if err := p.PickParamsChecked(doublepir, p.L, p.M); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.LogFailure(doublepir); got > (FailureOptions{}).maxLogFailure(doublepir) {
+			t.Fatalf("DoublePIR=%v: default params fail with probability 2^%f", doublepir, got)
+		}
+
+		opts := FailureOptions{MaxLogFailure: p.LogFailure(doublepir) - 1}
+		if err := p.PickParamsWithOptions(doublepir, opts, p.L, p.M); !errors.Is(err, ErrUnreliableParams) {
+			t.Fatalf("DoublePIR=%v: got %v", doublepir, err)
+		}
+
+		opts.Warn = true
+		if err := p.PickParamsWithOptions(doublepir, opts, p.L, p.M); err != nil {
+			t.Fatalf("DoublePIR=%v, warning only: got %v", doublepir, err)
+		}
+	}
+
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsWithOptions(1<<16, 8, SEC_PARAM, LOGQ, FailureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FailureOptions{MaxLogFailure: p.LogFailure(false) - 1}
+	if _, err := pi.PickParamsWithOptions(1<<16, 8, SEC_PARAM, LOGQ, opts); !errors.Is(err, ErrUnreliableParams) {
+		t.Fatalf("SimplePIR past the threshold: got %v", err)
+	}
+}
+
+// A preset outside the table works end to end.
+func TestSimplePirPreset192(t *testing.T) {
+	N, d := uint64(1<<16), uint64(8)
+	pi := SimplePIR{}
+	p, err := pi.PickParamsChecked(N, d, Preset192.N, Preset192.Logq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Sigma < MinSigma || EstimateSecurity(p.N, p.Logq, p.M, p.Sigma) < Preset192.Security {
+		t.Fatalf("got sigma=%f", p.Sigma)
+	}
+	DB := MakeRandomDB(N, d, &p)
+	RunPIR(&pi, DB, p, []uint64{N - 1})
+}
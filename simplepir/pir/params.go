@@ -1,9 +1,11 @@
 package pir
 
 import "math"
+import "math/bits"
 import "strings"
 import "strconv"
 import "fmt"
+import "errors"
 import _ "embed"
 
 //go:embed params.csv
@@ -18,12 +20,35 @@ type Params struct {
 
 	Logq uint64 // (logarithm of) ciphertext modulus
 	P    uint64 // plaintext modulus
+
+	HintLogq   uint64 // bits per hint elem sent to clients, if fewer than Logq (see CompressHint)
+	AnswerLogq uint64 // bits per answer elem sent to clients, if fewer than Logq
 }
 
 func (p *Params) Delta() uint64 {
+	if p.Logq >= 64 {
+		delta, _ := bits.Div64(1, 0, p.P)
+		return delta
+	}
 	return (1 << p.Logq) / (p.P)
 }
 
+// Returns x mod 2^logq.
+func modQ(x, logq uint64) uint64 {
+	if logq >= 64 {
+		return x
+	}
+	return x % (1 << logq)
+}
+
+// Returns 2^logq - (x mod 2^logq), which cancels out x mod 2^logq.
+func negModQ(x, logq uint64) uint64 {
+	if logq >= 64 {
+		return -x
+	}
+	return (1 << logq) - modQ(x, logq)
+}
+
 func (p *Params) delta() uint64 {
 	return uint64(math.Ceil(float64(p.Logq) / math.Log2(float64(p.P))))
 }
@@ -35,52 +60,210 @@ func (p *Params) Round(x uint64) uint64 {
 }
 
 func (p *Params) PickParams(doublepir bool, samples ...uint64) {
-	if p.N == 0 || p.Logq == 0 {
-		panic("Need to specify n and q!")
-	}
-
-	num_samples := uint64(0)
-	for _, ns := range samples {
-		if ns > num_samples {
-			num_samples = ns
+	if err := p.PickParamsChecked(doublepir, samples...); err != nil {
+		if errors.Is(err, ErrNoParams) {
+			fmt.Printf("Searched for %d, %d-by-%d, %d,\n", p.N, p.L, p.M, p.Logq)
 		}
+		panic(err)
 	}
+}
+
+// The embedded params table, parsed once since PickParams tries many dims.
+var lwe_rows = parseParamsTable()
 
+func paramsTable() []LWEParams {
+	return lwe_rows
+}
+
+func parseParamsTable() []LWEParams {
+	var rows []LWEParams
 	lines := strings.Split(lwe_params, "\n")
 	for _, l := range lines[1:] {
 		line := strings.Split(l, ",")
+		if len(line) < 7 {
+			continue
+		}
 		logn, _ := strconv.ParseUint(line[0], 10, 64)
 		logm, _ := strconv.ParseUint(line[1], 10, 64)
 		logq, _ := strconv.ParseUint(line[2], 10, 64)
+		sigma, _ := strconv.ParseFloat(line[3], 64)
+		p_simple, _ := strconv.ParseUint(line[5], 10, 64)
+		p_double, _ := strconv.ParseUint(line[6], 10, 64)
+		rows = append(rows, LWEParams{N: 1 << logn, M: 1 << logm, Logq: logq, Sigma: sigma,
+			PSimple: p_simple, PDouble: p_double})
+	}
+	return rows
+}
+
+// Same as PickParams, but returns ErrNeedDims, ErrInvalidParams, ErrNoParams
+// or ErrUnreliableParams instead of panicking. Params the embedded table does not cover are
+// generated, with GenerateLWEParams.
+func (p *Params) PickParamsChecked(doublepir bool, samples ...uint64) error {
+	return p.PickParamsWithOptions(doublepir, FailureOptions{}, samples...)
+}
+
+// Same as PickParamsChecked, but checks the decoding failure probability as
+// opts says.
+func (p *Params) PickParamsWithOptions(doublepir bool, opts FailureOptions, samples ...uint64) error {
+	if p.N == 0 || p.Logq == 0 {
+		return ErrNeedDims
+	}
+
+	num_samples := uint64(0)
+	for _, ns := range samples {
+		if ns > num_samples {
+			num_samples = ns
+		}
+	}
 
-		if (p.N == uint64(1<<logn)) &&
-			(num_samples <= uint64(1<<logm)) &&
-			(p.Logq == uint64(logq)) {
-			sigma, _ := strconv.ParseFloat(line[3], 64)
-			p.Sigma = sigma
+	for _, row := range paramsTable() {
+		if (p.N == row.N) &&
+			(num_samples <= row.M) &&
+			(p.Logq == row.Logq) {
+			p.Sigma = row.Sigma
 
 			if doublepir {
-				mod, _ := strconv.ParseUint(line[6], 10, 64)
-				p.P = mod
+				p.P = row.PDouble
 			} else {
-				mod, _ := strconv.ParseUint(line[5], 10, 64)
-				p.P = mod
+				p.P = row.PSimple
 			}
 
-			if sigma == 0.0 || p.P == 0 {
-				panic("Params invalid!")
+			if p.Sigma == 0.0 || p.P == 0 {
+				return ErrInvalidParams
 			}
 
-			return
+			if err := p.fitCompression(doublepir, num_samples); err != nil {
+				return err
+			}
+			return p.checkFailure(doublepir, num_samples, opts)
 		}
 	}
 
-	fmt.Printf("Searched for %d, %d-by-%d, %d,\n", p.N, p.L, p.M, p.Logq)
-	panic("No suitable params known!")
+	if p.Logq > 64 || num_samples == 0 {
+		return fmt.Errorf("%w: n=%d, %d samples, logq=%d", ErrNoParams, p.N, num_samples, p.Logq)
+	}
+	sigma, err := generatedSigma(p.N, p.Logq, num_samples)
+	if err != nil {
+		return err
+	}
+	row, err := lweParamsFor(p.N, p.Logq, num_samples, sigma, DefaultLogFailure)
+	if err != nil {
+		return err
+	}
+	p.Sigma = sigma
+	if doublepir {
+		p.P = row.PDouble
+	} else {
+		p.P = row.PSimple
+	}
+	// Setup packs squishCompression Z_p elems in each Z_q elem (see
+	// checkSquishParams).
+	if p.P > 1<<squishBasis {
+		p.P = 1 << squishBasis
+	}
+	if err := p.fitCompression(doublepir, num_samples); err != nil {
+		return err
+	}
+	return p.checkFailure(doublepir, num_samples, opts)
+}
+
+// How PickParams treats params with which answers decode wrongly with
+// probability above 2^MaxLogFailure (see LogFailureBound): it refuses them,
+// or, if Warn is set, only prints a warning. A MaxLogFailure of 0 stands for
+// the default of the scheme, DefaultLogFailure, or DoubleLogFailure for
+// DoublePIR.
+type FailureOptions struct {
+	MaxLogFailure float64
+	Warn          bool
+}
+
+// Default failure threshold of DoublePIR. LogFailureBound takes a union bound
+// over the elems of both of its rounds, which puts the table's DoublePIR
+// moduli at up to 2^-39.2 rather than 2^DefaultLogFailure.
+const DoubleLogFailure = DefaultLogFailure + 1
+
+func (o FailureOptions) maxLogFailure(doublepir bool) float64 {
+	switch {
+	case o.MaxLogFailure != 0:
+		return o.MaxLogFailure
+	case doublepir:
+		return DoubleLogFailure
+	default:
+		return DefaultLogFailure
+	}
+}
+
+func (p *Params) checkFailure(doublepir bool, num_samples uint64, opts FailureOptions) error {
+	log_failure := LogFailureBound(p.N, p.Logq, p.HintBits(), p.AnswerBits(), num_samples, p.P,
+		p.Sigma, doublepir)
+	max_log := opts.maxLogFailure(doublepir)
+	if log_failure <= max_log {
+		return nil
+	}
+	if opts.Warn {
+		fmt.Printf("Warning: p=%d, sigma=%f decode wrongly with probability up to 2^%.1f\n",
+			p.P, p.Sigma, log_failure)
+		return nil
+	}
+	return fmt.Errorf("%w: p=%d, sigma=%f decode wrongly with probability up to 2^%.1f, above 2^%.1f",
+		ErrUnreliableParams, p.P, p.Sigma, log_failure, max_log)
+}
+
+// Log of an upper bound on the probability that an answer decodes wrongly
+// with these params (see LogFailureBound).
+func (p *Params) LogFailure(doublepir bool) float64 {
+	m := p.M
+	if doublepir && p.L > m {
+		m = p.L
+	}
+	return LogFailureBound(p.N, p.Logq, p.HintBits(), p.AnswerBits(), m, p.P, p.Sigma, doublepir)
+}
+
+// Lowers p so that answers still decode when clients get the hint rounded
+// to HintLogq bits per elem, or the answer rounded to AnswerLogq bits.
+func (p *Params) fitCompression(doublepir bool, num_samples uint64) error {
+	if p.HintBits() == p.Logq && p.AnswerBits() == p.Logq {
+		return nil
+	}
+	if doublepir {
+		return fmt.Errorf("%w: DoublePIR cannot compress its hint or answer", ErrNotSupported)
+	}
+	comp_p := CompressedPlaintextModulus(p.N, p.Logq, p.HintBits(), p.AnswerBits(), num_samples,
+		p.Sigma, DefaultLogFailure)
+	if comp_p == 0 {
+		return fmt.Errorf("%w: no p decodes with a %d-bit hint and %d-bit answer for n=%d, logq=%d",
+			ErrNoParams, p.HintBits(), p.AnswerBits(), p.N, p.Logq)
+	}
+	if comp_p < p.P {
+		p.P = comp_p
+	}
+	return nil
+}
+
+// Number of bits per elem of the hint that clients download.
+func (p *Params) HintBits() uint64 {
+	if p.HintLogq == 0 || p.HintLogq >= p.Logq {
+		return p.Logq
+	}
+	return p.HintLogq
+}
+
+// Number of bits per elem of the answers that clients download.
+func (p *Params) AnswerBits() uint64 {
+	if p.AnswerLogq == 0 || p.AnswerLogq >= p.Logq {
+		return p.Logq
+	}
+	return p.AnswerLogq
 }
 
 func (p *Params) PrintParams() {
 	fmt.Printf("Working with: n=%d; db size=2^%d (l=%d, m=%d); logq=%d; p=%d; sigma=%f\n",
 		p.N, int(math.Log2(float64(p.L))+math.Log2(float64(p.M))), p.L, p.M, p.Logq,
 		p.P, p.Sigma)
+	if p.HintBits() < p.Logq {
+		fmt.Printf("\thint rounded to %d bits per elem\n", p.HintLogq)
+	}
+	if p.AnswerBits() < p.Logq {
+		fmt.Printf("\tanswers rounded to %d bits per elem\n", p.AnswerLogq)
+	}
 }
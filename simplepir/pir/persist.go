@@ -0,0 +1,403 @@
+package pir
+
+// #include "pir.h"
+import "C"
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// A server snapshot holds everything that Setup produces, so that a server can
+// restart without preprocessing the DB again: the scheme name, the params, the
+// DB info, the seed of the shared state, the hint, the server state and the
+// squished DB. It is laid out as
+//
+//	header | name | params | info | seed | hint | state | DB | SHA-256 of all the above
+//
+// where every field but the DB matrix and the checksum is a length-prefixed
+// encoding produced by the Marshal* methods. The shared state is not stored;
+// it is re-derived from the seed on load.
+
+// Number of DB elems packed at a time when writing or reading a snapshot; a
+// multiple of 8, so that every chunk but the last packs into whole bytes.
+const snapshotChunkElems = 1 << 16
+
+// Writes a snapshot of the server to w. The DB is packed a chunk at a time,
+// so writing takes little memory beyond the server itself.
+func (s *Server) WriteTo(w io.Writer) (int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	h := sha256.New()
+	hw := io.MultiWriter(bw, h)
+
+	p := s.db.params
+	buf := putHeader(nil, wireServerSnapshot)
+	buf = appendBlob(buf, []byte(s.db.pi.Name()))
+
+	fields := []func() ([]byte, error){
+		p.MarshalBinary,
+		s.db.db.Info.MarshalBinary,
+		s.seed.MarshalBinary,
+		func() ([]byte, error) { return s.db.hint.Marshal(p.Logq) },
+		func() ([]byte, error) { return s.db.state.Marshal(p.Logq) },
+	}
+	for _, field := range fields {
+		b, err := field()
+		if err != nil {
+			return cw.n, err
+		}
+		buf = appendBlob(buf, b)
+	}
+
+	data := s.db.db.Data
+	buf = appendUvarint(buf, data.Rows)
+	buf = appendUvarint(buf, data.Cols)
+	if _, err := hw.Write(buf); err != nil {
+		return cw.n, err
+	}
+
+	sz := data.Rows * data.Cols
+	if uint64(len(data.Data)) < sz {
+		return cw.n, fmt.Errorf("%w: %d-by-%d matrix holds only %d elems", ErrBadEncoding,
+			data.Rows, data.Cols, len(data.Data))
+	}
+	for start := uint64(0); start < sz; start += snapshotChunkElems {
+		end := start + snapshotChunkElems
+		if end > sz {
+			end = sz
+		}
+		var err error
+		if buf, err = packElems(buf[:0], data.Data[start:end], p.Logq); err != nil {
+			return cw.n, err
+		}
+		if _, err := hw.Write(buf); err != nil {
+			return cw.n, err
+		}
+	}
+
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return cw.n, err
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Reads a snapshot from r, hashing every byte it reads.
+type snapshotReader struct {
+	r   *bufio.Reader
+	h   hash.Hash
+	n   int64 // bytes read so far
+	err error
+}
+
+func (s *snapshotReader) fail(err error) {
+	if s.err == nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: truncated snapshot", ErrBadEncoding)
+		}
+		s.err = err
+	}
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.h.Write([]byte{b})
+		s.n++
+	}
+	return b, err
+}
+
+func (s *snapshotReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(s)
+	s.fail(err)
+	return v
+}
+
+// Reads n bytes; io.ReadAll grows the buffer as the bytes come in, so a
+// corrupted length cannot make it allocate more than the snapshot holds.
+func (s *snapshotReader) bytes(n uint64) []byte {
+	if s.err != nil {
+		return nil
+	}
+	b, err := io.ReadAll(io.LimitReader(s.r, int64(n)))
+	if err == nil && uint64(len(b)) != n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+	s.h.Write(b)
+	s.n += int64(len(b))
+	return b
+}
+
+// Reads a uvarint-length-prefixed byte string.
+func (s *snapshotReader) blob() []byte {
+	return s.bytes(s.uvarint())
+}
+
+// Reads a snapshot written by WriteTo, and rebuilds the server for scheme pi
+// without running Setup. Fails with ErrBadEncoding if the snapshot is
+// corrupted, was written for another scheme, or does not match its params.
+// The DB is read a chunk at a time, and the checksum verified as it is read.
+func ReadServer(pi CheckedPIR, r io.Reader) (*Server, error) {
+	return readServer(pi, r, -1)
+}
+
+// Same as ReadServer, for a snapshot of size bytes, or of unknown size if
+// size is negative.
+func readServer(pi CheckedPIR, r io.Reader, size int64) (*Server, error) {
+	s := &snapshotReader{r: bufio.NewReader(r), h: sha256.New()}
+
+	if hdr := s.bytes(2); s.err == nil {
+		d := decoder{buf: hdr}
+		d.header(wireServerSnapshot)
+		s.fail(d.err)
+	}
+	if name := s.blob(); s.err == nil && string(name) != pi.Name() {
+		s.fail(fmt.Errorf("%w: snapshot of %s, want %s", ErrBadEncoding, name, pi.Name()))
+	}
+
+	var p Params
+	var info DBinfo
+	var seed CompressedState
+	var hint Msg
+	var state State
+	fields := []func([]byte) error{
+		p.UnmarshalBinary,
+		info.UnmarshalBinary,
+		seed.UnmarshalBinary,
+		func(b []byte) error { return hint.Unmarshal(b, p.Logq) },
+		func(b []byte) error { return state.Unmarshal(b, p.Logq) },
+	}
+	for _, field := range fields {
+		if b := s.blob(); s.err == nil {
+			s.fail(field(b))
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := checkSquishParams(p); err != nil {
+		return nil, err
+	}
+	if info.Squishing == 0 {
+		return nil, fmt.Errorf("%w: database is not preprocessed", ErrBadEncoding)
+	}
+
+	// The params come from the snapshot too, and its checksum is only checked
+	// at the end, so check the dims against the bytes left before allocating
+	// the DB. If the size is unknown, the DB grows as its chunks are read.
+	rows, cols := s.uvarint(), s.uvarint()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if want := (p.M + info.Squishing - 1) / info.Squishing; rows != p.L || cols != want {
+		return nil, fmt.Errorf("%w: %d-by-%d database, want %d-by-%d", ErrBadEncoding, rows, cols, p.L, want)
+	}
+	if cols != 0 && rows > math.MaxUint64/8/p.Logq/cols {
+		return nil, fmt.Errorf("%w: %d-by-%d database", ErrBadEncoding, rows, cols)
+	}
+	sz := rows * cols
+	packed_bytes := (sz*p.Logq + 7) / 8
+	if size >= 0 && packed_bytes+sha256.Size > uint64(size-s.n) {
+		return nil, fmt.Errorf("%w: %d-by-%d database does not fit in %d bytes", ErrBadEncoding,
+			rows, cols, size-s.n)
+	}
+
+	data_matrix := MatrixNewNoAlloc(rows, cols)
+	if size >= 0 {
+		data_matrix.Data = make([]C.Elem, 0, sz)
+	}
+	for start := uint64(0); start < sz && s.err == nil; start += snapshotChunkElems {
+		end := start + snapshotChunkElems
+		if end > sz {
+			end = sz
+		}
+		packed := s.bytes(((end-start)*p.Logq + 7) / 8)
+		if s.err != nil {
+			break
+		}
+		data_matrix.Data = growElems(data_matrix.Data, end, sz)
+		if !unpackElems(data_matrix.Data[start:end], packed, p.Logq) {
+			s.fail(fmt.Errorf("%w: nonzero padding bits", ErrBadEncoding))
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(s.r, sum); err != nil {
+		return nil, fmt.Errorf("%w: truncated snapshot", ErrBadEncoding)
+	}
+	if !bytes.Equal(sum, s.h.Sum(nil)) {
+		return nil, fmt.Errorf("%w: snapshot checksum mismatch", ErrBadEncoding)
+	}
+	if _, err := s.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing bytes after snapshot", ErrBadEncoding)
+	}
+
+	if err := checkSnapshot(pi, p, info, data_matrix, hint, state); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		db: &SharedDB{
+			pi:      pi,
+			params:  p,
+			db:      &Database{Info: info, Data: data_matrix},
+			state:   state,
+			shared:  pi.DecompressState(info, p, seed),
+			hint:    hint,
+			workers: runtime.GOMAXPROCS(0),
+		},
+		seed: seed,
+	}, nil
+}
+
+// Checks that the decoded fields of a snapshot describe the same squished DB,
+// and that the hint and server state have the dimensions pi's Setup gives
+// them, as the answers and C kernels take them for granted.
+func checkSnapshot(pi CheckedPIR, p Params, info DBinfo, data *Matrix, hint Msg, state State) error {
+	if err := checkSquishParams(p); err != nil {
+		return err
+	}
+	if info.P != p.P || info.Logq != p.Logq {
+		return fmt.Errorf("%w: database built for p=%d, logq=%d, params have p=%d, logq=%d",
+			ErrBadEncoding, info.P, info.Logq, p.P, p.Logq)
+	}
+	if info.Squishing == 0 || info.Cols != p.M || info.Ne == 0 {
+		return fmt.Errorf("%w: database is not preprocessed", ErrBadEncoding)
+	}
+	cols := (p.M + info.Squishing - 1) / info.Squishing
+	if !hasDims(data, p.L, cols) {
+		return fmt.Errorf("%w: %d-by-%d database, want %d-by-%d", ErrBadEncoding,
+			data.Rows, data.Cols, p.L, cols)
+	}
+	return checkSetupDims(pi, p, info, hint, state)
+}
+
+func checkSetupDims(pi CheckedPIR, p Params, info DBinfo, hint Msg, state State) error {
+	var hints, states [][2]uint64
+	switch pi.(type) {
+	case *SimplePIR:
+		hints = [][2]uint64{{p.L, p.N}}
+	case *DoublePIR:
+		if info.X == 0 || p.L%info.X != 0 {
+			return fmt.Errorf("%w: %d rows do not split into %d parts", ErrBadEncoding, p.L, info.X)
+		}
+		h1_rows := p.N * p.delta() * info.X
+		a2_rows := (p.L/info.X + 2) / 3 * 3 // padded to a multiple of 3
+		hints = [][2]uint64{{h1_rows, p.N}}
+		states = [][2]uint64{
+			{h1_rows, (p.L/info.X + squishCompression - 1) / squishCompression},
+			{p.N, a2_rows},
+		}
+	case *TwoServerDPF:
+		// no hint
+	default:
+		if len(hint.Data) == 0 {
+			return fmt.Errorf("%w: missing hint", ErrBadEncoding)
+		}
+		return nil
+	}
+
+	if err := checkDims("hint", hint.Data, hints); err != nil {
+		return err
+	}
+	return checkDims("server state", state.Data, states)
+}
+
+func checkDims(what string, ms []*Matrix, dims [][2]uint64) error {
+	if len(ms) != len(dims) {
+		return fmt.Errorf("%w: %s has %d matrices, want %d", ErrBadEncoding, what, len(ms), len(dims))
+	}
+	for k, m := range ms {
+		if !hasDims(m, dims[k][0], dims[k][1]) {
+			return fmt.Errorf("%w: %s matrix %d is %d-by-%d, want %d-by-%d", ErrBadEncoding, what, k,
+				m.Rows, m.Cols, dims[k][0], dims[k][1])
+		}
+	}
+	return nil
+}
+
+// Writes a snapshot of the server to the file at path. The snapshot is first
+// written to a temporary file in the same directory, so a crash never leaves
+// a partial snapshot at path.
+func (s *Server) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := s.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Loads a server for scheme pi from a snapshot written by SaveFile.
+func LoadServerFile(pi CheckedPIR, path string) (*Server, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readServer(pi, f, stat.Size())
+}
+
+// Extends elems to n elems, doubling its capacity up to max as needed, so
+// that reading a DB only allocates as much as the input holds.
+func growElems(elems []C.Elem, n, max uint64) []C.Elem {
+	if n <= uint64(cap(elems)) {
+		return elems[:n]
+	}
+	c := 2 * uint64(cap(elems))
+	if c < n {
+		c = n
+	}
+	if c > max {
+		c = max
+	}
+	out := make([]C.Elem, n, c)
+	copy(out, elems)
+	return out
+}
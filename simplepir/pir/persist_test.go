@@ -0,0 +1,210 @@
+package pir
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A server reloaded from a snapshot answers queries like the original one.
+func testServerSnapshot(t *testing.T, pi CheckedPIR, N, d uint64, p Params) {
+	DB := MakeRandomDB(N, d, &p)
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "server.snap")
+	if err := server.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadServerFile(pi, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Params() != p || loaded.DBInfo() != server.DBInfo() || *loaded.Seed().Seed != *server.Seed().Seed {
+		t.Fatal("params, info or seed differ after reloading")
+	}
+
+	client, err := NewClient(pi, loaded.Params(), loaded.DBInfo(), loaded.Seed(), loaded.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint64{0, 1, N / 2, N - 1} {
+		pending, query, err := client.Query(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := loaded.Answer(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals, err := client.Recover(pending, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vals[0] != DB.GetElem(i) {
+			t.Fatalf("index %d: got %d instead of %d", i, vals[0], DB.GetElem(i))
+		}
+	}
+}
+
+func TestSimplePirServerSnapshot(t *testing.T) {
+	pi := &SimplePIR{}
+	testServerSnapshot(t, pi, 1<<16, 8, pi.PickParams(1<<16, 8, SEC_PARAM, LOGQ))
+}
+
+// The DB of this snapshot spans several chunks of snapshotChunkElems elems.
+func TestSimplePirServerSnapshotChunks(t *testing.T) {
+	pi := &SimplePIR{}
+	p := pi.PickParams(1<<20, 8, SEC_PARAM, LOGQ)
+	if p.L*p.M/squishCompression <= 2*snapshotChunkElems {
+		t.Fatalf("%d-by-%d DB fits in two chunks", p.L, p.M)
+	}
+	testServerSnapshot(t, pi, 1<<20, 8, p)
+}
+
+func TestDoublePirServerSnapshot(t *testing.T) {
+	pi := &DoublePIR{}
+	// PickParams' DoublePIR dims take GBs of memory with 64-bit elems.
+	testServerSnapshot(t, pi, 1<<12, 8, pi.PickParamsGivenDimensions(64, 1024, SEC_PARAM, LOGQ))
+}
+
+func TestServerSnapshotRejectsBadInput(t *testing.T) {
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsChecked(1<<12, 8, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, MakeRandomDB(1<<12, 8, &p), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := server.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snap := buf.Bytes()
+
+	if _, err := ReadServer(pi, bytes.NewReader(snap)); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte(nil), snap...)
+	corrupted[len(corrupted)/2] ^= 1
+	if _, err := ReadServer(pi, bytes.NewReader(corrupted)); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("corrupted snapshot: got %v", err)
+	}
+	if _, err := ReadServer(pi, bytes.NewReader(snap[:len(snap)-1])); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("truncated snapshot: got %v", err)
+	}
+	if _, err := ReadServer(&DoublePIR{}, bytes.NewReader(snap)); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("snapshot of another scheme: got %v", err)
+	}
+
+	// A well-formed snapshot whose hint has the wrong shape for the scheme.
+	server.db.hint = MakeMsg(server.db.hint.Data[0].RowsDeepCopy(0, p.L-1))
+	buf.Reset()
+	if _, err := server.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadServer(pi, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("snapshot with a %d-row hint: got %v", p.L-1, err)
+	}
+}
+
+// A snapshot that claims a huge DB is rejected before the DB is allocated,
+// even though its checksum is only checked at the end.
+func TestServerSnapshotRejectsHugeDims(t *testing.T) {
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsChecked(1<<12, 8, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, MakeRandomDB(1<<12, 8, &p), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	huge := p
+	huge.L = 1 << 40
+	buf := putHeader(nil, wireServerSnapshot)
+	buf = appendBlob(buf, []byte(pi.Name()))
+	for _, field := range []func() ([]byte, error){
+		huge.MarshalBinary,
+		server.db.db.Info.MarshalBinary,
+		server.seed.MarshalBinary,
+		func() ([]byte, error) { return server.db.hint.Marshal(p.Logq) },
+		func() ([]byte, error) { return server.db.state.Marshal(p.Logq) },
+	} {
+		b, err := field()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = appendBlob(buf, b)
+	}
+	buf = appendUvarint(buf, huge.L)
+	buf = appendUvarint(buf, server.db.db.Data.Cols)
+	buf = append(buf, make([]byte, 1<<12)...)
+
+	if _, err := ReadServer(pi, bytes.NewReader(buf)); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("snapshot of unknown size: got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "huge.snap")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadServerFile(pi, path); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("snapshot file: got %v", err)
+	}
+}
+
+// Snapshots of DoublePIR servers are rejected if any matrix
+// of the hint or server state is missing or has the wrong shape.
+func TestServerSnapshotChecksSetupDims(t *testing.T) {
+	double := &DoublePIR{}
+	for _, c := range []struct {
+		pi CheckedPIR
+		p  Params
+	}{
+		{double, double.PickParamsGivenDimensions(64, 1024, SEC_PARAM, LOGQ)},
+	} {
+		pi, p := c.pi, c.p
+		server, err := NewServer(pi, MakeRandomDB(1<<12, 8, &p), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hint, state := server.db.hint, server.db.state
+		last := len(hint.Data) - 1
+		cut_hint := MakeMsg(hint.Data...)
+		cut_hint.Data[last] = hint.Data[last].RowsDeepCopy(0, 1)
+
+		bad := []struct {
+			hint  Msg
+			state State
+		}{
+			{MakeMsg(append(hint.Data, hint.Data[0])...), state},
+			{cut_hint, state},
+		}
+		if n := len(state.Data); n > 0 {
+			cut_state := MakeState(state.Data...)
+			cut_state.Data[n-1] = state.Data[n-1].RowsDeepCopy(0, 1)
+			bad = append(bad, struct {
+				hint  Msg
+				state State
+			}{hint, cut_state})
+		}
+		for k, b := range bad {
+			server.db.hint, server.db.state = b.hint, b.state
+			var buf bytes.Buffer
+			if _, err := server.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadServer(pi, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrBadEncoding) {
+				t.Fatalf("%s, bad snapshot %d: got %v", pi.Name(), k, err)
+			}
+		}
+	}
+}
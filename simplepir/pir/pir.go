@@ -19,14 +19,14 @@ type PIR interface {
 
 	GetBW(info DBinfo, p Params)
 
-	Init(info DBinfo, p Params) State
+	Init(info DBinfo, p Params, prg *BufPRGReader) State
 	InitCompressed(info DBinfo, p Params) (State, CompressedState)
 	DecompressState(info DBinfo, p Params, comp CompressedState) State
 
 	Setup(DB *Database, shared State, p Params) (State, Msg)
 	FakeSetup(DB *Database, p Params) (State, float64) // used for benchmarking online phase
 
-	Query(i uint64, shared State, p Params, info DBinfo) (State, Msg)
+	Query(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg)
 
 	Answer(DB *Database, query MsgSlice, server State, shared State, p Params) Msg
 
@@ -36,6 +36,71 @@ type PIR interface {
 	Reset(DB *Database, p Params) // reset DB to its correct state, if modified during execution
 }
 
+// Error-returning variant of the PIR interface. The checked methods validate
+// their inputs and return one of the Err* values instead of panicking, so
+// that a service can reject bad requests without crashing.
+type CheckedPIR interface {
+	PIR
+
+	PickParamsChecked(N, d, n, logq uint64) (Params, error)
+
+	SetupChecked(DB *Database, shared State, p Params) (State, Msg, error)
+
+	QueryChecked(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg, error)
+
+	AnswerChecked(DB *Database, query MsgSlice, server State, shared State, p Params) (Msg, error)
+
+	RecoverChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg, shared State,
+		client State, p Params, info DBinfo) (uint64, error)
+
+	// Same as RecoverChecked, but returns the Z_p elems (in [0, p)) that make
+	// up DB entry i instead of combining them, for entries wider than 64 bits.
+	RecoverElemsChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg, shared State,
+		client State, p Params, info DBinfo) ([]uint64, error)
+}
+
+// Returns the scheme whose Name is name: SimplePIR, DoublePIR, or the scheme
+// they are compared against, TwoServerDPF.
+func SchemeByName(name string) (CheckedPIR, error) {
+	for _, pi := range []CheckedPIR{&SimplePIR{}, &DoublePIR{}, &TwoServerDPF{}} {
+		if pi.Name() == name {
+			return pi, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, name)
+}
+
+// Implemented by schemes that can split the work of answering a batch of
+// queries across several goroutines. Like AnswerChecked, AnswerParallel
+// validates its inputs; it never modifies DB or the server state.
+type ParallelAnswerer interface {
+	AnswerParallel(DB *Database, query MsgSlice, server State, shared State, p Params,
+		workers int) (Msg, error)
+}
+
+// Simulates sending msg over the network: encodes it, decodes the received
+// bytes, and returns the decoded copy along with the number of bytes sent.
+func sendMsg(msg Msg, logq uint64) (Msg, uint64, error) {
+	buf, err := msg.Marshal(logq)
+	if err != nil {
+		return Msg{}, 0, err
+	}
+	var recv Msg
+	err = recv.Unmarshal(buf, logq)
+	return recv, uint64(len(buf)), err
+}
+
+// Same as sendMsg, for a batch of messages.
+func sendMsgSlice(msgs MsgSlice, logq uint64) (MsgSlice, uint64, error) {
+	buf, err := msgs.Marshal(logq)
+	if err != nil {
+		return MsgSlice{}, 0, err
+	}
+	var recv MsgSlice
+	err = recv.Unmarshal(buf, logq)
+	return recv, uint64(len(buf)), err
+}
+
 // Run PIR's online phase, with a random preprocessing (to skip the offline phase).
 // Gives accurate bandwidth and online time measurements.
 func RunFakePIR(pi PIR, DB *Database, p Params, i []uint64, 
@@ -48,7 +113,8 @@ func RunFakePIR(pi PIR, DB *Database, p Params, i []uint64,
 	if DB.Data.Rows/num_queries < DB.Info.Ne {
 		panic("Too many queries to handle!")
 	}
-	shared_state := pi.Init(DB.Info, p)
+	prg := RandomBufPRG()
+	shared_state := pi.Init(DB.Info, p, prg)
 
 	fmt.Println("Setup...")
 	server_state, bw := pi.FakeSetup(DB, p)
@@ -59,11 +125,15 @@ func RunFakePIR(pi PIR, DB *Database, p Params, i []uint64,
 	start := time.Now()
 	var query MsgSlice
 	for index, _ := range i {
-		_, q := pi.Query(i[index], shared_state, p, DB.Info)
+		_, q := pi.Query(i[index], shared_state, p, DB.Info, prg)
 		query.Data = append(query.Data, q)
 	}
 	printTime(start)
-	online_comm := float64(query.Size() * uint64(p.Logq) / (8.0 * 1024.0))
+	query, up_bytes, err := sendMsgSlice(query, p.Logq)
+	if err != nil {
+		panic(err)
+	}
+	online_comm := kb(up_bytes)
 	fmt.Printf("\t\tOnline upload: %f KB\n", online_comm)
 	bw += online_comm
 	runtime.GC()
@@ -79,7 +149,11 @@ func RunFakePIR(pi PIR, DB *Database, p Params, i []uint64,
 		pprof.StopCPUProfile()
 	}
 	rate := printRate(p, elapsed, len(i))
-	online_down := float64(answer.Size() * uint64(p.Logq) / (8.0 * 1024.0))
+	_, down_bytes, err := sendMsg(answer, p.AnswerBits())
+	if err != nil {
+		panic(err)
+	}
+	online_down := kb(down_bytes)
 	fmt.Printf("\t\tOnline download: %f KB\n", online_down)
 	bw += online_down
 	online_comm += online_down
@@ -97,6 +171,39 @@ func RunFakePIR(pi PIR, DB *Database, p Params, i []uint64,
 
 // Run full PIR scheme (offline + online phases).
 func RunPIR(pi PIR, DB *Database, p Params, i []uint64) (float64, float64) {
+	m := RunPIRWithMetrics(pi, DB, p, i)
+	return m.Rate, m.BW()
+}
+
+// Same as RunPIR, but returns the measurements of each phase.
+func RunPIRWithMetrics(pi PIR, DB *Database, p Params, i []uint64) Metrics {
+	shared_state := pi.Init(DB.Info, p, RandomBufPRG())
+	return runPIR(pi, DB, p, i, shared_state, shared_state)
+}
+
+// Run full PIR scheme (offline + online phases), where the transmission of the A matrix is compressed.
+func RunPIRCompressed(pi PIR, DB *Database, p Params, i []uint64) (float64, float64) {
+	m := RunPIRCompressedWithMetrics(pi, DB, p, i)
+	return m.Rate, m.BW()
+}
+
+// Same as RunPIRCompressed, but returns the measurements of each phase.
+func RunPIRCompressedWithMetrics(pi PIR, DB *Database, p Params, i []uint64) Metrics {
+	server_shared_state, comp_state := pi.InitCompressed(DB.Info, p)
+	enc_state, err := comp_state.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	var recv_state CompressedState
+	if err := recv_state.UnmarshalBinary(enc_state); err != nil {
+		panic(err)
+	}
+	client_shared_state := pi.DecompressState(DB.Info, p, recv_state)
+	return runPIR(pi, DB, p, i, server_shared_state, client_shared_state)
+}
+
+func runPIR(pi PIR, DB *Database, p Params, i []uint64, server_shared_state State,
+	client_shared_state State) Metrics {
 	fmt.Printf("Executing %s\n", pi.Name())
 	//fmt.Printf("Memory limit: %d\n", debug.SetMemoryLimit(math.MaxInt64))
 	debug.SetGCPercent(-1)
@@ -106,55 +213,67 @@ func RunPIR(pi PIR, DB *Database, p Params, i []uint64) (float64, float64) {
 		panic("Too many queries to handle!")
 	}
 	batch_sz := DB.Data.Rows / (DB.Info.Ne * num_queries) * DB.Data.Cols
-	bw := float64(0)
+	if DB.Info.Packing > 0 {
+		batch_sz *= DB.Info.Packing
+	}
+	var m Metrics
 
-	shared_state := pi.Init(DB.Info, p)
+	prg := RandomBufPRG()
 
 	fmt.Println("Setup...")
-	start := time.Now()
-	server_state, offline_download := pi.Setup(DB, shared_state, p)
-	printTime(start)
-	comm := float64(offline_download.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-	fmt.Printf("\t\tOffline download: %f KB\n", comm)
-	bw += comm
+	timer := startPhase()
+	server_state, offline_download := pi.Setup(DB, server_shared_state, p)
+	m.Setup = timer.stop()
+	offline_download, comm, err := sendMsg(CompressHint(offline_download, p), p.HintBits())
+	if err != nil {
+		panic(err)
+	}
+	m.Setup.Bytes = comm
+	fmt.Printf("\t\tOffline download: %f KB\n", kb(comm))
 	runtime.GC()
 
 	fmt.Println("Building query...")
-	start = time.Now()
+	timer = startPhase()
 	var client_state []State
 	var query MsgSlice
 	for index, _ := range i {
 		index_to_query := i[index] + uint64(index)*batch_sz
-		cs, q := pi.Query(index_to_query, shared_state, p, DB.Info)
+		cs, q := pi.Query(index_to_query, client_shared_state, p, DB.Info, prg)
 		client_state = append(client_state, cs)
 		query.Data = append(query.Data, q)
 	}
 	runtime.GC()
-	printTime(start)
-	comm = float64(query.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-	fmt.Printf("\t\tOnline upload: %f KB\n", comm)
-	bw += comm
+	m.Query = timer.stop()
+	query, comm, err = sendMsgSlice(query, p.Logq)
+	if err != nil {
+		panic(err)
+	}
+	m.Query.Bytes = comm
+	fmt.Printf("\t\tOnline upload: %f KB\n", kb(comm))
 	runtime.GC()
 
 	fmt.Println("Answering query...")
-	start = time.Now()
-	answer := pi.Answer(DB, query, server_state, shared_state, p)
-	elapsed := printTime(start)
-	rate := printRate(p, elapsed, len(i))
-	comm = float64(answer.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-	fmt.Printf("\t\tOnline download: %f KB\n", comm)
-	bw += comm
+	timer = startPhase()
+	answer := pi.Answer(DB, query, server_state, server_shared_state, p)
+	m.Answer = timer.stop()
+	m.Rate = printRate(p, m.Answer.Time, len(i))
+	answer, comm, err = sendMsg(answer, p.AnswerBits())
+	if err != nil {
+		panic(err)
+	}
+	m.Answer.Bytes = comm
+	fmt.Printf("\t\tOnline download: %f KB\n", kb(comm))
 	runtime.GC()
 
 	pi.Reset(DB, p)
 	fmt.Println("Reconstructing...")
-	start = time.Now()
+	timer = startPhase()
 
 	for index, _ := range i {
 		index_to_query := i[index] + uint64(index)*batch_sz
-		val := pi.Recover(index_to_query, uint64(index), offline_download, 
-		                  query.Data[index], answer, shared_state,
-			          client_state[index], p, DB.Info)
+		val := pi.Recover(index_to_query, uint64(index), offline_download,
+			query.Data[index], answer, client_shared_state,
+			client_state[index], p, DB.Info)
 
 		if DB.GetElem(index_to_query) != val {
 			fmt.Printf("Batch %d (querying index %d -- row should be >= %d): Got %d instead of %d\n",
@@ -163,85 +282,152 @@ func RunPIR(pi PIR, DB *Database, p Params, i []uint64) (float64, float64) {
 		}
 	}
 	fmt.Println("Success!")
-	printTime(start)
+	m.Reconstruct = timer.stop()
 
 	runtime.GC()
 	debug.SetGCPercent(100)
-	return rate, bw
+	return m
 }
 
-// Run full PIR scheme (offline + online phases), where the transmission of the A matrix is compressed.
-func RunPIRCompressed(pi PIR, DB *Database, p Params, i []uint64) (float64, float64) {
-        fmt.Printf("Executing %s\n", pi.Name())
-        //fmt.Printf("Memory limit: %d\n", debug.SetMemoryLimit(math.MaxInt64))
-        debug.SetGCPercent(-1)
-
-        num_queries := uint64(len(i))
-        if DB.Data.Rows/num_queries < DB.Info.Ne {
-                panic("Too many queries to handle!")
-        }
-        batch_sz := DB.Data.Rows / (DB.Info.Ne * num_queries) * DB.Data.Cols
-        bw := float64(0)
-
-        server_shared_state, comp_state := pi.InitCompressed(DB.Info, p)
-        client_shared_state := pi.DecompressState(DB.Info, p, comp_state)
-
-        fmt.Println("Setup...")
-        start := time.Now()
-        server_state, offline_download := pi.Setup(DB, server_shared_state, p)
-        printTime(start)
-        comm := float64(offline_download.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-        fmt.Printf("\t\tOffline download: %f KB\n", comm)
-        bw += comm
-        runtime.GC()
-
-        fmt.Println("Building query...")
-        start = time.Now()
-        var client_state []State
-        var query MsgSlice
-        for index, _ := range i {
-                index_to_query := i[index] + uint64(index)*batch_sz
-                cs, q := pi.Query(index_to_query, client_shared_state, p, DB.Info)
-                client_state = append(client_state, cs)
-                query.Data = append(query.Data, q)
-        }
-        runtime.GC()
-        printTime(start)
-        comm = float64(query.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-        fmt.Printf("\t\tOnline upload: %f KB\n", comm)
-        bw += comm
-        runtime.GC()
-
-        fmt.Println("Answering query...")
-        start = time.Now()
-        answer := pi.Answer(DB, query, server_state, server_shared_state, p)
-        elapsed := printTime(start)
-        rate := printRate(p, elapsed, len(i))
-        comm = float64(answer.Size() * uint64(p.Logq) / (8.0 * 1024.0))
-        fmt.Printf("\t\tOnline download: %f KB\n", comm)
-        bw += comm
-        runtime.GC()
-
-        pi.Reset(DB, p)
-        fmt.Println("Reconstructing...")
-        start = time.Now()
-
-        for index, _ := range i {
-                index_to_query := i[index] + uint64(index)*batch_sz
-                val := pi.Recover(index_to_query, uint64(index), offline_download,
-                                  query.Data[index], answer, client_shared_state,
-                                  client_state[index], p, DB.Info)
-
-                if DB.GetElem(index_to_query) != val {
-                        fmt.Printf("Batch %d (querying index %d -- row should be >= %d): Got %d instead of %d\n",
-                                index, index_to_query, DB.Data.Rows/4, val, DB.GetElem(index_to_query))
-                        panic("Reconstruct failed!")
-                }
-        }
-        fmt.Println("Success!")
-        printTime(start)
-
-        runtime.GC()
-        debug.SetGCPercent(100)
-        return rate, bw
+// Same as RunPIRWithMetrics, but uses the checked PIR methods and returns an
+// error (e.g., ErrTooManyQueries or ErrReconstructFailed) instead of panicking.
+func RunPIRChecked(pi CheckedPIR, DB *Database, p Params, i []uint64) (Metrics, error) {
+	return runPIRChecked(pi, DB, p, i, RandomBufPRG(), nil)
+}
+
+// Messages of one run of RunPIRDeterministic, as sent, and the values that
+// the client recovered from them.
+type Transcript struct {
+	Params Params
+	Hint   Msg
+	Query  MsgSlice
+	Answer Msg
+	Values []uint64
+}
+
+// Same as RunPIRChecked, but derives all randomness of the run (the matrix
+// A, the client's secrets and the LWE errors) from key, so that runs with the
+// same key, DB and params send the same messages. Also returns these.
+func RunPIRDeterministic(pi CheckedPIR, DB *Database, p Params, i []uint64,
+	key *PRGKey) (Metrics, Transcript, error) {
+	t := Transcript{Params: p}
+	m, err := runPIRChecked(pi, DB, p, i, NewBufPRG(NewPRG(key)), &t)
+	return m, t, err
+}
+
+// Runs RunPIRChecked with randomness from prg, and records the messages in
+// t, if not nil.
+func runPIRChecked(pi CheckedPIR, DB *Database, p Params, i []uint64, prg *BufPRGReader,
+	t *Transcript) (Metrics, error) {
+	fmt.Printf("Executing %s\n", pi.Name())
+	debug.SetGCPercent(-1)
+	defer debug.SetGCPercent(100)
+
+	num_queries := uint64(len(i))
+	if num_queries == 0 || DB.Data.Rows/num_queries < DB.Info.Ne {
+		return Metrics{}, fmt.Errorf("%w: %d queries, %d rows", ErrTooManyQueries, num_queries, DB.Data.Rows)
+	}
+	batch_sz := DB.Data.Rows / (DB.Info.Ne * num_queries) * DB.Data.Cols
+	if DB.Info.Packing > 0 {
+		batch_sz *= DB.Info.Packing
+	}
+	var m Metrics
+
+	shared_state := pi.Init(DB.Info, p, prg)
+
+	fmt.Println("Setup...")
+	timer := startPhase()
+	server_state, offline_download, err := pi.SetupChecked(DB, shared_state, p)
+	if err != nil {
+		return Metrics{}, err
+	}
+	squished := true
+	defer func() {
+		// leave the DB in its original state, even if the run fails
+		if squished {
+			pi.Reset(DB, p)
+		}
+	}()
+	m.Setup = timer.stop()
+	offline_download, comm, err := sendMsg(CompressHint(offline_download, p), p.HintBits())
+	if err != nil {
+		return Metrics{}, err
+	}
+	m.Setup.Bytes = comm
+	fmt.Printf("\t\tOffline download: %f KB\n", kb(comm))
+	runtime.GC()
+
+	fmt.Println("Building query...")
+	timer = startPhase()
+	var client_state []State
+	var query MsgSlice
+	for index, _ := range i {
+		index_to_query := i[index] + uint64(index)*batch_sz
+		cs, q, err := pi.QueryChecked(index_to_query, shared_state, p, DB.Info, prg)
+		if err != nil {
+			return Metrics{}, err
+		}
+		client_state = append(client_state, cs)
+		query.Data = append(query.Data, q)
+	}
+	runtime.GC()
+	m.Query = timer.stop()
+	query, comm, err = sendMsgSlice(query, p.Logq)
+	if err != nil {
+		return Metrics{}, err
+	}
+	m.Query.Bytes = comm
+	fmt.Printf("\t\tOnline upload: %f KB\n", kb(comm))
+	runtime.GC()
+
+	fmt.Println("Answering query...")
+	timer = startPhase()
+	answer, err := pi.AnswerChecked(DB, query, server_state, shared_state, p)
+	if err != nil {
+		return Metrics{}, err
+	}
+	m.Answer = timer.stop()
+	m.Rate = printRate(p, m.Answer.Time, len(i))
+	answer, comm, err = sendMsg(answer, p.AnswerBits())
+	if err != nil {
+		return Metrics{}, err
+	}
+	m.Answer.Bytes = comm
+	fmt.Printf("\t\tOnline download: %f KB\n", kb(comm))
+	runtime.GC()
+
+	pi.Reset(DB, p)
+	squished = false
+	fmt.Println("Reconstructing...")
+	timer = startPhase()
+
+	for index, _ := range i {
+		index_to_query := i[index] + uint64(index)*batch_sz
+		val, err := pi.RecoverChecked(index_to_query, uint64(index), offline_download,
+			query.Data[index], answer, shared_state,
+			client_state[index], p, DB.Info)
+		if err != nil {
+			return Metrics{}, err
+		}
+
+		expected, err := DB.GetElemChecked(index_to_query)
+		if err != nil {
+			return Metrics{}, err
+		}
+		if expected != val {
+			return Metrics{}, fmt.Errorf("%w: batch %d (querying index %d): got %d instead of %d",
+				ErrReconstructFailed, index, index_to_query, val, expected)
+		}
+		if t != nil {
+			t.Values = append(t.Values, val)
+		}
+	}
+	fmt.Println("Success!")
+	m.Reconstruct = timer.stop()
+
+	if t != nil {
+		t.Hint, t.Query, t.Answer = offline_download, query, answer
+	}
+	runtime.GC()
+	return m, nil
 }
@@ -2,21 +2,24 @@ package pir
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"os"
 	"strconv"
 	"testing"
 	"strings"
 )
 
-const LOGQ = uint64(32)
-const SEC_PARAM = uint64(1 << 10)
+// The params of the default preset, which depend on the elem width.
+var LOGQ = Preset128.Logq
+var SEC_PARAM = Preset128.N
 
 // Test that DB packing methods are correct, when each database entry is ~ 1 Z_p elem.
 func TestDBMediumEntries(t *testing.T) {
 	N := uint64(4)
-	d := uint64(9)
+	d := uint64(squishBasis - 1)
 	pir := SimplePIR{}
 	p := pir.PickParams(N, d, SEC_PARAM, LOGQ)
 
@@ -56,7 +59,7 @@ func TestDBSmallEntries(t *testing.T) {
 // Test that DB packing methods are correct, when each database entry requires multiple Z_p elems.
 func TestDBLargeEntries(t *testing.T) {
 	N := uint64(4)
-	d := uint64(12)
+	d := uint64(squishBasis + 2)
 	pir := SimplePIR{}
 	p := pir.PickParams(N, d, SEC_PARAM, LOGQ)
 
@@ -109,6 +112,135 @@ func TestDBInterleaving(t *testing.T) {
 	}
 }
 
+// Test the arithmetic mod q for both elem widths, whichever this build uses.
+func TestModQ(t *testing.T) {
+	for _, logq := range []uint64{32, 64} {
+		p := Params{Logq: logq, P: 991}
+		q := new(big.Int).Lsh(big.NewInt(1), uint(logq))
+		want := new(big.Int).Div(q, big.NewInt(991)).Uint64()
+		if p.Delta() != want {
+			t.Fatalf("logq=%d: Delta is %d instead of %d", logq, p.Delta(), want)
+		}
+		for _, x := range []uint64{1, 12345, 1<<32 + 7, math.MaxUint64} {
+			if modQ(x+negModQ(x, logq), logq) != 0 {
+				t.Fatalf("logq=%d: %d does not cancel %d", logq, negModQ(x, logq), x)
+			}
+			if got := p.Round(modQ(x%991*p.Delta()+x%1000, logq)); got != x%991 {
+				t.Fatalf("logq=%d: %d rounds to %d", logq, x, got)
+			}
+		}
+	}
+}
+
+// Test that the checked API reports bad input through the sentinel errors.
+func TestCheckedErrors(t *testing.T) {
+	N := uint64(1 << 16)
+	d := uint64(8)
+	pir := SimplePIR{}
+
+	if _, err := pir.PickParamsChecked(0, d, SEC_PARAM, LOGQ); !errors.Is(err, ErrEmptyDB) {
+		t.Fatalf("expected ErrEmptyDB, got %v", err)
+	}
+	bad := Params{N: SEC_PARAM, Logq: 32}
+	if err := bad.PickParamsChecked(false, 1<<62); !errors.Is(err, ErrNoParams) {
+		t.Fatalf("expected ErrNoParams, got %v", err)
+	}
+
+	p, err := pir.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeDBChecked(N, d, &p, []uint64{1, 2, 3}); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("expected ErrBadInput, got %v", err)
+	}
+	small := p
+	small.L = 1
+	if _, err := SetupDBChecked(N, d, &small); !errors.Is(err, ErrDBSizeMismatch) {
+		t.Fatalf("expected ErrDBSizeMismatch, got %v", err)
+	}
+
+	DB := MakeRandomDB(N, d, &p)
+	if _, err := DB.GetElemChecked(N); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+
+	shared := pir.Init(DB.Info, p, RandomBufPRG())
+	if _, err := pir.AnswerChecked(DB, MakeMsgSlice(MakeMsg(MatrixZeros(p.M, 1))), State{}, shared, p); !errors.Is(err, ErrNotSetup) {
+		t.Fatalf("expected ErrNotSetup, got %v", err)
+	}
+	if _, _, err := pir.SetupChecked(DB, MakeState(), p); !errors.Is(err, ErrBadState) {
+		t.Fatalf("expected ErrBadState, got %v", err)
+	}
+
+	_, _, err = pir.SetupChecked(DB, shared, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pir.SetupChecked(DB, shared, p); !errors.Is(err, ErrAlreadySetup) {
+		t.Fatalf("expected ErrAlreadySetup, got %v", err)
+	}
+	if _, _, err := pir.QueryChecked(dbCapacity(p, DB.Info), shared, p, DB.Info, RandomBufPRG()); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	if _, err := pir.AnswerChecked(DB, MakeMsgSlice(MakeMsg(MatrixZeros(p.M+1, 1))), State{}, shared, p); !errors.Is(err, ErrBadQuery) {
+		t.Fatalf("expected ErrBadQuery, got %v", err)
+	}
+	pir.Reset(DB, p)
+
+	many := make([]uint64, p.L+1)
+	if _, err := RunPIRChecked(&pir, DB, p, many); !errors.Is(err, ErrTooManyQueries) {
+		t.Fatalf("expected ErrTooManyQueries, got %v", err)
+	}
+}
+
+func TestSchemeByName(t *testing.T) {
+	for _, pi := range []CheckedPIR{&SimplePIR{}, &DoublePIR{}, &TwoServerDPF{}} {
+		got, err := SchemeByName(pi.Name())
+		if err != nil || got.Name() != pi.Name() {
+			t.Fatalf("%s: got %v, %v", pi.Name(), got, err)
+		}
+	}
+	if _, err := SchemeByName("TriplePIR"); !errors.Is(err, ErrUnknownScheme) {
+		t.Fatalf("expected ErrUnknownScheme, got %v", err)
+	}
+}
+
+// Test that the checked driver runs both schemes end to end.
+func TestRunPIRChecked(t *testing.T) {
+	N := uint64(1 << 16)
+	d := uint64(8)
+
+	simple := SimplePIR{}
+	p := simple.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	m, err := RunPIRChecked(&simple, DB, p, []uint64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, phase := range map[string]PhaseMetrics{"setup": m.Setup, "query": m.Query, "answer": m.Answer} {
+		if phase.Time <= 0 || phase.Bytes == 0 || phase.AllocBytes == 0 {
+			t.Fatalf("%s: missing metrics %+v", name, phase)
+		}
+	}
+	if m.Reconstruct.Time <= 0 || m.Rate <= 0 {
+		t.Fatalf("missing metrics %+v", m)
+	}
+	// the hint has p.L rows of n Z_q elems, bit-packed
+	if hint_bytes := p.L * p.N * p.Logq / 8; m.Setup.Bytes < hint_bytes || m.Setup.Bytes > hint_bytes+64 {
+		t.Fatalf("offline download of %d bytes, expected about %d", m.Setup.Bytes, hint_bytes)
+	}
+	if got := m.Map()["online_upload"]; got != float64(m.Query.Bytes)/1024 {
+		t.Fatalf("online_upload is %f KB, measured %d bytes", got, m.Query.Bytes)
+	}
+
+	double := DoublePIR{}
+	p = double.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB = MakeRandomDB(N, d, &p)
+	if _, err := RunPIRChecked(&double, DB, p, []uint64{1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Print the BW used by SimplePIR
 func TestSimplePirBW(t *testing.T) {
 	N := uint64(1 << 20)
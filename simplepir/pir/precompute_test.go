@@ -0,0 +1,160 @@
+package pir
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newPrecomputeClient(t *testing.T, pi CheckedPIR, p Params, DB *Database, key *PRGKey) (*Server, *Client) {
+	server, err := NewShardServer(pi, DB, p, MakeCompressedState(&goldenKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientSeeded(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func checkQuery(t *testing.T, server *Server, client *Client, i, want uint64) []byte {
+	pending, q, err := client.Query(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Recover(pending, answer)
+	if err != nil || res[0] != want {
+		t.Fatalf("index %d: got %v, %v instead of %d", i, res, err, want)
+	}
+	buf, err := q.Marshal(client.Params().Logq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// Precomputed queries recover the right entries, are used up one per query,
+// and match the queries that a client with the same key builds from scratch.
+func testPrecompute(t *testing.T, N, d, hint_logq uint64) {
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if hint_logq != 0 {
+		p, err = pi.PickParamsCompressedHint(N, d, SEC_PARAM, LOGQ, hint_logq)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB := MakeRandomDB(N, d, &p)
+	server, client := newPrecomputeClient(t, pi, p, DB, &goldenKey)
+	_, plain := newPrecomputeClient(t, pi, p, DB, &goldenKey)
+
+	if err := client.Precompute(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	indices := []uint64{0, N / 3, N - 1, 5}
+	for k, i := range indices {
+		left := 3 - k
+		if left < 0 {
+			left = 0
+		}
+		if n := client.Precomputed(); n != left {
+			t.Fatalf("%d precomputed queries left after %d queries", n, k)
+		}
+		// the last query is built from scratch, once the pool is empty
+		if a, b := checkQuery(t, server, client, i, DB.GetElem(i)), checkQuery(t, server, plain, i, DB.GetElem(i)); !bytes.Equal(a, b) {
+			t.Fatalf("index %d: precomputed query differs from a fresh one", i)
+		}
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	testPrecompute(t, 1<<12, 8, 0)
+}
+
+func TestPrecomputeCompressedHint(t *testing.T) {
+	testPrecompute(t, 1<<12, 8, LOGQ*5/8)
+}
+
+func TestPrecomputeRejects(t *testing.T) {
+	N, d := uint64(1<<10), uint64(8)
+	pi := &SimplePIR{}
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	_, client := newPrecomputeClient(t, pi, p, DB, &goldenKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Precompute(ctx, 1); !errors.Is(err, context.Canceled) || client.Precomputed() != 0 {
+		t.Fatalf("cancelled Precompute: %v, %d queries", err, client.Precomputed())
+	}
+
+	// an out-of-range index leaves the pool untouched
+	if err := client.Precompute(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Query(dbCapacity(p, DB.Info)); !errors.Is(err, ErrIndexOutOfRange) || client.Precomputed() != 1 {
+		t.Fatalf("bad index: %v, %d queries left", err, client.Precomputed())
+	}
+
+	// a square DB, since PickParams' DoublePIR dims take seconds to set up
+	dpi := &DoublePIR{}
+	dp := dpi.PickParamsGivenDimensions(1<<5, 1<<5, SEC_PARAM, LOGQ)
+	_, dclient := newPrecomputeClient(t, dpi, dp, MakeRandomDB(N, d, &dp), &goldenKey)
+	if err := dclient.Precompute(context.Background(), 1); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("DoublePIR Precompute: %v", err)
+	}
+}
+
+// Hint patches drop the pool, whose H·secret products use the old hint.
+func TestPrecomputeHintPatch(t *testing.T) {
+	N, d := uint64(1<<12), uint64(8)
+	pi := &SimplePIR{}
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	server, client := newPrecomputeClient(t, pi, p, DB, &goldenKey)
+
+	if err := client.Precompute(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	want := DB.GetElem(N/2) ^ 1
+	patch, err := server.Update(Update{N / 2, want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ApplyHintPatch(patch); err != nil {
+		t.Fatal(err)
+	}
+	if client.Precomputed() != 0 {
+		t.Fatalf("%d precomputed queries left after a hint patch", client.Precomputed())
+	}
+	checkQuery(t, server, client, N/2, want)
+}
+
+// Precompute can fill the pool while the client is querying.
+func TestPrecomputeConcurrent(t *testing.T) {
+	N, d := uint64(1<<12), uint64(8)
+	pi := &SimplePIR{}
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	DB := MakeRandomDB(N, d, &p)
+	server, client := newPrecomputeClient(t, pi, p, DB, RandomPRGKey())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := client.Precompute(context.Background(), 8); err != nil {
+			t.Error(err)
+		}
+	}()
+	for i := uint64(0); i < 8; i++ {
+		checkQuery(t, server, client, i*N/8, DB.GetElem(i*N/8))
+	}
+	wg.Wait()
+}
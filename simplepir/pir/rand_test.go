@@ -0,0 +1,52 @@
+package pir
+
+import (
+	"sync"
+	"testing"
+)
+
+// Decompressing different seeds at the same time must give each session the
+// same shared state as decompressing it alone.
+func TestConcurrentDecompress(t *testing.T) {
+	pi := SimplePIR{}
+	p := Params{N: 64, L: 32, M: 32, Logq: 32, P: 2}
+	info := DBinfo{}
+
+	var seeds []*PRGKey
+	var expected []State
+	for j := 0; j < 8; j++ {
+		seeds = append(seeds, RandomPRGKey())
+		expected = append(expected, pi.DecompressState(info, p, MakeCompressedState(seeds[j])))
+	}
+
+	got := make([]State, len(seeds))
+	var wg sync.WaitGroup
+	for j := range seeds {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			got[j] = pi.DecompressState(info, p, MakeCompressedState(seeds[j]))
+		}(j)
+	}
+	wg.Wait()
+
+	for j := range seeds {
+		if !sameMatrix(got[j].Data[0], expected[j].Data[0]) {
+			t.Fatalf("session %d: shared state differs when decompressed concurrently", j)
+		}
+	}
+}
+
+func TestSeededQueryIsReproducible(t *testing.T) {
+	pi := SimplePIR{}
+	p := Params{N: 64, L: 32, M: 32, Logq: 32, P: 2}
+	info := DBinfo{Ne: 1, Squishing: 3}
+	shared := pi.Init(info, p, RandomBufPRG())
+
+	key := RandomPRGKey()
+	s1, q1 := pi.Query(5, shared, p, info, NewBufPRG(NewPRG(key)))
+	s2, q2 := pi.Query(5, shared, p, info, NewBufPRG(NewPRG(key)))
+	if !sameMatrix(s1.Data[0], s2.Data[0]) || !sameMatrix(q1.Data[0], q2.Data[0]) {
+		t.Fatal("same seed gave different queries")
+	}
+}
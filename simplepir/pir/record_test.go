@@ -0,0 +1,201 @@
+package pir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func randomRecords(N, max_len uint64) [][]byte {
+	rnd := RandomBufPRG().MathRand()
+	var records [][]byte
+	for i := uint64(0); i < N; i++ {
+		rec := make([]byte, rnd.Intn(int(max_len)+1))
+		rnd.Read(rec)
+		records = append(records, rec)
+	}
+	records[0] = make([]byte, max_len)
+	records[1] = nil
+	return records
+}
+
+func runRecordPIR(t *testing.T, pi CheckedPIR, N, max_len uint64, indices []uint64) {
+	records := randomRecords(N, max_len)
+	p, row_length, err := PickRecordParams(pi, N, max_len, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, err := MakeRecordDB(records, row_length, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range records {
+		rec, err := DB.GetRecord(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rec, records[i]) {
+			t.Fatalf("record %d differs in the DB", i)
+		}
+	}
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, query, err := client.Query(indices...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.RecoverRecords(pending, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, i := range indices {
+		if !bytes.Equal(got[j], records[i]) {
+			t.Fatalf("record %d: got %d bytes, want %d bytes", i, len(got[j]), len(records[i]))
+		}
+	}
+}
+
+func TestSimplePirRecords(t *testing.T) {
+	runRecordPIR(t, &SimplePIR{}, 1<<10, 300, []uint64{517})
+}
+
+func TestDoublePirRecords(t *testing.T) {
+	runRecordPIR(t, &DoublePIR{}, 1<<8, 40, []uint64{0})
+}
+
+// Records of many Z_p elems are queried with fewer repetitions X than elems,
+// or the hint (of X rows per Z_q elem of A) would take GBs.
+func TestDoublePirRecordRepetitions(t *testing.T) {
+	pi := DoublePIR{}
+	p, row_length, err := PickRecordParams(&pi, 1<<8, 300, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, err := MakeRecordDB(randomRecords(1<<8, 300), row_length, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := DB.Info
+	if info.Ne < 2 || info.Ne%info.X != 0 || info.X == info.Ne {
+		t.Fatalf("%d repetitions for records of %d elems", info.X, info.Ne)
+	}
+	if hint_bytes := p.delta() * info.X * p.N * p.N * p.Logq / 8; hint_bytes > 1<<28 {
+		t.Fatalf("hint of %d bytes", hint_bytes)
+	}
+}
+
+func TestRecordEncoding(t *testing.T) {
+	for _, bits := range []uint64{1, 7, 9, 10, 16} {
+		rec := []byte("The quick brown fox jumps over the lazy dog")
+		ne := (8*uint64(len(rec)+recordHeaderBytes) + bits - 1) / bits
+		got, err := elemsToRecord(recordToElems(rec, ne, bits), bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, rec) {
+			t.Fatalf("bits=%d: got %q", bits, got)
+		}
+	}
+
+	elems := recordToElems([]byte{1, 2, 3}, 8, 8)
+	elems[0] = 200 // length byte past the end of the entry
+	if _, err := elemsToRecord(elems, 8); !errors.Is(err, ErrReconstructFailed) {
+		t.Fatalf("bad length: %v", err)
+	}
+	elems[0] = 1 << 8
+	if _, err := elemsToRecord(elems, 8); !errors.Is(err, ErrReconstructFailed) {
+		t.Fatalf("oversized elem: %v", err)
+	}
+}
+
+func TestMakeRecordDBRejectsLongRecord(t *testing.T) {
+	pi := SimplePIR{}
+	p, row_length, err := PickRecordParams(&pi, 16, 10, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([][]byte, 16)
+	records[3] = make([]byte, 100)
+	if _, err := MakeRecordDB(records, row_length, &p); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("long record: %v", err)
+	}
+}
+
+// Records in buckets of doubling widths round-trip through one record DB per
+// bucket, each padded to less than twice the length of its records.
+func TestRecordLayout(t *testing.T) {
+	pi := SimplePIR{}
+	records := randomRecords(1<<9, 200)
+	records[7] = make([]byte, 1000)
+	lengths := make([]uint64, len(records))
+	for i, rec := range records {
+		lengths[i] = uint64(len(rec))
+	}
+	layout, err := NewRecordLayout(lengths, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Buckets() != 5 || layout.Size(3) != 0 || layout.Size(4) != 1 {
+		t.Fatalf("got %d buckets", layout.Buckets())
+	}
+
+	buf, err := layout.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RecordLayout
+	if err := got.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := got.Split(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	DBs := make([]*Database, len(buckets))
+	for b, recs := range buckets {
+		if len(recs) == 0 {
+			continue
+		}
+		p, row_length, err := PickRecordParams(&pi, uint64(len(recs)), got.Width(uint64(b)), SEC_PARAM, LOGQ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DBs[b], err = MakeRecordDB(recs, row_length, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range []uint64{0, 1, 7, 300} {
+		b, slot, err := got.Locate(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(records[i])) > got.Width(b) || (b > 0 && uint64(len(records[i])) <= got.Width(b-1)) {
+			t.Fatalf("record %d of %d bytes in bucket %d", i, len(records[i]), b)
+		}
+		rec, err := DBs[b].GetRecord(slot)
+		if err != nil || !bytes.Equal(rec, records[i]) {
+			t.Fatalf("record %d: got %d bytes, %v", i, len(rec), err)
+		}
+	}
+
+	if _, _, err := got.Locate(got.Num()); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("out of range: %v", err)
+	}
+	records[2] = make([]byte, 1000)
+	if _, err := got.Split(records); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("record past its bucket: %v", err)
+	}
+}
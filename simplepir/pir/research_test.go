@@ -9,7 +9,6 @@ import (
     "io"
     "math"
     "os"
-    "regexp"
     "strconv"
     "strings"
     "testing"
@@ -585,100 +584,75 @@ func TestConvertCSVToBinary(t *testing.T) {
     }
 }
 
-// CAPTURING AND EXTRACTION OUTPUT FUNCTIONS USED BY LOGGER -----------------------------------------------------------------------------
-func CaptureOutput(f func()) string {
-    old := os.Stdout
-    r, w, _ := os.Pipe()
-    os.Stdout = w
-    
-    f() 
+// LOGGING RESULTS FUNCTION --------------------------------------------------------------------------------------------------
+func LogTestResults(testName string, params map[string]string, metrics map[string]float64) {
+    os.MkdirAll("../../results", 0755)
+    filename := fmt.Sprintf("../../results/%s_results.csv", testName)
     
-    w.Close()
-    os.Stdout = old
+    var paramKeys []string
+    var metricKeys []string
     
-    var buf strings.Builder
-    io.Copy(&buf, r)
-
-    fmt.Print(buf.String())
-
-    return buf.String()
-}
-
-func ExtractPIRMetrics(output string) map[string]float64 {
-    metrics := map[string]float64{
-        "setup_time":       0.0,
-        "query_time":       0.0,
-        "answer_time":      0.0,
-        "reconstruct_time": 0.0,
-        "offline_download": 0.0,
-        "online_upload":    0.0,
-        "online_download":  0.0,
+    for k := range params {
+        paramKeys = append(paramKeys, k)
     }
+    sort.Strings(paramKeys) 
     
-    timePatterns := map[string]string{
-        "setup_time":       `Setup\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-        "query_time":       `Building query\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-        "answer_time":      `Answering query\.\.\.\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
-        "reconstruct_time": `Reconstructing\.\.\.\s+Success!\s+Elapsed: ([\d\.]+)(µs|ms|s)`,
+    for k := range metrics {
+        metricKeys = append(metricKeys, k)
     }
+    sort.Strings(metricKeys)
     
-    dataPatterns := map[string]string{
-        "offline_download": `Offline download: ([\d\.]+) KB`,
-        "online_upload":    `Online upload: ([\d\.]+) KB`,
-        "online_download":  `Online download: ([\d\.]+) KB`,
-    }
+    var header []string
+    header = append(header, paramKeys...)
+    header = append(header, metricKeys...)
     
-    convertToMs := func(value float64, unit string) float64 {
-        switch unit {
-        case "µs": return value / 1000
-        case "ms": return value
-        case "s":  return value * 1000
-        default:   return value
-        }
+    values := make(map[string]string)
+    for _, k := range paramKeys {
+        values[k] = params[k]
     }
-    
-    metricsFound := make(map[string]bool)
-    
-    // extract time metrics
-    for name, pattern := range timePatterns {
-        re := regexp.MustCompile(pattern)
-        if matches := re.FindStringSubmatch(output); len(matches) >= 3 {
-            value, _ := strconv.ParseFloat(matches[1], 64)
-            metrics[name] = convertToMs(value, matches[2])
-            metricsFound[name] = true
-        }
+    for _, k := range metricKeys {
+        values[k] = fmt.Sprintf("%.6f", metrics[k])
     }
     
-    // extract data transfer metrics
-    for name, pattern := range dataPatterns {
-        re := regexp.MustCompile(pattern)
-        if matches := re.FindStringSubmatch(output); len(matches) >= 2 {
-            value, _ := strconv.ParseFloat(matches[1], 64)
-            metrics[name] = value
-            metricsFound[name] = true
+    // Read the rows logged so far. If they have other columns (from an older
+    // version of the tests), the file is rewritten with the union of both.
+    var oldRecords [][]string
+    if file, err := os.Open(filename); err == nil {
+        reader := csv.NewReader(file)
+        reader.FieldsPerRecord = -1
+        oldRecords, err = reader.ReadAll()
+        file.Close()
+        if err != nil {
+            fmt.Printf("Failed to log results: %v\n", err)
+            return
         }
     }
     
-    for name := range metrics {
-        if !metricsFound[name] {
-            fmt.Printf("Metric '%s' not found in output\n", name)
+    if len(oldRecords) > 0 && strings.Join(oldRecords[0], ",") == strings.Join(header, ",") {
+        file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+        if err != nil {
+            fmt.Printf("Failed to log results: %v\n", err)
+            return
         }
+        defer file.Close()
+        
+        writer := csv.NewWriter(file)
+        defer writer.Flush()
+        writer.Write(rowFor(header, values))
+        return
     }
     
-    return metrics
-}
-
-// LOGGING RESULTS FUNCTION --------------------------------------------------------------------------------------------------
-func LogTestResults(testName string, params map[string]string, metrics map[string]float64) {
-    os.MkdirAll("../../results", 0755)
-    filename := fmt.Sprintf("../../results/%s_results.csv", testName)
-    
-    fileExists := false
-    if _, err := os.Stat(filename); err == nil {
-        fileExists = true
+    var oldHeader []string
+    if len(oldRecords) > 0 {
+        oldHeader = oldRecords[0]
+    }
+    for _, k := range oldHeader {
+        if _, ok := values[k]; !ok {
+            header = append(header, k)
+        }
     }
     
-    file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    file, err := os.Create(filename)
     if err != nil {
         fmt.Printf("Failed to log results: %v\n", err)
         return
@@ -688,43 +662,48 @@ func LogTestResults(testName string, params map[string]string, metrics map[strin
     writer := csv.NewWriter(file)
     defer writer.Flush()
     
-    var paramKeys []string
-    var metricKeys []string
-    
-    for k := range params {
-        paramKeys = append(paramKeys, k)
-    }
-    sort.Strings(paramKeys) 
-    
-    for k := range metrics {
-        metricKeys = append(metricKeys, k)
+    writer.Write(header)
+    if len(oldRecords) > 0 {
+        oldRecords = oldRecords[1:]
     }
-    sort.Strings(metricKeys)
-    
-    if !fileExists {
-        var header []string
-        header = append(header, paramKeys...)
-        header = append(header, metricKeys...)
-        writer.Write(header)
+    for _, record := range oldRecords {
+        old := make(map[string]string)
+        for i, k := range oldHeader {
+            if i < len(record) {
+                old[k] = record[i]
+            }
+        }
+        writer.Write(rowFor(header, old))
     }
-    
-    var row []string
-    
-    for _, k := range paramKeys {
-        row = append(row, params[k])
+    writer.Write(rowFor(header, values))
+}
+
+// Returns the values of the columns in header, with "" for missing ones.
+func rowFor(header []string, values map[string]string) []string {
+    row := make([]string, len(header))
+    for i, k := range header {
+        row[i] = values[k]
     }
-    
-    for _, k := range metricKeys {
-        row = append(row, fmt.Sprintf("%.6f", metrics[k]))
+    return row
+}
+
+// Schemes that the sweeps run side by side: SimplePIR, DoublePIR, and the
+// two-server DPF scheme to compare them against. PIR_SCHEME=DoublePIR runs
+// only the named scheme.
+func researchSchemes(t *testing.T) []PIR {
+    if name := os.Getenv("PIR_SCHEME"); name != "" {
+        pir, err := SchemeByName(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return []PIR{pir}
     }
-    
-    writer.Write(row)
+    return []PIR{&SimplePIR{}, &DoublePIR{}, &TwoServerDPF{}}
 }
 
 // TESTING QUERY PRODUCT BY ID FUNCTION ---------------------------------------------------------------------------------------------
-func QueryProductByID(t *testing.T, productID uint64, DBSize uint64, recordSize uint64) {
-    pir := SimplePIR{}
-    
+// Returns the metrics measured by the PIR run with scheme pir.
+func QueryProductByID(t *testing.T, pir PIR, productID uint64, DBSize uint64, recordSize uint64) Metrics {
     _, allPirKeys, columns, baseRecordSize, err := LoadDatabaseOnce()
     if err != nil {
         t.Fatalf("Failed to load database: %v", err)
@@ -733,11 +712,12 @@ func QueryProductByID(t *testing.T, productID uint64, DBSize uint64, recordSize
     var pirKeys []uint64
     var actualRecordSize uint64
     
-    // limit DB size
+    // limit DB size; copy the keys, which LoadDatabaseOnce caches across runs,
+    // before compressing or expanding them below
     if DBSize > 0 && DBSize < uint64(len(allPirKeys)) {
-        pirKeys = allPirKeys[:DBSize]
+        pirKeys = append([]uint64(nil), allPirKeys[:DBSize]...)
     } else {
-        pirKeys = allPirKeys
+        pirKeys = append([]uint64(nil), allPirKeys...)
     }
     
     // limit Record size
@@ -784,14 +764,13 @@ func QueryProductByID(t *testing.T, productID uint64, DBSize uint64, recordSize
     }
     
     if !found {
-        fmt.Printf("Product ID %d not found in the database\n", productID)
-        return
+        t.Skipf("Product ID %d not found in the database", productID)
     }
     
     fmt.Printf("Running PIR query for product ID %d at index %d...\n", productID, queryIndex)
     
     // run PIR
-    RunPIR(&pir, DB, p, []uint64{queryIndex})
+    metrics := RunPIRWithMetrics(pir, DB, p, []uint64{queryIndex})
     
     // retrieve full record data
     binPath := "../../db/en.openfoodfacts.org.products.bin"
@@ -799,7 +778,7 @@ func QueryProductByID(t *testing.T, productID uint64, DBSize uint64, recordSize
     recordData, err := GetRecordFromBinary(binPath, columns, queryIndex)
     if err != nil {
         fmt.Printf("Error retrieving record: %v\n", err)
-        return
+        return metrics
     }
     
     fmt.Printf("\n=== Retrieved Full Record for Product ID %d ===\n", productID)
@@ -818,6 +797,7 @@ func QueryProductByID(t *testing.T, productID uint64, DBSize uint64, recordSize
     fmt.Printf("=== End Record ===\n")
     
     fmt.Printf("Successfully retrieved product ID %d\n", productID)
+    return metrics
 }
 
 // PRODUCT_ID=63 go test -run=TestQueryProduct
@@ -834,7 +814,9 @@ func TestQueryProduct(t *testing.T) {
     }
     
     fmt.Printf("Querying for product ID: %d\n", productID)
-    QueryProductByID(t, productID, 0, 0)
+    for _, pir := range researchSchemes(t) {
+        QueryProductByID(t, pir, productID, 0, 0)
+    }
 }
 
 // RUNNING THE TESTS MULTIPLE TIMES------------------------------------------------------------------------------------------------------------
@@ -927,23 +909,22 @@ func TestPIRWithDifferentDBSizes(t *testing.T) {
         }
     }
     
-    for _, dbSize := range dbSizes {
-        fmt.Printf("\n\n==== Testing PIR with %d entries (%d runs) ====\n", dbSize, runCount)
-        
-        RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
-            output := CaptureOutput(func() {
-                QueryProductByID(t, productID, dbSize, 0)
-            })
-            
-            metrics := ExtractPIRMetrics(output)
-            
-            params := map[string]string{
-                "db_size": fmt.Sprintf("%d", dbSize),
-                "record_size": "auto",
-            }
+    for _, pir := range researchSchemes(t) {
+        for _, dbSize := range dbSizes {
+            fmt.Printf("\n\n==== Testing %s with %d entries (%d runs) ====\n", pir.Name(), dbSize, runCount)
             
-            return params, metrics
-        })
+            RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
+                metrics := QueryProductByID(t, pir, productID, dbSize, 0).Map()
+                
+                params := map[string]string{
+                    "scheme": pir.Name(),
+                    "db_size": fmt.Sprintf("%d", dbSize),
+                    "record_size": "auto",
+                }
+                
+                return params, metrics
+            })
+        }
     }
 }
 
@@ -959,23 +940,22 @@ func TestPIRWithDifferentRecordSizes(t *testing.T) {
         }
     }
     
-    for _, recordSize := range recordSizes {
-        fmt.Printf("\n\n==== Testing PIR with record size %d bits (%d runs) ====\n", recordSize, runCount)
-        
-        RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
-            output := CaptureOutput(func() {
-                QueryProductByID(t, productID, 0, recordSize)
-            })
-            
-            metrics := ExtractPIRMetrics(output)
-            
-            params := map[string]string{
-                "db_size": "auto", 
-                "record_size": fmt.Sprintf("%d", recordSize),
-            }
+    for _, pir := range researchSchemes(t) {
+        for _, recordSize := range recordSizes {
+            fmt.Printf("\n\n==== Testing %s with record size %d bits (%d runs) ====\n", pir.Name(), recordSize, runCount)
             
-            return params, metrics
-        })
+            RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
+                metrics := QueryProductByID(t, pir, productID, 0, recordSize).Map()
+                
+                params := map[string]string{
+                    "scheme": pir.Name(),
+                    "db_size": "auto", 
+                    "record_size": fmt.Sprintf("%d", recordSize),
+                }
+                
+                return params, metrics
+            })
+        }
     }
 }
 
@@ -992,25 +972,24 @@ func TestPIRWithSizeCombinations(t *testing.T) {
         }
     }
     
-    for _, dbSize := range dbSizes {
-        for _, recordSize := range recordSizes {
-            fmt.Printf("\n\n==== Testing PIR with DB size %d and record size %d bits (%d runs) ====\n", 
-                      dbSize, recordSize, runCount)
-            
-            RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
-                output := CaptureOutput(func() {
-                    QueryProductByID(t, productID, dbSize, recordSize)
-                })
-                
-                metrics := ExtractPIRMetrics(output)
-                
-                params := map[string]string{
-                    "db_size": fmt.Sprintf("%d", dbSize),
-                    "record_size": fmt.Sprintf("%d", recordSize),
-                }
+    for _, pir := range researchSchemes(t) {
+        for _, dbSize := range dbSizes {
+            for _, recordSize := range recordSizes {
+                fmt.Printf("\n\n==== Testing %s with DB size %d and record size %d bits (%d runs) ====\n", 
+                          pir.Name(), dbSize, recordSize, runCount)
                 
-                return params, metrics
-            })
+                RunTestMultipleTimes(t, runCount, func() (map[string]string, map[string]float64) {
+                    metrics := QueryProductByID(t, pir, productID, dbSize, recordSize).Map()
+                    
+                    params := map[string]string{
+                        "scheme": pir.Name(),
+                        "db_size": fmt.Sprintf("%d", dbSize),
+                        "record_size": fmt.Sprintf("%d", recordSize),
+                    }
+                    
+                    return params, metrics
+                })
+            }
         }
     }
 }
\ No newline at end of file
@@ -0,0 +1,517 @@
+package pir
+
+// #include "pir.h"
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// Version of the wire encoding produced by the Marshal* methods below.
+// Every encoding starts with this version byte, followed by a byte that
+// identifies the type of the encoded value.
+const WireVersion = byte(1)
+
+const (
+	wireMsg byte = iota + 1
+	wireMsgSlice
+	wireState
+	wireCompressedState
+	wireParams
+	wireDBinfo
+	wireKeywordLayout
+	wireServerSnapshot
+	wireHintPatch
+	wireBatchLayout
+	wireMembershipLayout
+	wireRecordLayout
+)
+
+// Number of bits in a C.Elem, i.e., the largest supported logq.
+const elemBits = uint64(8 * unsafe.Sizeof(C.Elem(0)))
+
+func checkLogq(logq uint64) error {
+	if logq == 0 || logq > elemBits {
+		return fmt.Errorf("%w: logq=%d, elems have %d bits", ErrBadEncoding, logq, elemBits)
+	}
+	return nil
+}
+
+func putHeader(buf []byte, kind byte) []byte {
+	return append(buf, WireVersion, kind)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBlob(buf []byte, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+// Wire decoder; each read records the first error it hits, and all later
+// reads become no-ops, so callers only need to check d.err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrBadEncoding}, args...)...)
+	}
+}
+
+func (d *decoder) header(kind byte) {
+	if len(d.buf) < 2 {
+		d.fail("truncated header")
+		return
+	}
+	if d.buf[0] != WireVersion {
+		d.err = fmt.Errorf("%w: got version %d, want %d", ErrUnsupportedVersion, d.buf[0], WireVersion)
+		return
+	}
+	if d.buf[1] != kind {
+		d.fail("got type %d, want %d", d.buf[1], kind)
+		return
+	}
+	d.buf = d.buf[2:]
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.fail("need %d bytes, have %d", n, len(d.buf))
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// Reads a uvarint-length-prefixed byte string.
+func (d *decoder) blob() []byte {
+	return d.bytes(d.uvarint())
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.fail("%d trailing bytes", len(d.buf))
+	}
+	return d.err
+}
+
+// Packs the first rows*cols entries of m into ceil(rows*cols*logq/8) bytes,
+// least significant bits first.
+func putMatrix(buf []byte, m *Matrix, logq uint64) ([]byte, error) {
+	if m == nil {
+		return nil, fmt.Errorf("%w: nil matrix", ErrBadEncoding)
+	}
+	sz := m.Rows * m.Cols
+	if uint64(len(m.Data)) < sz {
+		return nil, fmt.Errorf("%w: %d-by-%d matrix holds only %d elems", ErrBadEncoding,
+			m.Rows, m.Cols, len(m.Data))
+	}
+
+	buf = appendUvarint(buf, m.Rows)
+	buf = appendUvarint(buf, m.Cols)
+	return packElems(buf, m.Data[:sz], logq)
+}
+
+// Packs elems into ceil(len(elems)*logq/8) bytes, least significant bits
+// first; the last byte is padded with zero bits.
+func packElems(buf []byte, elems []C.Elem, logq uint64) ([]byte, error) {
+	acc := uint64(0) // pending bits, always fewer than 8
+	n := uint64(0)
+	for _, e := range elems {
+		v := uint64(e)
+		if logq < 64 && v>>logq != 0 {
+			return nil, fmt.Errorf("%w: elem %d does not fit in %d bits", ErrBadEncoding, v, logq)
+		}
+		for bits := logq; bits > 0; {
+			take := 8 - n
+			if bits < take {
+				take = bits
+			}
+			acc |= (v & (1<<take - 1)) << n
+			v >>= take
+			bits -= take
+			n += take
+			if n == 8 {
+				buf = append(buf, byte(acc))
+				acc, n = 0, 0
+			}
+		}
+	}
+	if n > 0 {
+		buf = append(buf, byte(acc))
+	}
+	return buf, nil
+}
+
+func (d *decoder) matrix(logq uint64) *Matrix {
+	rows := d.uvarint()
+	cols := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	// Check the claimed size against the remaining input before allocating.
+	max_elems := uint64(len(d.buf)) * 8 / logq
+	if cols != 0 && rows > max_elems/cols {
+		d.fail("%d-by-%d matrix does not fit in %d bytes", rows, cols, len(d.buf))
+		return nil
+	}
+	sz := rows * cols
+	packed := d.bytes((sz*logq + 7) / 8)
+	if d.err != nil {
+		return nil
+	}
+
+	m := MatrixNew(rows, cols)
+	if !unpackElems(m.Data, packed, logq) {
+		d.fail("nonzero padding bits")
+		return nil
+	}
+	return m
+}
+
+// Inverse of packElems: fills elems from packed, and reports whether the
+// padding bits are zero.
+func unpackElems(elems []C.Elem, packed []byte, logq uint64) bool {
+	pos := 0
+	acc := uint64(0)
+	n := uint64(0) // number of unread bits in acc
+	for j := range elems {
+		v := uint64(0)
+		for got := uint64(0); got < logq; {
+			if n == 0 {
+				acc = uint64(packed[pos])
+				pos += 1
+				n = 8
+			}
+			take := logq - got
+			if n < take {
+				take = n
+			}
+			v |= (acc & (1<<take - 1)) << got
+			acc >>= take
+			n -= take
+			got += take
+		}
+		elems[j] = C.Elem(v)
+	}
+	return acc == 0
+}
+
+func putMatrices(buf []byte, ms []*Matrix, logq uint64) ([]byte, error) {
+	buf = appendUvarint(buf, uint64(len(ms)))
+	var err error
+	for _, m := range ms {
+		buf, err = putMatrix(buf, m, logq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (d *decoder) matrices(logq uint64) []*Matrix {
+	num := d.uvarint()
+	// every matrix takes at least 2 bytes, for its dimensions
+	if num > uint64(len(d.buf))/2 {
+		d.fail("%d matrices do not fit in %d bytes", num, len(d.buf))
+		return nil
+	}
+	var ms []*Matrix
+	for j := uint64(0); j < num && d.err == nil; j++ {
+		ms = append(ms, d.matrix(logq))
+	}
+	return ms
+}
+
+func marshalMatrices(kind byte, ms []*Matrix, logq uint64) ([]byte, error) {
+	if err := checkLogq(logq); err != nil {
+		return nil, err
+	}
+	buf := putHeader(nil, kind)
+	buf = append(buf, byte(logq))
+	return putMatrices(buf, ms, logq)
+}
+
+func unmarshalMatrices(kind byte, data []byte, logq uint64) ([]*Matrix, error) {
+	if err := checkLogq(logq); err != nil {
+		return nil, err
+	}
+	d := decoder{buf: data}
+	d.header(kind)
+	if got := d.bytes(1); d.err == nil && uint64(got[0]) != logq {
+		d.fail("encoded with logq=%d, want %d", got[0], logq)
+	}
+	ms := d.matrices(logq)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Encodes the message for the wire, packing each entry into logq bits.
+func (m *Msg) Marshal(logq uint64) ([]byte, error) {
+	return marshalMatrices(wireMsg, m.Data, logq)
+}
+
+// Decodes a message produced by Marshal with the same logq.
+func (m *Msg) Unmarshal(data []byte, logq uint64) error {
+	ms, err := unmarshalMatrices(wireMsg, data, logq)
+	if err != nil {
+		return err
+	}
+	m.Data = ms
+	return nil
+}
+
+// Encodes the state for the wire (or for storage), packing each entry into logq bits.
+func (s *State) Marshal(logq uint64) ([]byte, error) {
+	return marshalMatrices(wireState, s.Data, logq)
+}
+
+// Decodes a state produced by Marshal with the same logq.
+func (s *State) Unmarshal(data []byte, logq uint64) error {
+	ms, err := unmarshalMatrices(wireState, data, logq)
+	if err != nil {
+		return err
+	}
+	s.Data = ms
+	return nil
+}
+
+// Encodes a batch of messages for the wire, packing each entry into logq bits.
+func (m *MsgSlice) Marshal(logq uint64) ([]byte, error) {
+	if err := checkLogq(logq); err != nil {
+		return nil, err
+	}
+	buf := putHeader(nil, wireMsgSlice)
+	buf = append(buf, byte(logq))
+	buf = appendUvarint(buf, uint64(len(m.Data)))
+	var err error
+	for _, msg := range m.Data {
+		buf, err = putMatrices(buf, msg.Data, logq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Decodes a batch of messages produced by Marshal with the same logq.
+func (m *MsgSlice) Unmarshal(data []byte, logq uint64) error {
+	if err := checkLogq(logq); err != nil {
+		return err
+	}
+	d := decoder{buf: data}
+	d.header(wireMsgSlice)
+	if got := d.bytes(1); d.err == nil && uint64(got[0]) != logq {
+		d.fail("encoded with logq=%d, want %d", got[0], logq)
+	}
+	num := d.uvarint()
+	// every message takes at least 1 byte, for its length
+	if num > uint64(len(d.buf)) {
+		d.fail("%d messages do not fit in %d bytes", num, len(d.buf))
+	}
+	var msgs []Msg
+	for j := uint64(0); j < num && d.err == nil; j++ {
+		msgs = append(msgs, Msg{Data: d.matrices(logq)})
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	m.Data = msgs
+	return nil
+}
+
+func (c CompressedState) MarshalBinary() ([]byte, error) {
+	if c.Seed == nil {
+		return nil, fmt.Errorf("%w: missing seed", ErrBadEncoding)
+	}
+	buf := putHeader(nil, wireCompressedState)
+	return append(buf, c.Seed[:]...), nil
+}
+
+func (c *CompressedState) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireCompressedState)
+	seed := d.bytes(uint64(len(PRGKey{})))
+	if err := d.finish(); err != nil {
+		return err
+	}
+	c.Seed = new(PRGKey)
+	copy(c.Seed[:], seed)
+	return nil
+}
+
+func (p Params) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireParams)
+	buf = appendUvarint(buf, p.N)
+	buf = appendUint64(buf, math.Float64bits(p.Sigma))
+	buf = appendUvarint(buf, p.L)
+	buf = appendUvarint(buf, p.M)
+	buf = appendUvarint(buf, p.Logq)
+	buf = appendUvarint(buf, p.P)
+	if p.HintLogq != 0 || p.AnswerLogq != 0 {
+		// left out otherwise, so that the encoding of params without a
+		// compressed hint or answer is unchanged
+		buf = appendUvarint(buf, p.HintLogq)
+		buf = appendUvarint(buf, p.AnswerLogq)
+	}
+	return buf, nil
+}
+
+func (p *Params) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireParams)
+	var out Params
+	out.N = d.uvarint()
+	if sigma := d.bytes(8); d.err == nil {
+		out.Sigma = math.Float64frombits(binary.LittleEndian.Uint64(sigma))
+	}
+	out.L = d.uvarint()
+	out.M = d.uvarint()
+	out.Logq = d.uvarint()
+	out.P = d.uvarint()
+	if d.err == nil && len(d.buf) > 0 {
+		out.HintLogq = d.uvarint()
+	}
+	if d.err == nil && len(d.buf) > 0 {
+		out.AnswerLogq = d.uvarint()
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	if err := checkLogq(out.Logq); err != nil {
+		return err
+	}
+	if math.IsNaN(out.Sigma) || out.Sigma < 0 {
+		return fmt.Errorf("%w: sigma=%f", ErrBadEncoding, out.Sigma)
+	}
+	if out.P < 2 || (out.Logq < 64 && out.P > 1<<out.Logq) {
+		return fmt.Errorf("%w: p=%d, logq=%d", ErrBadEncoding, out.P, out.Logq)
+	}
+	if out.HintLogq > out.Logq || out.AnswerLogq > out.Logq {
+		return fmt.Errorf("%w: hint of %d bits, answer of %d bits, logq=%d", ErrBadEncoding,
+			out.HintLogq, out.AnswerLogq, out.Logq)
+	}
+	*p = out
+	return nil
+}
+
+func (info DBinfo) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireDBinfo)
+	for _, v := range []uint64{info.Num, info.Row_length, info.Packing, info.Ne, info.X,
+		info.P, info.Logq, info.Basis, info.Squishing, info.Cols} {
+		buf = appendUvarint(buf, v)
+	}
+	return buf, nil
+}
+
+func (info *DBinfo) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireDBinfo)
+	var out DBinfo
+	for _, v := range []*uint64{&out.Num, &out.Row_length, &out.Packing, &out.Ne, &out.X,
+		&out.P, &out.Logq, &out.Basis, &out.Squishing, &out.Cols} {
+		*v = d.uvarint()
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	if err := checkLogq(out.Logq); err != nil {
+		return err
+	}
+	if out.X != 0 && out.Ne%out.X != 0 {
+		return fmt.Errorf("%w: x=%d does not divide ne=%d", ErrBadEncoding, out.X, out.Ne)
+	}
+	if out.Basis*out.Squishing > elemBits {
+		return fmt.Errorf("%w: cannot squish %d %d-bit values into one elem", ErrBadEncoding,
+			out.Squishing, out.Basis)
+	}
+	*info = out
+	return nil
+}
+
+func (k KeywordLayout) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireKeywordLayout)
+	buf = appendUint64(buf, k.Seed)
+	buf = appendUvarint(buf, k.Value_bits)
+	return buf, nil
+}
+
+func (k *KeywordLayout) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireKeywordLayout)
+	var out KeywordLayout
+	if seed := d.bytes(8); d.err == nil {
+		out.Seed = binary.LittleEndian.Uint64(seed)
+	}
+	out.Value_bits = d.uvarint()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if out.Value_bits == 0 || out.Value_bits > 32 {
+		return fmt.Errorf("%w: %d value bits", ErrBadEncoding, out.Value_bits)
+	}
+	*k = out
+	return nil
+}
+
+func (m MembershipLayout) MarshalBinary() ([]byte, error) {
+	buf := putHeader(nil, wireMembershipLayout)
+	buf = appendUint64(buf, m.Seed)
+	buf = appendUvarint(buf, m.Fingerprint_bits)
+	return buf, nil
+}
+
+func (m *MembershipLayout) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	d.header(wireMembershipLayout)
+	var out MembershipLayout
+	if seed := d.bytes(8); d.err == nil {
+		out.Seed = binary.LittleEndian.Uint64(seed)
+	}
+	out.Fingerprint_bits = d.uvarint()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if out.Fingerprint_bits == 0 || out.Fingerprint_bits > 32 {
+		return fmt.Errorf("%w: %d fingerprint bits", ErrBadEncoding, out.Fingerprint_bits)
+	}
+	*m = out
+	return nil
+}
@@ -0,0 +1,202 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+func randomMatrix(rows, cols, logq uint64) *Matrix {
+	m := MatrixRand(RandomBufPRG(), rows, cols, logq, 0)
+	if logq < elemBits {
+		m.ReduceMod(1 << logq)
+	}
+	return m
+}
+
+func sameMatrix(a, b *Matrix) bool {
+	if a.Rows != b.Rows || a.Cols != b.Cols {
+		return false
+	}
+	for j := uint64(0); j < a.Rows*a.Cols; j++ {
+		if a.Data[j] != b.Data[j] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMsgEncoding(t *testing.T) {
+	for _, logq := range []uint64{1, 7, 17, 32} {
+		msg := MakeMsg(randomMatrix(13, 5, logq), randomMatrix(1, 1, logq), MatrixZeros(0, 0))
+		buf, err := msg.Marshal(logq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed := (13*5*logq+7)/8 + (logq+7)/8
+		if uint64(len(buf)) > packed+16 {
+			t.Fatalf("logq=%d: %d bytes to encode %d packed bytes", logq, len(buf), packed)
+		}
+
+		var got Msg
+		if err := got.Unmarshal(buf, logq); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Data) != len(msg.Data) {
+			t.Fatalf("got %d matrices, want %d", len(got.Data), len(msg.Data))
+		}
+		for j := range msg.Data {
+			if !sameMatrix(got.Data[j], msg.Data[j]) {
+				t.Fatalf("logq=%d: matrix %d differs after decoding", logq, j)
+			}
+		}
+	}
+}
+
+func TestMsgSliceAndStateEncoding(t *testing.T) {
+	slice := MakeMsgSlice(MakeMsg(randomMatrix(8, 1, 32)), MakeMsg(randomMatrix(3, 2, 32), randomMatrix(2, 3, 32)))
+	buf, err := slice.Marshal(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got MsgSlice
+	if err := got.Unmarshal(buf, 32); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Data) != 2 || len(got.Data[1].Data) != 2 || !sameMatrix(got.Data[1].Data[1], slice.Data[1].Data[1]) {
+		t.Fatal("batch differs after decoding")
+	}
+
+	st := MakeState(randomMatrix(4, 4, 32))
+	buf, err = st.Marshal(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got_st State
+	if err := got_st.Unmarshal(buf, 32); err != nil {
+		t.Fatal(err)
+	}
+	if !sameMatrix(got_st.Data[0], st.Data[0]) {
+		t.Fatal("state differs after decoding")
+	}
+
+	// a State must not be accepted as a Msg
+	var msg Msg
+	if err := msg.Unmarshal(buf, 32); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("decoded state as msg: %v", err)
+	}
+}
+
+func TestMsgEncodingRejectsBadInput(t *testing.T) {
+	msg := MakeMsg(randomMatrix(10, 3, 32))
+	buf, err := msg.Marshal(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Msg
+	if err := got.Unmarshal(buf[:len(buf)-1], 32); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("truncated: %v", err)
+	}
+	if err := got.Unmarshal(append(buf, 0), 32); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("trailing bytes: %v", err)
+	}
+	if err := got.Unmarshal(buf, 31); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("wrong logq: %v", err)
+	}
+
+	bad := append([]byte{}, buf...)
+	bad[0] = WireVersion + 1
+	if err := got.Unmarshal(bad, 32); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("wrong version: %v", err)
+	}
+
+	// header, logq, 1 matrix of 2^40-by-2^40 with no data
+	huge := []byte{WireVersion, wireMsg, 32, 1, 0x80, 0x80, 0x80, 0x80, 0x80, 0x20, 0x80, 0x80, 0x80, 0x80, 0x80, 0x20}
+	if err := got.Unmarshal(huge, 32); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("huge dims: %v", err)
+	}
+
+	// 9-bit elem with logq=8
+	m := MatrixZeros(1, 1)
+	m.Data[0] = 1 << 8
+	small := MakeMsg(m)
+	if _, err := small.Marshal(8); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("oversized elem: %v", err)
+	}
+	if _, err := msg.Marshal(elemBits + 1); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("oversized logq: %v", err)
+	}
+}
+
+func TestParamsAndInfoEncoding(t *testing.T) {
+	p := Params{N: 1024, Sigma: 6.4, L: 2048, M: 4096, Logq: 32, P: 991}
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got_p Params
+	if err := got_p.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got_p != p {
+		t.Fatalf("got %v, want %v", got_p, p)
+	}
+	bad := p
+	bad.P = 1
+	buf, _ = bad.MarshalBinary()
+	if err := got_p.UnmarshalBinary(buf); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("bad p: %v", err)
+	}
+	p.HintLogq = 20
+	buf, _ = p.MarshalBinary()
+	if err := got_p.UnmarshalBinary(buf); err != nil || got_p != p {
+		t.Fatalf("compressed hint: got %v, %v", got_p, err)
+	}
+	p.HintLogq, p.AnswerLogq = 0, 16
+	buf, _ = p.MarshalBinary()
+	if err := got_p.UnmarshalBinary(buf); err != nil || got_p != p {
+		t.Fatalf("compressed answer: got %v, %v", got_p, err)
+	}
+	bad = p
+	bad.HintLogq = 33
+	buf, _ = bad.MarshalBinary()
+	if err := got_p.UnmarshalBinary(buf); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("bad hint bits: %v", err)
+	}
+
+	info := DBinfo{Num: 1 << 20, Row_length: 8, Packing: 1, Ne: 1, X: 1, P: 991, Logq: 32,
+		Basis: 10, Squishing: 3, Cols: 4096}
+	buf, err = info.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got_info DBinfo
+	if err := got_info.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got_info != info {
+		t.Fatalf("got %v, want %v", got_info, info)
+	}
+	if err := got_info.UnmarshalBinary(buf[:len(buf)-1]); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("truncated: %v", err)
+	}
+	info.X = 3
+	info.Ne = 4
+	buf, _ = info.MarshalBinary()
+	if err := got_info.UnmarshalBinary(buf); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("x does not divide ne: %v", err)
+	}
+
+	seed := RandomPRGKey()
+	buf, err = MakeCompressedState(seed).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got_comp CompressedState
+	if err := got_comp.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if *got_comp.Seed != *seed {
+		t.Fatal("seed differs after decoding")
+	}
+}
@@ -0,0 +1,321 @@
+package pir
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Sharding: a DB is split into shards of consecutive rows, each of which is
+// preprocessed and answered on its own, in-process or by a worker. All
+// shards have the same number of columns and share the seed of the LWE
+// matrix A, so the hint of the full DB is the concatenation of the shards'
+// hints, and its answer is the concatenation of their answers: clients query
+// a ShardedServer like any other Server.
+
+// One shard of a sharded DB. *Server implements it; so can a stub that
+// forwards queries to a worker process.
+type Shard interface {
+	Params() Params
+	DBInfo() DBinfo
+	Seed() CompressedState
+	Hint() Msg
+	AnswerContext(ctx context.Context, query MsgSlice) (Msg, error)
+
+	// Answers a single query from rows [start, end) of the shard only.
+	AnswerRowsContext(ctx context.Context, query Msg, start, end uint64) (Msg, error)
+}
+
+// Implemented by schemes whose answer holds one elem per DB row, such as
+// SimplePIR, so that a query can be answered from a range of rows only.
+type RowAnswerer interface {
+	AnswerRows(ctx context.Context, DB *Database, query Msg, start, end uint64, p Params,
+		workers int) (Msg, error)
+}
+
+// Describes where the entries of each shard live in the full DB.
+type ShardLayout struct {
+	Rows    []uint64 // number of DB rows of each shard
+	M       uint64   // number of DB columns
+	Ne      uint64   // number of Z_p elems per entry
+	Packing uint64   // number of entries per Z_p elem, if more than 0
+}
+
+// Number of entries that fit in the given number of rows.
+func (l ShardLayout) entries(rows uint64) uint64 {
+	return dbCapacity(Params{L: rows, M: l.M}, DBinfo{Ne: l.Ne, Packing: l.Packing})
+}
+
+// Returns the index in the full DB of entry i of the given shard.
+func (l ShardLayout) Index(shard, i uint64) (uint64, error) {
+	if shard >= uint64(len(l.Rows)) {
+		return 0, fmt.Errorf("%w: shard %d, %d shards", ErrIndexOutOfRange, shard, len(l.Rows))
+	}
+	if i >= l.entries(l.Rows[shard]) {
+		return 0, fmt.Errorf("%w: index %d, shard %d holds %d entries", ErrIndexOutOfRange,
+			i, shard, l.entries(l.Rows[shard]))
+	}
+	offset := uint64(0)
+	for _, rows := range l.Rows[:shard] {
+		offset += l.entries(rows)
+	}
+	return offset + i, nil
+}
+
+// Returns the shard that holds entry i of the full DB, and the entry's index
+// within that shard.
+func (l ShardLayout) Locate(i uint64) (uint64, uint64, error) {
+	for shard, rows := range l.Rows {
+		if i < l.entries(rows) {
+			return uint64(shard), i, nil
+		}
+		i -= l.entries(rows)
+	}
+	return 0, 0, fmt.Errorf("%w: index past the last shard", ErrIndexOutOfRange)
+}
+
+// Splits a DB built for params p into num shards of consecutive rows. Every
+// shard but the last has the same number of rows, a multiple of both Ne and
+// 8 (the packed matrix-vector kernel handles 8 rows at a time). Returns the
+// shards along with their params, which only differ from p in L.
+func SplitDB(DB *Database, p Params, num uint64) ([]*Database, []Params, ShardLayout, error) {
+	if err := checkSetupDB(DB, p); err != nil {
+		return nil, nil, ShardLayout{}, err
+	}
+
+	unit := DB.Info.Ne
+	for unit%8 != 0 {
+		unit += DB.Info.Ne
+	}
+	if num == 0 {
+		return nil, nil, ShardLayout{}, fmt.Errorf("%w: 0 shards", ErrBadParams)
+	}
+	step := (p.L/num + unit - 1) / unit * unit
+	if step*(num-1) >= p.L {
+		return nil, nil, ShardLayout{}, fmt.Errorf("%w: cannot split %d rows into %d shards of %d-row blocks",
+			ErrBadParams, p.L, num, unit)
+	}
+
+	layout := ShardLayout{M: p.M, Ne: DB.Info.Ne, Packing: DB.Info.Packing}
+	var shards []*Database
+	var params []Params
+	left := DB.Info.Num
+	for j := uint64(0); j < num; j++ {
+		rows := step
+		if j == num-1 {
+			rows = p.L - j*step
+		}
+		shard_p := p
+		shard_p.L = rows
+
+		D := new(Database)
+		D.Info = DB.Info
+		D.Info.Num = layout.entries(rows)
+		if D.Info.Num > left {
+			D.Info.Num = left
+		}
+		left -= D.Info.Num
+		D.Data = DB.Data.RowsDeepCopy(j*step, rows)
+
+		shards = append(shards, D)
+		params = append(params, shard_p)
+		layout.Rows = append(layout.Rows, rows)
+	}
+	return shards, params, layout, nil
+}
+
+// Answers queries by fanning them out to the shards of a DB, and merging
+// their answers. Safe for concurrent use if the shards are.
+type ShardedServer struct {
+	pi     CheckedPIR
+	params Params
+	info   DBinfo
+	seed   CompressedState
+	hint   Msg
+	layout ShardLayout
+	starts []uint64 // first row of each shard
+	shards []Shard
+}
+
+// Splits DB into num shards and preprocesses each of them in-process; DB
+// itself is left untouched. Only schemes whose hint has one row per DB row,
+// such as SimplePIR, can be sharded.
+func NewShardedServer(pi CheckedPIR, DB *Database, p Params, num uint64) (*ShardedServer, error) {
+	DBs, params, _, err := SplitDB(DB, p, num)
+	if err != nil {
+		return nil, err
+	}
+
+	_, seed := pi.InitCompressed(DB.Info, p)
+	var shards []Shard
+	for j := range DBs {
+		s, err := NewShardServer(pi, DBs[j], params[j], seed)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", j, err)
+		}
+		shards = append(shards, s)
+	}
+	return NewShardedServerFrom(pi, shards)
+}
+
+// Builds a sharded server from shards preprocessed elsewhere (e.g., by
+// NewShardServer in worker processes), in the order of their rows.
+func NewShardedServerFrom(pi CheckedPIR, shards []Shard) (*ShardedServer, error) {
+	if len(shards) == 0 {
+		return nil, ErrEmptyDB
+	}
+
+	first := shards[0]
+	s := &ShardedServer{
+		pi:     pi,
+		params: first.Params(),
+		info:   first.DBInfo(),
+		seed:   first.Seed(),
+		shards: shards,
+	}
+	if s.seed.Seed == nil {
+		return nil, fmt.Errorf("%w: missing seed", ErrBadState)
+	}
+	s.layout = ShardLayout{M: s.params.M, Ne: s.info.Ne, Packing: s.info.Packing}
+	s.params.L = 0
+
+	H := MatrixZeros(0, 0)
+	for j, shard := range shards {
+		p, info, hint := shard.Params(), shard.DBInfo(), shard.Hint()
+		seed := shard.Seed()
+		if seed.Seed == nil || *seed.Seed != *s.seed.Seed {
+			return nil, fmt.Errorf("%w: shard %d has a different seed", ErrBadState, j)
+		}
+		same := p
+		same.L = s.params.L
+		if same != s.params {
+			return nil, fmt.Errorf("%w: shard %d has different params", ErrBadParams, j)
+		}
+		if info.Ne != s.info.Ne || info.Packing != s.info.Packing || info.P != s.info.P ||
+			info.Squishing != s.info.Squishing || info.Cols != s.info.Cols {
+			return nil, fmt.Errorf("%w: shard %d has a different entry layout", ErrBadInput, j)
+		}
+		if len(hint.Data) != 1 || !hasDims(hint.Data[0], p.L, hint.Data[0].Cols) {
+			return nil, fmt.Errorf("%w: %s hints cannot be merged across shards", ErrNotSupported, pi.Name())
+		}
+		if H.Rows > 0 && hint.Data[0].Cols != H.Cols {
+			return nil, fmt.Errorf("%w: shard %d has a %d-column hint", ErrBadState, j, hint.Data[0].Cols)
+		}
+
+		// Every shard but the last may hold fewer entries than fit in it, in
+		// which case the unused indices read as 0 (as in ConcatDBs).
+		s.info.Num = s.layout.entries(s.params.L) + info.Num
+		s.starts = append(s.starts, s.params.L)
+		s.layout.Rows = append(s.layout.Rows, p.L)
+		s.params.L += p.L
+		H.Concat(hint.Data[0].RowsDeepCopy(0, p.L))
+	}
+	s.hint = MakeMsg(H)
+	return s, nil
+}
+
+func (s *ShardedServer) Params() Params {
+	return s.params
+}
+
+// Returns the description of the full DB that clients need to build queries.
+func (s *ShardedServer) DBInfo() DBinfo {
+	return s.info
+}
+
+func (s *ShardedServer) Seed() CompressedState {
+	return s.seed
+}
+
+// Returns the hint of the full DB, i.e., the shards' hints one after another.
+func (s *ShardedServer) Hint() Msg {
+	return s.hint
+}
+
+// Returns where each shard's entries live in the full DB.
+func (s *ShardedServer) Layout() ShardLayout {
+	return s.layout
+}
+
+// Answers a batch of queries, built by a Client with this server's params.
+func (s *ShardedServer) Answer(query MsgSlice) (Msg, error) {
+	return s.AnswerContext(context.Background(), query)
+}
+
+// Sends each query of the batch to every shard that holds some of the rows
+// the query is answered from, in parallel across shards, and stitches the
+// answers together. Gives up as soon as one shard fails or ctx is cancelled.
+func (s *ShardedServer) AnswerContext(ctx context.Context, query MsgSlice) (Msg, error) {
+	num := uint64(len(query.Data))
+	if num == 0 {
+		return Msg{}, fmt.Errorf("%w: empty batch", ErrBadQuery)
+	}
+	if s.params.L/num < s.info.Ne {
+		return Msg{}, fmt.Errorf("%w: %d queries, %d rows", ErrTooManyQueries, num, s.params.L)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ans := MatrixNew(s.params.L, 1)
+	errs := make(chan error, len(s.shards))
+	var wg sync.WaitGroup
+	for j := range s.shards {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			if err := s.answerShard(ctx, j, query, ans); err != nil {
+				errs <- fmt.Errorf("shard %d: %w", j, err)
+				cancel()
+			}
+		}(j)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return Msg{}, err
+	}
+	return MakeMsg(ans), nil
+}
+
+// Fills in the rows of ans that shard j holds. Each query is answered from
+// the rows it overlaps only, so a batch costs the shard one pass over its rows.
+func (s *ShardedServer) answerShard(ctx context.Context, j int, query MsgSlice, ans *Matrix) error {
+	shard_start := s.starts[j]
+	shard_end := shard_start + s.layout.Rows[j]
+	num := uint64(len(query.Data))
+
+	for b := uint64(0); b < num; b++ {
+		start, end := batchRows(s.params.L, b, num)
+		if start < shard_start {
+			start = shard_start
+		}
+		if end > shard_end {
+			end = shard_end
+		}
+		if start >= end {
+			continue
+		}
+
+		a, err := s.shards[j].AnswerRowsContext(ctx, query.Data[b], start-shard_start, end-shard_start)
+		if err != nil {
+			return err
+		}
+		if len(a.Data) != 1 || !hasDims(a.Data[0], end-start, 1) {
+			return fmt.Errorf("%w: expected answer of dimension %d", ErrBadAnswer, end-start)
+		}
+		copy(ans.Data[start:end], a.Data[0].Data)
+	}
+	return nil
+}
+
+// Builds a query for entry i of the given shard, for a client of a
+// ShardedServer with the given layout. Client.Recover then returns the
+// entry as usual.
+func (c *Client) QueryShard(layout ShardLayout, shard, i uint64) (*PendingQuery, MsgSlice, error) {
+	index, err := layout.Index(shard, i)
+	if err != nil {
+		return nil, MsgSlice{}, err
+	}
+	return c.Query(index)
+}
@@ -0,0 +1,178 @@
+package pir
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func queryAndRecover(t *testing.T, client *Client, answer func(MsgSlice) (Msg, error), pending *PendingQuery,
+	query MsgSlice) []uint64 {
+	ans, err := answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := client.Recover(pending, ans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vals
+}
+
+func TestSimplePirShardedServer(t *testing.T) {
+	pi := &SimplePIR{}
+	N, d := uint64(1<<16), uint64(8)
+	p, err := pi.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB := MakeRandomDB(N, d, &p)
+	orig := DB.Copy()
+
+	server, err := NewShardedServer(pi, DB, p, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMatrix(DB.Data, orig.Data) {
+		t.Fatal("NewShardedServer modified the database")
+	}
+	layout := server.Layout()
+	if len(layout.Rows) != 3 || server.Params() != p {
+		t.Fatalf("got %d shards with params %v", len(layout.Rows), server.Params())
+	}
+
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint64{0, N / 3, N / 2, N - 1} {
+		shard, local, err := layout.Locate(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, query, err := client.QueryShard(layout, shard, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := queryAndRecover(t, client, server.Answer, pending, query); got[0] != orig.GetElem(i) {
+			t.Fatalf("index %d (shard %d): got %d instead of %d", i, shard, got[0], orig.GetElem(i))
+		}
+	}
+
+	// A batch whose row ranges cross the shard boundaries.
+	pending, query, err := client.Query(0, N-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := queryAndRecover(t, client, server.Answer, pending, query)
+	if got[0] != orig.GetElem(0) || got[1] != orig.GetElem(N-1) {
+		t.Fatalf("batch: got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := server.AnswerContext(ctx, query); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled answer: got %v", err)
+	}
+}
+
+func TestConcatPartialDBs(t *testing.T) {
+	pi := &SimplePIR{}
+	d := uint64(8)
+	p := pi.PickParams(1<<12, d, SEC_PARAM, LOGQ)
+	capacity := p.L * p.M
+
+	var DBs []*Database
+	var vals [][]uint64
+	for j, num := range []uint64{capacity, capacity / 2, capacity / 3} {
+		v := make([]uint64, num)
+		for i := range v {
+			v[i] = uint64(i*7+j) % 256
+		}
+		vals = append(vals, v)
+		DBs = append(DBs, MakeDB(num, d, &p, v))
+	}
+
+	shard_p := p
+	D, err := pi.ConcatDBsChecked(DBs, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if D.Info.Num != 2*capacity+capacity/3 || p.L != 3*shard_p.L {
+		t.Fatalf("got %d entries in %d rows", D.Info.Num, p.L)
+	}
+	for j, v := range vals {
+		for _, i := range []uint64{0, uint64(len(v)) - 1} {
+			if got := D.GetElem(uint64(j)*capacity + i); got != v[i] {
+				t.Fatalf("DB %d, index %d: got %d instead of %d", j, i, got, v[i])
+			}
+		}
+	}
+	if got := D.GetElem(capacity + capacity/2); got != 0 {
+		t.Fatalf("unused index: got %d", got)
+	}
+
+	// The concatenated DB can be split back into the same shards.
+	shards, _, layout, err := SplitDB(D, p, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index, err := layout.Index(2, 5); err != nil || index != 2*capacity+5 {
+		t.Fatalf("got index %d, %v", index, err)
+	}
+	if !sameMatrix(shards[1].Data, DBs[1].Data) {
+		t.Fatal("second shard differs from the DB it was built from")
+	}
+
+	bad := MakeDB(4, 16, &shard_p, make([]uint64, 4))
+	if _, err := pi.ConcatDBsChecked([]*Database{DBs[0], bad}, &shard_p); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("mismatched entry layouts: got %v", err)
+	}
+}
+
+// An answer from a range of rows matches those rows of the full answer.
+func TestAnswerRows(t *testing.T) {
+	pi := &SimplePIR{}
+	N, d := uint64(1<<14), uint64(8)
+	p := pi.PickParams(N, d, SEC_PARAM, LOGQ)
+	server, err := NewServer(pi, MakeRandomDB(N, d, &p), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, query, err := client.Query(N / 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := server.Answer(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, r := range [][2]uint64{{0, p.L}, {3, 17}, {p.L - 1, p.L}} {
+		a, err := server.AnswerRowsContext(ctx, query.Data[0], r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameMatrix(a.Data[0], full.Data[0].RowsDeepCopy(r[0], r[1]-r[0])) {
+			t.Fatalf("rows [%d, %d) differ from the full answer", r[0], r[1])
+		}
+	}
+	if _, err := server.AnswerRowsContext(ctx, query.Data[0], 5, p.L+1); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("rows past the end: got %v", err)
+	}
+
+	dpi := &DoublePIR{}
+	dp := dpi.PickParams(N, d, SEC_PARAM, LOGQ)
+	dserver, err := NewServer(dpi, MakeRandomDB(N, d, &dp), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserver.AnswerRowsContext(ctx, query.Data[0], 0, 1); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("DoublePIR: got %v", err)
+	}
+}
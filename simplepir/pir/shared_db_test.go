@@ -0,0 +1,87 @@
+package pir
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMatrixMulVecPackedParallel(t *testing.T) {
+	prg := RandomBufPRG()
+	for _, rows := range []uint64{1, 8, 13, 100, 1001} {
+		a := MatrixRand(prg, rows, 30, 0, 512)
+		a.Squish(10, 3)
+		b := MatrixRand(prg, a.Cols*3, 1, 32, 0)
+
+		expected := MatrixMulVecPacked(a, b, 10, 3)
+		for _, workers := range []int{1, 2, 3, 16} {
+			got := MatrixMulVecPackedParallel(a, b, 10, 3, workers)
+			if !sameMatrix(got, expected) {
+				t.Fatalf("%d rows, %d workers: result differs from single-threaded", rows, workers)
+			}
+		}
+	}
+}
+
+// Many clients query one server at the same time.
+func testConcurrentAnswers(t *testing.T, pi CheckedPIR, N, d uint64, p Params) {
+	DB := MakeRandomDB(N, d, &p)
+	orig := DB.Copy()
+
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMatrix(DB.Data, orig.Data) || DB.Info != orig.Info {
+		t.Fatal("NewServer modified the database")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for c := uint64(0); c < 4; c++ {
+		wg.Add(1)
+		go func(c uint64) {
+			defer wg.Done()
+			client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+			if err != nil {
+				errs <- err
+				return
+			}
+			i := (c * 7919) % orig.Info.Num
+			pending, query, err := client.Query(i)
+			if err != nil {
+				errs <- err
+				return
+			}
+			answer, err := server.Answer(query)
+			if err != nil {
+				errs <- err
+				return
+			}
+			vals, err := client.Recover(pending, answer)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if vals[0] != orig.GetElem(i) {
+				t.Errorf("client %d, index %d: got %d instead of %d", c, i, vals[0], orig.GetElem(i))
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestSimplePirConcurrentAnswers(t *testing.T) {
+	pi := &SimplePIR{}
+	testConcurrentAnswers(t, pi, 1<<16, 8, pi.PickParams(1<<16, 8, SEC_PARAM, LOGQ))
+}
+
+// PickParams' DoublePIR dims (COMP_RATIO·n columns) make each client's setup
+// take seconds, so use a square DB.
+func TestDoublePirConcurrentAnswers(t *testing.T) {
+	pi := &DoublePIR{}
+	testConcurrentAnswers(t, pi, 1<<12, 8, pi.PickParamsGivenDimensions(1<<6, 1<<6, SEC_PARAM, LOGQ))
+}
@@ -3,6 +3,7 @@ package pir
 // #cgo CFLAGS: -O3 -march=native
 // #include "pir.h"
 import "C"
+import "context"
 import "fmt"
 
 type SimplePIR struct{}
@@ -12,6 +13,49 @@ func (pi *SimplePIR) Name() string {
 }
 
 func (pi *SimplePIR) PickParams(N, d, n, logq uint64) Params {
+	p, err := pi.PickParamsChecked(N, d, n, logq)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (pi *SimplePIR) PickParamsChecked(N, d, n, logq uint64) (Params, error) {
+	return pi.pickParams(N, d, n, logq, 0, 0, FailureOptions{})
+}
+
+// Same as PickParamsChecked, but checks the decoding failure probability as
+// opts says.
+func (pi *SimplePIR) PickParamsWithOptions(N, d, n, logq uint64, opts FailureOptions) (Params, error) {
+	return pi.pickParams(N, d, n, logq, 0, 0, opts)
+}
+
+// Same as PickParamsChecked, for clients that download the hint rounded to
+// hint_logq bits per elem (see CompressHint). This takes a smaller p, and so
+// a taller DB, but shrinks the offline download by about logq/hint_logq.
+func (pi *SimplePIR) PickParamsCompressedHint(N, d, n, logq, hint_logq uint64) (Params, error) {
+	if hint_logq == 0 || hint_logq > logq {
+		return Params{}, fmt.Errorf("%w: hint of %d bits, logq=%d", ErrBadParams, hint_logq, logq)
+	}
+	return pi.pickParams(N, d, n, logq, hint_logq, 0, FailureOptions{})
+}
+
+// Same as PickParamsChecked, for a server that rounds each answer elem to
+// answer_logq bits before sending it, and clients that download the hint
+// rounded to hint_logq bits per elem; either may be 0, for no rounding.
+func (pi *SimplePIR) PickParamsCompressed(N, d, n, logq, hint_logq, answer_logq uint64) (Params, error) {
+	if hint_logq > logq || answer_logq > logq {
+		return Params{}, fmt.Errorf("%w: hint of %d bits, answer of %d bits, logq=%d", ErrBadParams,
+			hint_logq, answer_logq, logq)
+	}
+	return pi.pickParams(N, d, n, logq, hint_logq, answer_logq, FailureOptions{})
+}
+
+func (pi *SimplePIR) pickParams(N, d, n, logq, hint_logq, answer_logq uint64, opts FailureOptions) (Params, error) {
+	if N == 0 || d == 0 {
+		return Params{}, ErrEmptyDB
+	}
+
 	good_p := Params{}
 	found := false
 
@@ -20,27 +64,29 @@ func (pi *SimplePIR) PickParams(N, d, n, logq uint64) Params {
 		l, m := ApproxSquareDatabaseDims(N, d, mod_p)
 
 		p := Params{
-			N:    n,
-			Logq: logq,
-			L:    l,
-			M:    m,
+			N:          n,
+			Logq:       logq,
+			L:          l,
+			M:          m,
+			HintLogq:   hint_logq,
+			AnswerLogq: answer_logq,
 		}
-		p.PickParams(false, m)
+		err := p.PickParamsWithOptions(false, opts, m)
 
-		if p.P < mod_p {
+		if err != nil || p.P < mod_p {
 			if !found {
-				panic("Error; should not happen")
+				if err == nil {
+					err = ErrInvalidParams
+				}
+				return Params{}, err
 			}
 			good_p.PrintParams()
-			return good_p
+			return good_p, nil
 		}
 
 		good_p = p
 		found = true
 	}
-
-	panic("Cannot be reached")
-	return Params{}
 }
 
 func (pi *SimplePIR) PickParamsGivenDimensions(l, m, n, logq uint64) Params {
@@ -57,47 +103,65 @@ func (pi *SimplePIR) PickParamsGivenDimensions(l, m, n, logq uint64) Params {
 // Works for SimplePIR because vertical concatenation doesn't increase
 // the number of LWE samples (so don't need to change LWE params)
 func (pi *SimplePIR) ConcatDBs(DBs []*Database, p *Params) *Database {
-        if len(DBs) == 0 {
-                panic("Should not happen")
-        }
-
-        if DBs[0].Info.Num != p.L * p.M {
-                panic("Not yet implemented")
-        }
+	D, err := pi.ConcatDBsChecked(DBs, p)
+	if err != nil {
+		panic(err)
+	}
+	return D
+}
 
-        rows := DBs[0].Data.Rows
-        for j:=1; j<len(DBs); j++ {
-                if DBs[j].Data.Rows != rows {
-                        panic("Bad input")
-                }
-        }
+// Same as ConcatDBs, but returns an error instead of panicking. Every DB
+// must be built for p, but may hold fewer entries than fit in p (e.g., the
+// last shard of a dataset); the entries of DBs[j] then start at index j times
+// dbCapacity(p), and the unused indices in between read as 0.
+func (pi *SimplePIR) ConcatDBsChecked(DBs []*Database, p *Params) (*Database, error) {
+	if len(DBs) == 0 {
+		return nil, ErrEmptyDB
+	}
 
-        D := new(Database)
-        D.Data = MatrixZeros(0, 0)
-        D.Info = DBs[0].Info
-        D.Info.Num *= uint64(len(DBs))
-        p.L *= uint64(len(DBs))
+	info := DBs[0].Info
+	for j, DB := range DBs {
+		if DB == nil || DB.Data == nil {
+			return nil, ErrEmptyDB
+		}
+		if DB.Info.Squishing != 0 {
+			return nil, ErrAlreadySetup
+		}
+		if !hasDims(DB.Data, p.L, p.M) {
+			return nil, fmt.Errorf("%w: DB %d is %d-by-%d, params are %d-by-%d", ErrDBSizeMismatch,
+				j, DB.Data.Rows, DB.Data.Cols, p.L, p.M)
+		}
+		if DB.Info.P != info.P || DB.Info.Ne != info.Ne || DB.Info.Packing != info.Packing ||
+			DB.Info.Row_length != info.Row_length {
+			return nil, fmt.Errorf("%w: DB %d has a different entry layout", ErrBadInput, j)
+		}
+	}
 
-	for j:=0; j<len(DBs); j++ {
-		D.Data.Concat(DBs[j].Data.SelectRows(0, rows))
+	D := new(Database)
+	D.Data = MatrixZeros(0, 0)
+	D.Info = info
+	D.Info.Num = uint64(len(DBs)-1)*dbCapacity(*p, info) + DBs[len(DBs)-1].Info.Num
+	for _, DB := range DBs {
+		D.Data.Concat(DB.Data.RowsDeepCopy(0, p.L))
 	}
+	p.L *= uint64(len(DBs))
 
-        return D
+	return D, nil
 }
 
 func (pi *SimplePIR) GetBW(info DBinfo, p Params) {
-	offline_download := float64(p.L*p.N*p.Logq) / (8.0 * 1024.0)
+	offline_download := float64(p.L*p.N*p.HintBits()) / (8.0 * 1024.0)
 	fmt.Printf("\t\tOffline download: %d KB\n", uint64(offline_download))
 
 	online_upload := float64(p.M*p.Logq) / (8.0 * 1024.0)
 	fmt.Printf("\t\tOnline upload: %d KB\n", uint64(online_upload))
 
-	online_download := float64(p.L*p.Logq) / (8.0 * 1024.0)
+	online_download := float64(p.L*p.AnswerBits()) / (8.0 * 1024.0)
 	fmt.Printf("\t\tOnline download: %d KB\n", uint64(online_download))
 }
 
-func (pi *SimplePIR) Init(info DBinfo, p Params) State {
-        A := MatrixRand(p.M, p.N, p.Logq, 0)
+func (pi *SimplePIR) Init(info DBinfo, p Params, prg *BufPRGReader) State {
+        A := MatrixRand(prg, p.M, p.N, p.Logq, 0)
         return MakeState(A)
 }
 
@@ -107,18 +171,26 @@ func (pi *SimplePIR) InitCompressed(info DBinfo, p Params) (State, CompressedSta
 }
 
 func (pi *SimplePIR) InitCompressedSeeded(info DBinfo, p Params, seed *PRGKey) (State, CompressedState) {
-        bufPrgReader = NewBufPRG(NewPRG(seed))
-        return pi.Init(info, p), MakeCompressedState(seed)
+        return pi.Init(info, p, NewBufPRG(NewPRG(seed))), MakeCompressedState(seed)
 }
 
 func (pi *SimplePIR) DecompressState(info DBinfo, p Params, comp CompressedState) State {
-	bufPrgReader = NewBufPRG(NewPRG(comp.Seed))
-	return pi.Init(info, p)
+	return pi.Init(info, p, NewBufPRG(NewPRG(comp.Seed)))
 }
 
 func (pi *SimplePIR) Setup(DB *Database, shared State, p Params) (State, Msg) {
+	server, offline, _ := pi.setup(noProgress(), DB, shared, p)
+	return server, offline
+}
+
+// Only fails if r's context is cancelled, in which case DB is left untouched.
+func (pi *SimplePIR) setup(r *rowProgress, DB *Database, shared State, p Params) (State, Msg, error) {
 	A := shared.Data[0]
-	H := MatrixMul(DB.Data, A)
+	r.total = DB.Data.Rows
+	H, err := matrixMulContext(r, DB.Data, A)
+	if err != nil {
+		return State{}, Msg{}, err
+	}
 
 	// map the database entries to [0, p] (rather than [-p/1, p/2]) and then
 	// pack the database more tightly in memory, because the online computation
@@ -126,11 +198,11 @@ func (pi *SimplePIR) Setup(DB *Database, shared State, p Params) (State, Msg) {
 	DB.Data.Add(p.P / 2)
 	DB.Squish()
 
-	return MakeState(), MakeMsg(H)
+	return MakeState(), MakeMsg(H), nil
 }
 
 func (pi *SimplePIR) FakeSetup(DB *Database, p Params) (State, float64) {
-	offline_download := float64(p.L*p.N*uint64(p.Logq)) / (8.0 * 1024.0)
+	offline_download := float64(p.L*p.N*p.HintBits()) / (8.0 * 1024.0)
 	fmt.Printf("\t\tOffline download: %d KB\n", uint64(offline_download))
 
 	// map the database entries to [0, p] (rather than [-p/1, p/2]) and then
@@ -142,24 +214,81 @@ func (pi *SimplePIR) FakeSetup(DB *Database, p Params) (State, float64) {
 	return MakeState(), offline_download
 }
 
-func (pi *SimplePIR) Query(i uint64, shared State, p Params, info DBinfo) (State, Msg) {
+func (pi *SimplePIR) Query(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg) {
+	secret, query := pi.unindexedQuery(shared, p, info, prg)
+	query.Data[elemIndex(i, info)%p.M] += C.Elem(p.Delta())
+	return MakeState(secret), MakeMsg(query)
+}
+
+// Returns a secret and the query A·secret + error, padded to the width of the
+// compressed DB, which only lacks Delta at the queried index.
+func (pi *SimplePIR) unindexedQuery(shared State, p Params, info DBinfo, prg *BufPRGReader) (*Matrix, *Matrix) {
 	A := shared.Data[0]
 
-	secret := MatrixRand(p.N, 1, p.Logq, 0)
-	err := MatrixGaussian(p.M, 1)
+	var secret *Matrix
+	if p.HintBits() < p.Logq {
+		// keeps the error from the rounded hint small (see modswitch.go)
+		secret = MatrixGaussianSigma(prg, p.N, 1, p.Sigma)
+	} else {
+		secret = MatrixRand(prg, p.N, 1, p.Logq, 0)
+	}
+	err := MatrixGaussianSigma(prg, p.M, 1, p.Sigma)
 	query := MatrixMul(A, secret)
 	query.MatrixAdd(err)
-	query.Data[i%p.M] += C.Elem(p.Delta())
 
 	// Pad the query to match the dimensions of the compressed DB
 	if p.M%info.Squishing != 0 {
 		query.AppendZeros(info.Squishing - (p.M % info.Squishing))
 	}
 
-	return MakeState(secret), MakeMsg(query)
+	return secret, query
+}
+
+// Returns H·secret, scaled back up to Z_q if the hint is rounded.
+func hintTimesSecret(H *Matrix, secret *Matrix, p Params) *Matrix {
+	interm := MatrixMul(H, secret)
+	if bits := p.HintBits(); bits < p.Logq {
+		// scale the rounded hint back up to Z_q
+		interm.MulConst(1 << (p.Logq - bits))
+	}
+	return interm
+}
+
+// Builds a query for an index still to be chosen (see QueryPrecomputer). The
+// client state also holds H·secret, which Recover then does not recompute.
+func (pi *SimplePIR) PrecomputeQuery(shared State, offline Msg, p Params, info DBinfo,
+	prg *BufPRGReader) (State, Msg, error) {
+	if len(shared.Data) < 1 || !hasDims(shared.Data[0], p.M, p.N) {
+		return State{}, Msg{}, fmt.Errorf("%w: expected %d-by-%d matrix A", ErrBadState, p.M, p.N)
+	}
+	if len(offline.Data) != 1 || offline.Data[0].Cols != p.N {
+		return State{}, Msg{}, fmt.Errorf("%w: expected hint with %d columns", ErrBadState, p.N)
+	}
+
+	secret, query := pi.unindexedQuery(shared, p, info, prg)
+	return MakeState(secret, hintTimesSecret(offline.Data[0], secret, p)), MakeMsg(query), nil
+}
+
+func (pi *SimplePIR) FinishQuery(i uint64, query Msg, p Params, info DBinfo) error {
+	if err := checkIndex(i, p, info); err != nil {
+		return err
+	}
+	if len(query.Data) != 1 || query.Data[0].Cols != 1 || query.Data[0].Rows < p.M {
+		return fmt.Errorf("%w: expected query of dimension %d", ErrBadQuery, p.M)
+	}
+	query.Data[0].Data[elemIndex(i, info)%p.M] += C.Elem(p.Delta())
+	return nil
 }
 
 func (pi *SimplePIR) Answer(DB *Database, query MsgSlice, server State, shared State, p Params) Msg {
+	ans, _ := pi.answer(noProgress(), DB, query, p, 1)
+	return ans
+}
+
+// Only reads from DB, so it is safe to call concurrently on a shared DB.
+// Only fails if r's context is cancelled.
+func (pi *SimplePIR) answer(r *rowProgress, DB *Database, query MsgSlice, p Params, workers int) (Msg, error) {
+	r.total = DB.Data.Rows
 	ans := new(Matrix)
 	num_queries := uint64(len(query.Data)) // number of queries in the batch of queries
 	batch_sz := DB.Data.Rows / num_queries // how many rows of the database each query in the batch maps to
@@ -171,19 +300,30 @@ func (pi *SimplePIR) Answer(DB *Database, query MsgSlice, server State, shared S
 		if batch == int(num_queries-1) {
 			batch_sz = DB.Data.Rows - last
 		}
-		a := MatrixMulVecPacked(DB.Data.SelectRows(last, batch_sz),
+		a, err := matrixMulVecPackedContext(r, DB.Data.SelectRows(last, batch_sz),
 			q.Data[0],
 			DB.Info.Basis,
-			DB.Info.Squishing)
+			DB.Info.Squishing,
+			workers)
+		if err != nil {
+			return Msg{}, err
+		}
 		ans.Concat(a)
 		last += batch_sz
 	}
 
-	return MakeMsg(ans)
+	return MakeMsg(compressAnswer(ans, p)), nil
 }
 
 func (pi *SimplePIR) Recover(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
 	shared State, client State, p Params, info DBinfo) uint64 {
+	vals := pi.recoverElems(i, offline, query, answer, client, p, info)
+	return ReconstructElem(vals, i, info)
+}
+
+// Returns the (still mapped to [-p/2, p/2]) Z_p elems that make up DB entry i.
+func (pi *SimplePIR) recoverElems(i uint64, offline Msg, query Msg, answer Msg, client State,
+	p Params, info DBinfo) []uint64 {
 	secret := client.Data[0]
 	H := offline.Data[0]
 	ans := answer.Data[0]
@@ -193,11 +333,21 @@ func (pi *SimplePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 	for j := uint64(0); j<p.M; j++ {
         	offset += ratio*query.Data[0].Get(j,0)
 	}
-	offset %= (1 << p.Logq)
-	offset = (1 << p.Logq)-offset
+	offset = negModQ(offset, p.Logq)
 
-	row := i / p.M
-	interm := MatrixMul(H, secret)
+	if bits := p.AnswerBits(); bits < p.Logq {
+		// scale the rounded answer back up to Z_q
+		ans = ans.RowsDeepCopy(0, ans.Rows)
+		ans.MulConst(1 << (p.Logq - bits))
+	}
+
+	row := elemIndex(i, info) / p.M
+	var interm *Matrix
+	if len(client.Data) > 1 {
+		interm = client.Data[1] // precomputed by PrecomputeQuery
+	} else {
+		interm = hintTimesSecret(H, secret, p)
+	}
 	ans.MatrixSub(interm)
 
 	var vals []uint64
@@ -210,7 +360,7 @@ func (pi *SimplePIR) Recover(i uint64, batch_index uint64, offline Msg, query Ms
 	}
 	ans.MatrixAdd(interm)
 
-	return ReconstructElem(vals, i, info)
+	return vals
 }
 
 func (pi *SimplePIR) Reset(DB *Database, p Params) {
@@ -218,3 +368,170 @@ func (pi *SimplePIR) Reset(DB *Database, p Params) {
 	DB.Unsquish()
 	DB.Data.Sub(p.P / 2)
 }
+
+
+func (pi *SimplePIR) SetupChecked(DB *Database, shared State, p Params) (State, Msg, error) {
+	if err := pi.checkSetup(DB, shared, p); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	server, offline := pi.Setup(DB, shared, p)
+	return server, offline, nil
+}
+
+func (pi *SimplePIR) SetupContext(ctx context.Context, DB *Database, shared State, p Params,
+	progress ProgressFunc) (State, Msg, error) {
+	if err := pi.checkSetup(DB, shared, p); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	return pi.setup(newRowProgress(ctx, progress), DB, shared, p)
+}
+
+func (pi *SimplePIR) checkSetup(DB *Database, shared State, p Params) error {
+	if err := checkSetupDB(DB, p); err != nil {
+		return err
+	}
+	if p.HintLogq > p.Logq || p.AnswerLogq > p.Logq {
+		return fmt.Errorf("%w: hint of %d bits, answer of %d bits, logq=%d", ErrBadParams,
+			p.HintLogq, p.AnswerLogq, p.Logq)
+	}
+	if len(shared.Data) < 1 || !hasDims(shared.Data[0], p.M, p.N) {
+		return fmt.Errorf("%w: expected %d-by-%d matrix A", ErrBadState, p.M, p.N)
+	}
+	return nil
+}
+
+func (pi *SimplePIR) QueryChecked(i uint64, shared State, p Params, info DBinfo, prg *BufPRGReader) (State, Msg, error) {
+	if len(shared.Data) < 1 || !hasDims(shared.Data[0], p.M, p.N) {
+		return State{}, Msg{}, fmt.Errorf("%w: expected %d-by-%d matrix A", ErrBadState, p.M, p.N)
+	}
+	if err := checkIndex(i, p, info); err != nil {
+		return State{}, Msg{}, err
+	}
+
+	client, query := pi.Query(i, shared, p, info, prg)
+	return client, query, nil
+}
+
+func (pi *SimplePIR) AnswerChecked(DB *Database, query MsgSlice, server State, shared State, p Params) (Msg, error) {
+	return pi.AnswerParallel(DB, query, server, shared, p, 1)
+}
+
+func (pi *SimplePIR) AnswerParallel(DB *Database, query MsgSlice, server State, shared State, p Params,
+	workers int) (Msg, error) {
+	return pi.AnswerContext(context.Background(), DB, query, server, shared, p, workers, nil)
+}
+
+func (pi *SimplePIR) AnswerContext(ctx context.Context, DB *Database, query MsgSlice, server State,
+	shared State, p Params, workers int, progress ProgressFunc) (Msg, error) {
+	if err := checkAnswerDB(DB, query); err != nil {
+		return Msg{}, err
+	}
+	for batch, q := range query.Data {
+		if len(q.Data) != 1 || !hasDims(q.Data[0], DB.Data.Cols*DB.Info.Squishing, 1) {
+			return Msg{}, fmt.Errorf("%w: query %d does not match %d-column database",
+				ErrBadQuery, batch, DB.Info.Cols)
+		}
+	}
+
+	return pi.answer(newRowProgress(ctx, progress), DB, query, p, workers)
+}
+
+func (pi *SimplePIR) AnswerRows(ctx context.Context, DB *Database, query Msg, start, end uint64, p Params,
+	workers int) (Msg, error) {
+	if err := checkAnswerDB(DB, MakeMsgSlice(query)); err != nil {
+		return Msg{}, err
+	}
+	if len(query.Data) != 1 || !hasDims(query.Data[0], DB.Data.Cols*DB.Info.Squishing, 1) {
+		return Msg{}, fmt.Errorf("%w: query does not match %d-column database", ErrBadQuery, DB.Info.Cols)
+	}
+	if start >= end || end > DB.Data.Rows {
+		return Msg{}, fmt.Errorf("%w: rows [%d, %d) of a %d-row database", ErrBadInput, start, end,
+			DB.Data.Rows)
+	}
+
+	r := newRowProgress(ctx, nil)
+	r.total = end - start
+	ans, err := matrixMulVecPackedContext(r, DB.Data.SelectRows(start, end-start), query.Data[0],
+		DB.Info.Basis, DB.Info.Squishing, workers)
+	if err != nil {
+		return Msg{}, err
+	}
+	return MakeMsg(compressAnswer(ans, p)), nil
+}
+
+func (pi *SimplePIR) RecoverChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) (uint64, error) {
+	if err := pi.checkRecover(i, offline, query, answer, client, p, info); err != nil {
+		return 0, err
+	}
+
+	return pi.Recover(i, batch_index, offline, query, answer, shared, client, p, info), nil
+}
+
+func (pi *SimplePIR) RecoverElemsChecked(i uint64, batch_index uint64, offline Msg, query Msg, answer Msg,
+	shared State, client State, p Params, info DBinfo) ([]uint64, error) {
+	if err := pi.checkRecover(i, offline, query, answer, client, p, info); err != nil {
+		return nil, err
+	}
+
+	return unmapElems(pi.recoverElems(i, offline, query, answer, client, p, info), info), nil
+}
+
+func (pi *SimplePIR) checkRecover(i uint64, offline Msg, query Msg, answer Msg, client State,
+	p Params, info DBinfo) error {
+	if err := checkIndex(i, p, info); err != nil {
+		return err
+	}
+	if len(client.Data) < 1 || len(client.Data) > 2 || !hasDims(client.Data[0], p.N, 1) {
+		return fmt.Errorf("%w: expected secret of dimension %d", ErrBadState, p.N)
+	}
+	if len(query.Data) != 1 || query.Data[0].Cols != 1 || query.Data[0].Rows < p.M {
+		return fmt.Errorf("%w: expected query of dimension %d", ErrBadQuery, p.M)
+	}
+	if len(offline.Data) != 1 || offline.Data[0].Cols != p.N {
+		return fmt.Errorf("%w: expected hint with %d columns", ErrBadAnswer, p.N)
+	}
+	H := offline.Data[0]
+	if len(answer.Data) != 1 || !hasDims(answer.Data[0], H.Rows, 1) {
+		return fmt.Errorf("%w: expected answer of dimension %d", ErrBadAnswer, H.Rows)
+	}
+	if len(client.Data) == 2 && !hasDims(client.Data[1], H.Rows, 1) {
+		return fmt.Errorf("%w: expected H·secret of dimension %d", ErrBadState, H.Rows)
+	}
+	if (elemIndex(i, info)/p.M+1)*info.Ne > H.Rows {
+		return fmt.Errorf("%w: index %d, hint has %d rows", ErrIndexOutOfRange, i, H.Rows)
+	}
+	return nil
+}
+
+// Updates H = DB·A by delta·A[col] for every DB elem (row, col) that changed by delta.
+func (pi *SimplePIR) UpdateChecked(DB *Database, updates []Update, shared State, offline Msg,
+	p Params) (Msg, HintPatch, error) {
+	if err := DB.checkUpdates(updates); err != nil {
+		return Msg{}, HintPatch{}, err
+	}
+	if len(shared.Data) < 1 || !hasDims(shared.Data[0], p.M, p.N) {
+		return Msg{}, HintPatch{}, fmt.Errorf("%w: expected %d-by-%d matrix A", ErrBadState, p.M, p.N)
+	}
+	if len(offline.Data) != 1 || !hasDims(offline.Data[0], p.L, p.N) {
+		return Msg{}, HintPatch{}, fmt.Errorf("%w: expected %d-by-%d hint", ErrBadState, p.L, p.N)
+	}
+
+	A := shared.Data[0]
+	H := offline.Data[0].RowsDeepCopy(0, p.L)
+	changed := make(map[uint64]bool)
+	for _, u := range updates {
+		for _, c := range DB.setEntry(u.Index, u.Value) {
+			h := H.Data[c.row*p.N : (c.row+1)*p.N]
+			a := A.Data[c.col*p.N : (c.col+1)*p.N]
+			for k := range h {
+				h[k] += c.delta * a[k]
+			}
+			changed[c.row] = true
+		}
+	}
+
+	return MakeMsg(H), makeHintPatch(H, changed), nil
+}
@@ -0,0 +1,149 @@
+package pir
+
+import (
+	"errors"
+	"testing"
+)
+
+// Updates DB entries on a running server; a client that applies the hint
+// patch then reads the new values, and the patched hint matches a fresh Setup.
+// The hint is rounded to hint_logq bits per elem, unless hint_logq is 0.
+func testHintUpdates(t *testing.T, N, d, hint_logq uint64) {
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsChecked(N, d, SEC_PARAM, LOGQ)
+	if hint_logq != 0 {
+		p, err = pi.PickParamsCompressedHint(N, d, SEC_PARAM, LOGQ, hint_logq)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := uint64(1)<<d - 1
+	prg := RandomBufPRG()
+	vals := make([]uint64, N)
+	for i := range vals {
+		vals[i] = prg.Uint64() & mask
+	}
+	DB := MakeDB(N, d, &p, vals)
+	server, err := NewServer(pi, DB, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), server.Hint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old_hint := server.Hint().Data[0].RowsDeepCopy(0, p.L)
+
+	// Index 1 is updated twice, to check that the last update wins.
+	updates := []Update{{0, 1}, {1, 5}, {N / 2, vals[N/2] ^ 1}, {1, 7}, {N - 1, mask}}
+	for _, u := range updates {
+		vals[u.Index] = u.Value
+	}
+
+	patch, err := server.Update(updates...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(patch.Rows)) > uint64(len(updates))*DB.Info.Ne {
+		t.Fatalf("patch has %d rows for %d updates", len(patch.Rows), len(updates))
+	}
+	if !sameMatrix(client.Hint().Data[0], old_hint) {
+		t.Fatal("Update modified a hint handed out before it")
+	}
+
+	buf, err := patch.Marshal(p.HintBits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HintPatch
+	if err := got.Unmarshal(buf, p.HintBits()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ApplyHintPatch(got); err != nil {
+		t.Fatal(err)
+	}
+	if !sameMatrix(client.Hint().Data[0], server.Hint().Data[0]) {
+		t.Fatal("patched hint differs from the server's")
+	}
+
+	fresh := MakeDB(N, d, &p, vals)
+	_, hint := pi.Setup(fresh, pi.DecompressState(DB.Info, p, server.Seed()), p)
+	if !sameMatrix(CompressHint(hint, p).Data[0], server.Hint().Data[0]) {
+		t.Fatal("updated hint differs from a fresh Setup")
+	}
+
+	for _, i := range []uint64{0, 1, 2, N / 2, N - 1} {
+		pending, query, err := client.Query(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := server.Answer(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Recover(pending, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res[0] != vals[i] {
+			t.Fatalf("index %d: got %d instead of %d", i, res[0], vals[i])
+		}
+	}
+}
+
+func TestSimplePirHintUpdates(t *testing.T) {
+	testHintUpdates(t, 1<<16, 8, 0)
+}
+
+func TestSimplePirCompressedHintUpdates(t *testing.T) {
+	testHintUpdates(t, 1<<16, 8, LOGQ/2)
+}
+
+func TestSimplePirHintUpdatesLongRow(t *testing.T) {
+	testHintUpdates(t, 1<<12, 32, 0)
+}
+
+func TestHintUpdatesRejectBadInput(t *testing.T) {
+	pi := &SimplePIR{}
+	p, err := pi.PickParamsChecked(1<<12, 8, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pi, MakeRandomDB(1<<12, 8, &p), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hint := server.Hint()
+
+	if _, err := server.Update(Update{1 << 12, 0}); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("index out of range: got %v", err)
+	}
+	if _, err := server.Update(Update{0, 0}, Update{1, 256}); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("value too large: got %v", err)
+	}
+	if server.Hint().Data[0] != hint.Data[0] {
+		t.Fatal("failed Update changed the hint")
+	}
+
+	client, err := NewClient(pi, server.Params(), server.DBInfo(), server.Seed(), hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := HintPatch{Rows: []uint64{p.L}, Data: MatrixZeros(1, p.N)}
+	if err := client.ApplyHintPatch(bad); !errors.Is(err, ErrBadInput) {
+		t.Fatalf("patch row out of range: got %v", err)
+	}
+
+	dpi := &DoublePIR{}
+	dp, err := dpi.PickParamsChecked(1<<12, 8, SEC_PARAM, LOGQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserver, err := NewServer(dpi, MakeRandomDB(1<<12, 8, &dp), dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserver.Update(Update{0, 0}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("DoublePIR: got %v", err)
+	}
+}